@@ -1,27 +0,0 @@
-package main
-
-import (
-	"net/http/httputil"
-	"net/url"
-	"sync"
-)
-
-type Backend struct {
-	Url          *url.URL
-	Alive        bool
-	mux          sync.RWMutex
-	ReverseProxy *httputil.ReverseProxy
-}
-
-func (b *Backend) SetAlive(alive bool) {
-	b.mux.Lock()
-	b.Alive = alive
-	b.mux.Unlock()
-}
-
-func (b *Backend) IsAlive() bool {
-	b.mux.RLock()
-	defer b.mux.RUnlock()
-	return b.Alive
-}
-
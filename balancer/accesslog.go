@@ -0,0 +1,110 @@
+package balancer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// accessRecord collects what the access log needs to know about a request
+// beyond what the response writer sees.
+type accessRecord struct {
+	backend string
+}
+
+// setAccessBackend notes the backend serving r; after a failover the last
+// one wins.
+func setAccessBackend(r *http.Request, b *Backend) {
+	if rec, ok := r.Context().Value(AccessLog).(*accessRecord); ok {
+		rec.backend = backendHost(b.Url)
+	}
+}
+
+// statusWriter captures the status code and body size of a response. Unwrap
+// lets http.ResponseController reach the Flusher and Hijacker underneath.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// openAccessLog returns the destination of -access-log: stdout for "-" or
+// "stdout", otherwise the named file opened for appending.
+func openAccessLog(dest string) (io.Writer, error) {
+	if dest == "-" || dest == "stdout" {
+		return os.Stdout, nil
+	}
+	return os.OpenFile(dest, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+}
+
+// withAccessLog writes one line per request to out in the Combined Log
+// Format, followed by the quoted host of the backend that served it and the
+// request ID. Clients are logged at their address behind the trusted proxies.
+func withAccessLog(out io.Writer, trusted proxyPrefixes, h http.Handler) http.Handler {
+	var mux sync.Mutex
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &accessRecord{}
+		sw := &statusWriter{ResponseWriter: w}
+		h.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), AccessLog, rec)))
+
+		status := sw.status
+		switch {
+		case status == 0 && isUpgrade(r):
+			//the proxy writes the 101 to the hijacked connection itself
+			status = http.StatusSwitchingProtocols
+		case status == 0:
+			status = http.StatusOK
+		}
+		size := "-"
+		if sw.bytes > 0 {
+			size = strconv.FormatInt(sw.bytes, 10)
+		}
+		line := fmt.Sprintf("%s - %s [%s] %q %d %s %q %q %q %q\n",
+			trusted.clientIP(r), orDash(userName(r)), start.Format("02/Jan/2006:15:04:05 -0700"),
+			r.Method+" "+r.RequestURI+" "+r.Proto, status, size,
+			orDash(r.Referer()), orDash(r.UserAgent()), orDash(rec.backend), orDash(GetRequestIDFromContext(r)))
+		mux.Lock()
+		io.WriteString(out, line)
+		mux.Unlock()
+	})
+}
+
+func userName(r *http.Request) string {
+	if user, _, ok := r.BasicAuth(); ok {
+		return user
+	}
+	return ""
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
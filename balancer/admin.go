@@ -0,0 +1,224 @@
+package balancer
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"gopkg.in/yaml.v3"
+)
+
+// AdminTokenHeader carries the shared secret of the admin API.
+const AdminTokenHeader = "X-Admin-Token"
+
+// requireAdmin rejects requests that do not carry the shared admin token.
+func requireAdmin(token string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given := r.Header.Get(AdminTokenHeader)
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// backendsHandler registers (POST) and deregisters (DELETE ?url=) backends
+// of the pool named by the pool query parameter, the default pool if unset.
+func (l *LoadBalancer) backendsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		w.Header().Set("Allow", "POST, DELETE")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	pool := l.requestPool(w, r)
+	if pool == nil {
+		return
+	}
+	if r.Method == http.MethodPost {
+		addBackendHandler(w, r, pool)
+	} else {
+		removeBackendHandler(w, r, pool)
+	}
+}
+
+// requestPool returns the pool named by the pool query parameter, the default
+// pool if unset, or responds 404 and returns nil.
+func (l *LoadBalancer) requestPool(w http.ResponseWriter, r *http.Request) *ServerPool {
+	name := r.URL.Query().Get("pool")
+	if name == "" {
+		name = defaultPool
+	}
+	pool := l.router.Pool(name)
+	if pool == nil {
+		http.Error(w, "Unknown pool", http.StatusNotFound)
+	}
+	return pool
+}
+
+// requestBackendUrl returns the normalized url query parameter, or responds
+// 400 and returns "".
+func requestBackendUrl(w http.ResponseWriter, r *http.Request) string {
+	backendUrl, err := normalizeBackendUrl(r.URL.Query().Get("url"))
+	if err != nil {
+		http.Error(w, "Missing or invalid url parameter: "+err.Error(), http.StatusBadRequest)
+		return ""
+	}
+	return backendUrl
+}
+
+// drainHandler takes a backend (POST ?url=) out of rotation for maintenance,
+// or puts it back when drain is false. A draining backend gets no new
+// requests but keeps its health state and finishes those in flight.
+func (l *LoadBalancer) drainHandler(drain bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		pool := l.requestPool(w, r)
+		if pool == nil {
+			return
+		}
+		backendUrl := requestBackendUrl(w, r)
+		if backendUrl == "" {
+			return
+		}
+		b := pool.Backend(backendUrl)
+		if b == nil {
+			http.Error(w, "Unknown backend", http.StatusNotFound)
+			return
+		}
+		b.SetDraining(drain)
+		slog.Info("backend drain changed", "pool", pool.Name, "backend", b.Url.String(), "draining", drain, "active_connections", b.ActiveConnections(), "source", "admin")
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// configHandler (GET) returns the config last applied at startup or by a
+// reload as JSON, with the keys and duration format of the config file, and
+// the effective settings of every pool after inheritance. Settings that need
+// a restart show the value read by the last reload even if not applied yet.
+// Secrets are redacted.
+func (l *LoadBalancer) configHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	cfg := l.router.Config()
+	if cfg.AdminToken != "" {
+		cfg.AdminToken = redacted
+	}
+	out, err := toJSONValue(cfg)
+	if err == nil {
+		out.(map[string]interface{})["pools"], err = toJSONValue(cfg.PoolConfigs())
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(out)
+}
+
+const redacted = "REDACTED"
+
+// toJSONValue round trips v through YAML so it encodes to JSON with the yaml
+// keys and durations such as "10s" instead of nanoseconds.
+func toJSONValue(v interface{}) (interface{}, error) {
+	b, err := yaml.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var out interface{}
+	err = yaml.Unmarshal(b, &out)
+	return out, err
+}
+
+// healthStatus is the outcome of a triggered health check for one backend.
+type healthStatus struct {
+	Pool         string `json:"pool"`
+	Url          string `json:"url"`
+	Alive        bool   `json:"alive"`
+	HealthPassed int    `json:"health_passed"`
+	HealthFailed int    `json:"health_failed"`
+	LastError    string `json:"last_error,omitempty"`
+}
+
+// healthCheckTriggerHandler (POST) runs a health check of every pool right
+// away, after the one in progress if any, and returns the backend statuses.
+func (l *LoadBalancer) healthCheckTriggerHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	slog.Info("health check triggered", "source", "admin")
+	l.runHealthCheck(true)
+	statuses := []healthStatus{}
+	for _, pool := range l.router.Pools() {
+		for _, b := range pool.Backends() {
+			passed, failed := b.HealthCounts()
+			status := healthStatus{
+				Pool:         pool.Name,
+				Url:          b.Url.String(),
+				Alive:        b.IsAlive(),
+				HealthPassed: passed,
+				HealthFailed: failed,
+			}
+			if last, _, _ := b.HealthDetails(); last.Err != nil {
+				status.LastError = last.Err.Error()
+			}
+			statuses = append(statuses, status)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"backends": statuses})
+}
+
+func addBackendHandler(w http.ResponseWriter, r *http.Request, pool *ServerPool) {
+	var bc BackendConfig
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&bc); err != nil {
+		http.Error(w, "Invalid body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := bc.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := newBackend(bc, pool)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	//not routable until its first probe passes
+	if err := pool.AddBackend(b); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	slog.Info("added backend", "pool", pool.Name, "backend", b.Url.String(), "weight", b.Weight, "source", "admin")
+	go pool.probeBackends([]*Backend{b})
+	w.WriteHeader(http.StatusCreated)
+}
+
+func removeBackendHandler(w http.ResponseWriter, r *http.Request, pool *ServerPool) {
+	backendUrl := requestBackendUrl(w, r)
+	if backendUrl == "" {
+		return
+	}
+	b, ok := pool.RemoveBackend(backendUrl)
+	if !ok {
+		http.Error(w, "Unknown backend", http.StatusNotFound)
+		return
+	}
+	slog.Info("removed backend", "pool", pool.Name, "backend", b.Url.String(), "active_connections", b.ActiveConnections(), "source", "admin")
+	go b.release("admin")
+	w.WriteHeader(http.StatusNoContent)
+}
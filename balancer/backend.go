@@ -0,0 +1,169 @@
+package balancer
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Backend struct {
+	Url          *url.URL
+	Alive        bool
+	Weight       int
+	HealthPath   string
+	HealthStatus string
+	//HealthUrl is probed instead of Url when set
+	HealthUrl *url.URL
+	//PreserveHost and HostOverride override the pool's HostRewrite when set
+	PreserveHost   *bool
+	HostOverride   string
+	MaxConnections int
+	//Priority is the failover tier of the backend, 0 first
+	Priority     int
+	mux          sync.RWMutex
+	ReverseProxy *httputil.ReverseProxy
+
+	pool *ServerPool
+	//life ends once the backend is released after its removal
+	life      context.Context
+	stop      context.CancelFunc
+	transport http.RoundTripper
+	//tlsConfig is the TLS client config of the backend, nil for the default
+	tlsConfig   atomic.Pointer[tls.Config]
+	h2c         bool
+	id          string
+	connections int64
+	metrics     *backendMetrics
+	breaker     CircuitBreaker
+	latency     LatencyTracker
+	errors      errorRate
+	outlier     outlierState
+
+	passiveFailures int64
+	draining        int32
+
+	//weightOverride is set by WeightOverrides, nil when there is none
+	weightOverride atomic.Pointer[weightOverride]
+
+	//attempts that fail fast when the backend goes down, see trackWaiting
+	waitingMux sync.Mutex
+	waiting    map[*waitingRequest]struct{}
+
+	//consecutive health check results, the last probe and failed probe, and
+	//when the backend last came up and last went up or down, guarded by mux
+	healthPassed int
+	healthFailed int
+	lastProbe    probeResult
+	lastFailure  probeResult
+	aliveSince   time.Time
+	changedAt    time.Time
+	//backoff of the probes while down, see HealthBackoff; guarded by mux
+	probeDelay time.Duration
+	nextProbe  time.Time
+
+	//smooth weighted round-robin state, guarded by the owning ServerPool
+	currentWeight   int
+	effectiveWeight int
+}
+
+// SetAlive overrides the health check state and restarts its rise and fall
+// counts.
+func (b *Backend) SetAlive(alive bool) {
+	b.mux.Lock()
+	if alive != b.Alive {
+		b.changedAt = b.pool.policies.clock.Now()
+	}
+	if alive && !b.Alive {
+		b.aliveSince = b.changedAt
+	}
+	b.Alive = alive
+	b.healthPassed, b.healthFailed = 0, 0
+	b.probeDelay, b.nextProbe = 0, time.Time{}
+	b.mux.Unlock()
+}
+
+// probeResult is the outcome of a health check probe.
+type probeResult struct {
+	At       time.Time
+	Duration time.Duration
+	Err      error
+}
+
+// HealthDetails returns the last probe and the last failed one of b, and
+// when it last went up or down, zero if it never did.
+func (b *Backend) HealthDetails() (last, lastFailure probeResult, changedAt time.Time) {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	return b.lastProbe, b.lastFailure, b.changedAt
+}
+
+// HealthCounts returns the number of consecutive passed and failed probes.
+func (b *Backend) HealthCounts() (passed, failed int) {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	return b.healthPassed, b.healthFailed
+}
+
+func (b *Backend) IsAlive() bool {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	return b.Alive
+}
+
+// ServeHTTP proxies the request to the backend while tracking it as in-flight.
+func (b *Backend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.metrics.requests.Inc()
+	atomic.AddInt64(&b.connections, 1)
+	defer b.pool.queue.notify()
+	defer atomic.AddInt64(&b.connections, -1)
+	b.proxy(w, r)
+}
+
+// proxy runs a single attempt against the backend.
+func (b *Backend) proxy(w http.ResponseWriter, r *http.Request) {
+	r, cancel := withAttemptTimeout(r, b.pool.Timeouts().Request)
+	defer cancel()
+	r, done := b.trackWaiting(r)
+	defer done()
+	r, unbind := b.withRelease(r)
+	defer unbind()
+	b.ReverseProxy.ServeHTTP(w, r)
+}
+
+// SetDraining takes b out of rotation for new requests without marking it
+// down, or puts it back.
+func (b *Backend) SetDraining(draining bool) {
+	var v int32
+	if draining {
+		v = 1
+	}
+	atomic.StoreInt32(&b.draining, v)
+}
+
+func (b *Backend) IsDraining() bool {
+	return atomic.LoadInt32(&b.draining) == 1
+}
+
+func (b *Backend) ActiveConnections() int64 {
+	return atomic.LoadInt64(&b.connections)
+}
+
+// IsAvailable reports whether b is alive, not draining or ejected as an
+// outlier, its circuit breaker is not open and it is below MaxConnections,
+// where 0 means unlimited. The caller must hold the owning ServerPool's lock.
+func (b *Backend) IsAvailable() bool {
+	if !b.inRotation() {
+		return false
+	}
+	return b.MaxConnections <= 0 || b.ActiveConnections() < int64(b.MaxConnections)
+}
+
+// inRotation reports whether b is available but for its MaxConnections.
+func (b *Backend) inRotation() bool {
+	return b.IsAlive() && !b.IsDraining() && !b.outlier.ejected.Load() && b.breaker.Ready()
+}
@@ -0,0 +1,273 @@
+// Package balancer is an HTTP load balancer: it proxies requests to pools of
+// backends picked by host and path, with health checks, retries, failover
+// and the internal endpoints of the loadbalancer command.
+//
+// New builds the load balancer of a whole Config. A single pool, built with
+// NewServerPool and its options, can be mounted on its own. Retry, health
+// check and similar policies are held by the LoadBalancer or pool they were
+// built for, so several can run side by side in a process.
+package balancer
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	Attempts int = iota
+	Retry
+	BaseContext
+	AccessLog
+	Waiting
+	RequestID
+	Tried
+	StripPrefix
+	Inbound
+)
+
+// LoadBalancer serves the pools and routes of a Config along with the
+// internal endpoints it enables.
+type LoadBalancer struct {
+	cfg      Config
+	policies *policies
+	router   Router
+	drain    Drain
+	//rateLimiter, concurrencyLimit, mirror and discovery are nil when
+	//disabled
+	rateLimiter      *RateLimiter
+	concurrencyLimit *ConcurrencyLimit
+	mirror           *Mirror
+	discovery        *Discovery
+	stateReaper      StateReaper
+
+	handler         http.Handler
+	shutdownTracing func(context.Context) error
+}
+
+// Option customizes the LoadBalancer built by New.
+type Option func(*options)
+
+type options struct {
+	endpoints []endpoint
+	clock     Clock
+}
+
+type endpoint struct {
+	path    string
+	handler http.Handler
+}
+
+// WithEndpoint serves h for requests to exactly path, next to the internal
+// endpoints.
+func WithEndpoint(path string, h http.Handler) Option {
+	return func(o *options) {
+		o.endpoints = append(o.endpoints, endpoint{path, h})
+	}
+}
+
+// WithClock makes the load balancer and its pools tell the time from c
+// instead of the system clock.
+func WithClock(c Clock) Option {
+	return func(o *options) {
+		o.clock = c
+	}
+}
+
+// New validates cfg and returns the load balancer serving its pools with its
+// policies. Its backends are assumed up until Warmup or the first health
+// check of Run says otherwise.
+func New(cfg Config, opts ...Option) (*LoadBalancer, error) {
+	o := options{clock: realClock{}}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	p, err := newPolicies(&cfg, o.clock)
+	if err != nil {
+		return nil, err
+	}
+	l := &LoadBalancer{
+		cfg:         cfg,
+		policies:    p,
+		drain:       Drain{Delay: cfg.DrainDelay, clock: o.clock},
+		stateReaper: StateReaper{Interval: cfg.ReapInterval, TTL: cfg.StateTTL},
+	}
+	if cfg.RateLimit > 0 {
+		l.rateLimiter = NewRateLimiter(cfg.RateLimit, cfg.RateBurst)
+		l.rateLimiter.clock = o.clock
+	}
+	if cfg.MaxConcurrent > 0 {
+		l.concurrencyLimit = NewConcurrencyLimit(cfg.MaxConcurrent, cfg.MaxConcurrentWait)
+		l.concurrencyLimit.clock = o.clock
+	}
+	if cfg.MirrorUrl != "" {
+		target, _ := url.Parse(cfg.MirrorUrl)
+		l.mirror = NewMirror(target, cfg.MirrorPercent, cfg.MirrorMaxBody, cfg.MirrorMaxInFlight)
+	}
+	if cfg.DNSDiscovery != "" {
+		target, _ := url.Parse(cfg.DNSDiscovery)
+		l.discovery = &Discovery{Target: target, SRV: cfg.DNSSRV, Interval: cfg.DNSInterval}
+		l.discovery.refresh(context.Background())
+	}
+	l.router.policies = p
+	l.router.discovery = l.discovery
+	backends, _, err := l.router.Configure(&cfg)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range backends {
+		b.SetAlive(true)
+		slog.Info("configured backend", "pool", b.pool.Name, "backend", b.Url.String(), "weight", b.Weight)
+	}
+
+	handler := http.Handler(http.HandlerFunc(l.serve))
+	if cfg.Compress {
+		handler = withCompression(Compression{Types: cfg.CompressTypes, MinSize: cfg.CompressMinSize}, handler)
+	}
+	if cfg.Tracing {
+		tracer, shutdown, err := setupTracing(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("cannot set up tracing: %w", err)
+		}
+		l.shutdownTracing = shutdown
+		handler = withTracing(tracer, p.trustedProxies, handler)
+	}
+	if cfg.AccessLog != "" {
+		out, err := openAccessLog(cfg.AccessLog)
+		if err != nil {
+			return nil, fmt.Errorf("cannot open access log: %w", err)
+		}
+		handler = withAccessLog(out, p.trustedProxies, handler)
+	}
+	if cfg.StatsPath != "" {
+		handler = withEndpoint(cfg.StatsPath, http.HandlerFunc(l.statsHandler), handler)
+	}
+	if cfg.AdminToken != "" {
+		handler = withEndpoint("/lb/backends", requireAdmin(cfg.AdminToken, http.HandlerFunc(l.backendsHandler)), handler)
+		handler = withEndpoint("/lb/backends/drain", requireAdmin(cfg.AdminToken, l.drainHandler(true)), handler)
+		handler = withEndpoint("/lb/backends/undrain", requireAdmin(cfg.AdminToken, l.drainHandler(false)), handler)
+		handler = withEndpoint("/lb/drain", requireAdmin(cfg.AdminToken, http.HandlerFunc(l.lbDrainHandler)), handler)
+		handler = withEndpoint("/lb/healthcheck/trigger", requireAdmin(cfg.AdminToken, http.HandlerFunc(l.healthCheckTriggerHandler)), handler)
+		handler = withEndpoint("/lb/config", requireAdmin(cfg.AdminToken, http.HandlerFunc(l.configHandler)), handler)
+		handler = withEndpoint("/lb/weights", requireAdmin(cfg.AdminToken, http.HandlerFunc(l.weightsHandler)), handler)
+	}
+	if cfg.MetricsPath != "" {
+		//the backend gauges are per load balancer, the other metrics global
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(poolCollector{router: &l.router, rateLimiter: l.rateLimiter})
+		metrics := promhttp.HandlerFor(prometheus.Gatherers{prometheus.DefaultGatherer, registry}, promhttp.HandlerOpts{})
+		handler = withEndpoint(cfg.MetricsPath, promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, metrics), handler)
+	}
+	if cfg.LivezPath != "" {
+		handler = withEndpoint(cfg.LivezPath, http.HandlerFunc(livezHandler), handler)
+	}
+	if cfg.ReadyzPath != "" {
+		handler = withEndpoint(cfg.ReadyzPath, http.HandlerFunc(l.readyzHandler), handler)
+	}
+	for _, e := range o.endpoints {
+		handler = withEndpoint(e.path, e.handler, handler)
+	}
+	handler = withRecover(handler)
+	l.handler = withRequestID(p.requestIDs, handler)
+	return l, nil
+}
+
+// Check reports the errors New would fail with for cfg, one per line,
+// without applying it.
+func Check(cfg Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	_, err := cfg.UnavailablePage()
+	return err
+}
+
+func (l *LoadBalancer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	l.handler.ServeHTTP(w, r)
+}
+
+// Run health checks the backends, and runs DNS discovery and outlier
+// detection where enabled as well as the state reaper, until ctx is done.
+func (l *LoadBalancer) Run(ctx context.Context) {
+	go l.stateReaper.run(ctx, l.rateLimiter, &l.router)
+	if l.discovery != nil {
+		go l.discovery.run(ctx, &l.router)
+	}
+	if d := l.policies.outlierDetection; d.Interval > 0 {
+		go d.run(ctx, &l.router)
+	}
+	l.healthCheck(ctx, l.cfg.HealthInterval)
+}
+
+// Drain starts draining the load balancer, see Drain.Start, and returns when
+// new requests start being refused.
+func (l *LoadBalancer) Drain() time.Time {
+	return l.drain.Start()
+}
+
+// ActiveConnections returns the number of requests in flight across all pools.
+func (l *LoadBalancer) ActiveConnections() int64 {
+	return l.router.ActiveConnections()
+}
+
+// Close flushes the traces not exported yet.
+func (l *LoadBalancer) Close(ctx context.Context) error {
+	if l.shutdownTracing == nil {
+		return nil
+	}
+	return l.shutdownTracing(ctx)
+}
+
+func GetRetryFromContext(r *http.Request) int {
+	if retry, ok := r.Context().Value(Retry).(int); ok {
+		return retry
+	}
+	return 0
+}
+
+func GetAttemptsFromContext(r *http.Request) int {
+	if attempts, ok := r.Context().Value(Attempts).(int); ok {
+		return attempts
+	}
+	return 0
+}
+
+// serve passes r through the limits of the load balancer and on to the pool
+// of its route.
+func (l *LoadBalancer) serve(w http.ResponseWriter, r *http.Request) {
+	if l.drain.Refusing() {
+		w.Header().Set("Connection", "close")
+		http.Error(w, "Service not available", http.StatusServiceUnavailable)
+		return
+	}
+	if l.limitRate(w, r) {
+		return
+	}
+	if limitRequest(w, r, l.policies.maxRequestBytes) {
+		return
+	}
+	release, ok := l.limitConcurrency(w, r)
+	defer release()
+	if !ok {
+		return
+	}
+	route := l.router.Match(r)
+	if route.Pool == nil {
+		l.policies.serveUnavailable(w)
+		return
+	}
+	if route.StripPrefix {
+		r = withStripPrefix(r, route.Prefix)
+	}
+	r = mirrorRequest(l.mirror, r)
+	route.Pool.serve(w, r)
+}
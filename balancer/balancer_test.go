@@ -0,0 +1,86 @@
+package balancer
+
+import (
+	"flag"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if !testing.Verbose() {
+		slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	}
+	os.Exit(m.Run())
+}
+
+// TestBalancersIndependent builds two load balancers with different policies
+// in one process: each must keep its own.
+func TestBalancersIndependent(t *testing.T) {
+	var hits atomic.Int64
+	backend := newTestBackend(t, hangUp(&hits))
+	build := func(retries, badGateway int) *LoadBalancer {
+		cfg := DefaultConfig()
+		cfg.Backends = []BackendConfig{{Url: backend.URL, Weight: 1}}
+		cfg.MaxRetries, cfg.RetryBackoff = retries, 0
+		cfg.BadGatewayStatus = badGateway
+		l, err := New(cfg)
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		return l
+	}
+	a := build(0, http.StatusBadGateway)
+	b := build(2, 520)
+	tests := []struct {
+		name     string
+		l        *LoadBalancer
+		wantCode int
+		wantHits int64
+	}{
+		{"first", a, http.StatusBadGateway, 1},
+		{"second", b, 520, 3},
+	}
+	for _, tt := range tests {
+		hits.Store(0)
+		w := serve(tt.l, httptest.NewRequest(http.MethodGet, "/", nil))
+		if w.Code != tt.wantCode {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.wantCode)
+		}
+		if hits.Load() != tt.wantHits {
+			t.Errorf("%s: backend got %d attempts, want %d", tt.name, hits.Load(), tt.wantHits)
+		}
+	}
+}
+
+// TestWithClock checks that the load balancer and its pools tell the time
+// from the clock they were given.
+func TestWithClock(t *testing.T) {
+	fc := newFakeClock()
+	cfg := DefaultConfig()
+	cfg.Backends = []BackendConfig{{Url: "http://10.0.0.1:80", Weight: 1}}
+	cfg.DrainDelay = 5 * time.Second
+	l, err := New(cfg, WithClock(fc))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if until := l.Drain(); !until.Equal(fc.Now().Add(cfg.DrainDelay)) {
+		t.Errorf("Drain = %s, want %s after the fake clock's %s", until, cfg.DrainDelay, fc.Now())
+	}
+	if l.drain.Refusing() {
+		t.Error("refusing requests before the drain delay")
+	}
+	fc.Advance(cfg.DrainDelay)
+	if !l.drain.Refusing() {
+		t.Error("not refusing requests once the fake clock passed the drain delay")
+	}
+	if changed := l.router.Pool(defaultPool).Backends()[0].changedAt; !changed.Equal(fc.Now().Add(-cfg.DrainDelay)) {
+		t.Errorf("backend brought up at %s, want the fake clock's start", changed)
+	}
+}
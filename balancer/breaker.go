@@ -0,0 +1,116 @@
+package balancer
+
+import (
+	"sync"
+	"time"
+)
+
+// BreakerPolicy configures the per-backend circuit breakers. A Failures
+// threshold of 0 disables them.
+type BreakerPolicy struct {
+	Failures int
+	Cooldown time.Duration
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+// CircuitBreaker opens after the Failures of its policy in a row, proxy
+// errors or 5xx and degraded responses, and keeps the backend out of
+// selection for the cooldown. It then lets a single trial request through
+// (half-open) and closes again if it succeeds.
+type CircuitBreaker struct {
+	policy BreakerPolicy
+	clock  Clock
+
+	mux      sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+	trial    bool
+}
+
+// Ready reports whether the breaker would let a request through, without
+// changing its state.
+func (cb *CircuitBreaker) Ready() bool {
+	if cb.policy.Failures <= 0 {
+		return true
+	}
+	cb.mux.Lock()
+	defer cb.mux.Unlock()
+	switch cb.state {
+	case breakerOpen:
+		return since(cb.clock, cb.openedAt) >= cb.policy.Cooldown
+	case breakerHalfOpen:
+		return !cb.trial
+	}
+	return true
+}
+
+// Begin records that a request was routed through the breaker, claiming the
+// trial slot when the cooldown has elapsed.
+func (cb *CircuitBreaker) Begin() {
+	if cb.policy.Failures <= 0 {
+		return
+	}
+	cb.mux.Lock()
+	defer cb.mux.Unlock()
+	if cb.state == breakerOpen && since(cb.clock, cb.openedAt) >= cb.policy.Cooldown {
+		cb.state = breakerHalfOpen
+	}
+	if cb.state == breakerHalfOpen {
+		cb.trial = true
+	}
+}
+
+func (cb *CircuitBreaker) Success() {
+	cb.mux.Lock()
+	defer cb.mux.Unlock()
+	cb.state = breakerClosed
+	cb.failures = 0
+	cb.trial = false
+}
+
+// Abort gives back the trial slot of a request that ended without telling
+// whether the backend recovered, as the client went away or the load
+// balancer cancelled it, so that the next request becomes the trial.
+func (cb *CircuitBreaker) Abort() {
+	cb.mux.Lock()
+	defer cb.mux.Unlock()
+	cb.trial = false
+}
+
+func (cb *CircuitBreaker) Failure() {
+	if cb.policy.Failures <= 0 {
+		return
+	}
+	cb.mux.Lock()
+	defer cb.mux.Unlock()
+	cb.failures++
+	if cb.state == breakerHalfOpen || cb.failures >= cb.policy.Failures {
+		cb.state = breakerOpen
+		cb.openedAt = cb.clock.Now()
+		cb.trial = false
+	}
+}
+
+func (cb *CircuitBreaker) State() string {
+	cb.mux.Lock()
+	defer cb.mux.Unlock()
+	return cb.state.String()
+}
@@ -0,0 +1,37 @@
+package balancer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBreakerOpensOn5xx(t *testing.T) {
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	pool := newTestPool(t, WithBackend(backend.URL, 1), withSettings(func(cfg *Config) {
+		cfg.BreakerFailures, cfg.BreakerCooldown = 2, time.Hour
+	}))
+	b := pool.Backends()[0]
+
+	for i := 0; i < b.breaker.policy.Failures; i++ {
+		w := serve(pool.Handler(), httptest.NewRequest(http.MethodGet, "/", nil))
+		if w.Code != http.StatusServiceUnavailable {
+			t.Fatalf("request %d: status = %d, want the backend's %d", i, w.Code, http.StatusServiceUnavailable)
+		}
+	}
+	if got := b.breaker.State(); got != "open" {
+		t.Fatalf("breaker = %s after %d 503s, want open", got, b.breaker.policy.Failures)
+	}
+
+	//once the cooldown is over, a 503 to the trial request opens it again
+	b.breaker.mux.Lock()
+	b.breaker.openedAt = b.breaker.clock.Now().Add(-b.breaker.policy.Cooldown)
+	b.breaker.mux.Unlock()
+	serve(pool.Handler(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if got := b.breaker.State(); got != "open" {
+		t.Fatalf("breaker = %s after a failed trial, want open", got)
+	}
+}
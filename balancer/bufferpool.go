@@ -0,0 +1,26 @@
+package balancer
+
+import "sync"
+
+// BufferPool hands the reverse proxies reusable copy buffers so streaming
+// bodies does not allocate a fresh buffer per request.
+type BufferPool struct {
+	pool sync.Pool
+}
+
+func NewBufferPool(size int) *BufferPool {
+	return &BufferPool{pool: sync.Pool{
+		New: func() interface{} {
+			buf := make([]byte, size)
+			return &buf
+		},
+	}}
+}
+
+func (p *BufferPool) Get() []byte {
+	return *p.pool.Get().(*[]byte)
+}
+
+func (p *BufferPool) Put(buf []byte) {
+	p.pool.Put(&buf)
+}
@@ -0,0 +1,55 @@
+package balancer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"testing"
+)
+
+// discardWriter is a ResponseWriter throwing the response away, so that
+// benchmarks only count the allocations of the proxy.
+type discardWriter struct {
+	header http.Header
+}
+
+func (w *discardWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *discardWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (w *discardWriter) WriteHeader(int) {}
+
+func BenchmarkProxyCopy(b *testing.B) {
+	body := make([]byte, 256<<10)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	}))
+	defer backend.Close()
+	target, _ := url.Parse(backend.URL)
+
+	for _, bb := range []struct {
+		name string
+		pool httputil.BufferPool
+	}{
+		{"unpooled", nil},
+		{"pooled", NewBufferPool(32 << 10)},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			proxy := &httputil.ReverseProxy{
+				Rewrite:    func(pr *httputil.ProxyRequest) { pr.SetURL(target) },
+				BufferPool: bb.pool,
+			}
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			b.ReportAllocs()
+			b.SetBytes(int64(len(body)))
+			for b.Loop() {
+				proxy.ServeHTTP(&discardWriter{header: http.Header{}}, r)
+			}
+		})
+	}
+}
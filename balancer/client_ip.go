@@ -0,0 +1,79 @@
+package balancer
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// proxyPrefixes are the ranges of the trusted proxies, the peers whose
+// X-Forwarded-* headers are believed. Only list proxies that append to
+// X-Forwarded-For themselves, as clients can forge the header otherwise.
+type proxyPrefixes []netip.Prefix
+
+// parsePrefix accepts a CIDR range or a single address.
+func parsePrefix(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if !strings.Contains(s, "/") {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	p, err := netip.ParsePrefix(s)
+	return p.Masked(), err
+}
+
+func (t proxyPrefixes) contains(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	for _, p := range t {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// peerHost returns the host of r's immediate peer, without port.
+func peerHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// trusts reports whether r's immediate peer is a trusted proxy.
+func (t proxyPrefixes) trusts(r *http.Request) bool {
+	ip, err := netip.ParseAddr(peerHost(r))
+	return err == nil && t.contains(ip)
+}
+
+// clientIP returns the address of the client that issued r, without port.
+// Behind trusted proxies that is the right-most X-Forwarded-For entry not
+// added by a trusted proxy: entries further left were supplied by the client
+// itself and may be spoofed. Anyone else is taken at their RemoteAddr.
+func (t proxyPrefixes) clientIP(r *http.Request) string {
+	client := peerHost(r)
+	if !t.trusts(r) {
+		return client
+	}
+	var entries []string
+	for _, xff := range r.Header.Values("X-Forwarded-For") {
+		entries = append(entries, strings.Split(xff, ",")...)
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		ip, err := netip.ParseAddr(strings.TrimSpace(entries[i]))
+		if err != nil {
+			//garbage, keep the last address a trusted proxy vouched for
+			break
+		}
+		client = ip.Unmap().String()
+		if !t.contains(ip) {
+			break
+		}
+	}
+	return client
+}
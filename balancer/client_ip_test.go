@@ -0,0 +1,71 @@
+package balancer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted := proxyPrefixes{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("2001:db8::/32"),
+	}
+	tests := []struct {
+		name   string
+		remote string
+		xff    []string
+		want   string
+	}{
+		{"direct client", "203.0.113.7:4321", nil, "203.0.113.7"},
+		{"untrusted peer forging the header", "203.0.113.7:4321", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"trusted proxy", "10.0.0.1:4321", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"trusted proxy without header", "10.0.0.1:4321", nil, "10.0.0.1"},
+		{"spoofed entry left of the real client", "10.0.0.1:4321", []string{"1.2.3.4, 198.51.100.1"}, "198.51.100.1"},
+		{"chain of trusted proxies", "10.0.0.1:4321", []string{"198.51.100.1, 10.1.1.1, 10.2.2.2"}, "198.51.100.1"},
+		{"only trusted proxies", "10.0.0.1:4321", []string{"10.1.1.1"}, "10.1.1.1"},
+		{"several headers", "10.0.0.1:4321", []string{"1.2.3.4", "198.51.100.1"}, "198.51.100.1"},
+		{"garbage after the client", "10.0.0.1:4321", []string{"198.51.100.1, not-an-ip"}, "10.0.0.1"},
+		{"garbage left of the client", "10.0.0.1:4321", []string{"not-an-ip, 198.51.100.1"}, "198.51.100.1"},
+		{"IPv4-mapped IPv6", "10.0.0.1:4321", []string{"::ffff:198.51.100.1"}, "198.51.100.1"},
+		{"trusted IPv6 proxy", "[2001:db8::1]:4321", []string{"2001:db9::5"}, "2001:db9::5"},
+		{"trusted IPv4-mapped peer", "[::ffff:10.0.0.1]:4321", []string{"198.51.100.1"}, "198.51.100.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remote
+			for _, v := range tt.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if got := trusted.clientIP(r); got != tt.want {
+				t.Errorf("clientIP = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePrefix(t *testing.T) {
+	tests := []struct {
+		in, want string
+		err      bool
+	}{
+		{"10.0.0.0/8", "10.0.0.0/8", false},
+		{"10.1.2.3/8", "10.0.0.0/8", false},
+		{" 192.0.2.1 ", "192.0.2.1/32", false},
+		{"2001:db8::1", "2001:db8::1/128", false},
+		{"10.0.0.0/33", "", true},
+		{"example.com", "", true},
+	}
+	for _, tt := range tests {
+		p, err := parsePrefix(tt.in)
+		if (err != nil) != tt.err {
+			t.Errorf("parsePrefix(%q) error = %v, want error %v", tt.in, err, tt.err)
+			continue
+		}
+		if err == nil && p.String() != tt.want {
+			t.Errorf("parsePrefix(%q) = %s, want %s", tt.in, p, tt.want)
+		}
+	}
+}
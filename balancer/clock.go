@@ -0,0 +1,48 @@
+package balancer
+
+import "time"
+
+// Clock tells the time to the time-based logic: health check intervals and
+// backoff, retry backoff, slow start, circuit breakers, outlier ejection,
+// backend latencies, draining, rate limiting and concurrency limit waits.
+// Tests can substitute one they advance by hand, see WithClock and
+// WithPoolClock.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker is a time.Ticker of a Clock.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// since returns the time elapsed since t according to c.
+func since(c Clock, t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+// realClock is the Clock of the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
@@ -0,0 +1,232 @@
+package balancer
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock that only moves when advanced. Its tickers deliver
+// every tick they go past, waiting for each to be received.
+type fakeClock struct {
+	mux     sync.Mutex
+	now     time.Time
+	timers  []*fakeTimer
+	tickers []*fakeTicker
+}
+
+type fakeTimer struct {
+	at time.Time
+	c  chan time.Time
+}
+
+type fakeTicker struct {
+	clock   *fakeClock
+	period  time.Duration
+	next    time.Time
+	c       chan time.Time
+	stopped chan struct{}
+	once    sync.Once
+}
+
+// newFakeClock returns a fake clock, to be given to pools with
+// WithPoolClock.
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	timer := &fakeTimer{at: c.now.Add(d), c: make(chan time.Time, 1)}
+	if d <= 0 {
+		timer.c <- c.now
+		return timer.c
+	}
+	c.timers = append(c.timers, timer)
+	return timer.c
+}
+
+func (c *fakeClock) NewTicker(d time.Duration) Ticker {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	ticker := &fakeTicker{clock: c, period: d, next: c.now.Add(d), c: make(chan time.Time), stopped: make(chan struct{})}
+	c.tickers = append(c.tickers, ticker)
+	return ticker
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTicker) Stop() {
+	t.once.Do(func() {
+		close(t.stopped)
+		t.clock.mux.Lock()
+		t.clock.tickers = slices.DeleteFunc(t.clock.tickers, func(x *fakeTicker) bool { return x == t })
+		t.clock.mux.Unlock()
+	})
+}
+
+// waiting returns the number of pending timers and running tickers.
+func (c *fakeClock) waiting() int {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return len(c.timers) + len(c.tickers)
+}
+
+// blockUntil waits for n timers and tickers to be pending on c.
+func (c *fakeClock) blockUntil(t *testing.T, n int) {
+	t.Helper()
+	waitFor(t, func() bool { return c.waiting() >= n })
+}
+
+// Advance moves c forward by d, firing the timers and ticks due meanwhile.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mux.Lock()
+	end := c.now.Add(d)
+	for {
+		//the earliest timer or tick due, in order
+		var next time.Time
+		for _, timer := range c.timers {
+			if next.IsZero() || timer.at.Before(next) {
+				next = timer.at
+			}
+		}
+		for _, ticker := range c.tickers {
+			if next.IsZero() || ticker.next.Before(next) {
+				next = ticker.next
+			}
+		}
+		if next.IsZero() || next.After(end) {
+			break
+		}
+		c.now = next
+		var due []*fakeTicker
+		c.timers = slices.DeleteFunc(c.timers, func(timer *fakeTimer) bool {
+			if timer.at.After(next) {
+				return false
+			}
+			timer.c <- next
+			return true
+		})
+		for _, ticker := range c.tickers {
+			if !ticker.next.After(next) {
+				ticker.next = ticker.next.Add(ticker.period)
+				due = append(due, ticker)
+			}
+		}
+		c.mux.Unlock()
+		for _, ticker := range due {
+			select {
+			case ticker.c <- next:
+			case <-ticker.stopped:
+			}
+		}
+		c.mux.Lock()
+	}
+	c.now = end
+	c.mux.Unlock()
+}
+
+// waitFor waits up to a second of real time for cond to hold.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met after 1s")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestReleaseDrain(t *testing.T) {
+	tests := []struct {
+		name     string
+		finishes bool //whether the request in flight finishes before the timeout
+	}{
+		{"drained", true},
+		{"timed out", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newFakeClock()
+			pool := newTestPool(t, WithBackend("http://10.0.0.1:80", 1), WithPoolClock(fc), withSettings(func(cfg *Config) {
+				cfg.DrainTimeout = 10 * time.Second
+			}))
+			b := pool.Backends()[0]
+			atomic.AddInt64(&b.connections, 1)
+			var released atomic.Bool
+			go func() {
+				b.release("test")
+				released.Store(true)
+			}()
+			fc.blockUntil(t, 2)
+
+			fc.Advance(5 * time.Second)
+			if released.Load() || b.released() {
+				t.Fatal("backend released halfway through the drain timeout with a request in flight")
+			}
+			if tt.finishes {
+				atomic.AddInt64(&b.connections, -1)
+				fc.Advance(100 * time.Millisecond)
+			} else {
+				fc.Advance(5 * time.Second)
+			}
+			waitFor(t, released.Load)
+			if !b.released() {
+				t.Error("backend not released")
+			}
+		})
+	}
+}
+
+func TestOutlierEjectionExpiry(t *testing.T) {
+	fc := newFakeClock()
+	d := OutlierDetection{Interval: 10 * time.Second, ErrorRate: 0.5, MinRequests: 10, BaseEjection: 30 * time.Second, MaxEjection: time.Minute, MaxEjectedPercent: 50}
+	pool := newStrategyPool(t, RoundRobin, 3, WithPoolClock(fc))
+	l := newTestBalancer(Config{}, pool)
+	backends := pool.Backends()
+	for i, b := range backends {
+		for range 10 {
+			b.outlier.observe(d, i == 0)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		d.run(ctx, &l.router)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	fc.blockUntil(t, 1)
+
+	ejected := func() bool {
+		ejected, _, _ := backends[0].Ejection()
+		return ejected
+	}
+	fc.Advance(10 * time.Second)
+	waitFor(t, ejected)
+	if _, until, _ := backends[0].Ejection(); !until.Equal(fc.Now().Add(30 * time.Second)) {
+		t.Errorf("ejected until %s, want 30s after %s", until, fc.Now())
+	}
+	//each tick is only delivered once the sweep of the previous one is over
+	fc.Advance(20 * time.Second)
+	if !ejected() {
+		t.Fatal("backend returned before the end of its ejection")
+	}
+	fc.Advance(10 * time.Second)
+	waitFor(t, func() bool { return !ejected() })
+}
@@ -0,0 +1,197 @@
+package balancer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Compression gzips the responses to clients accepting it whose content
+// type matches one of Types, either a media type or a type followed by /*,
+// and whose body is at least MinSize bytes. Responses that are already
+// encoded, partial or for HEAD requests are left alone. A response of unknown
+// length is buffered up to MinSize before deciding, unless it is flushed
+// first, so streaming ones go out as soon as the backend flushes them.
+type Compression struct {
+	Types   []string
+	MinSize int
+}
+
+// compressDefaults are the settings of Compression unless configured
+// otherwise.
+var compressDefaults = Compression{
+	Types:   []string{"text/*", "application/json", "application/javascript", "application/xml", "image/svg+xml"},
+	MinSize: 1024,
+}
+
+var gzipWriters = sync.Pool{New: func() any { return gzip.NewWriter(nil) }}
+
+// withCompression gzips the responses of h as configured by c.
+func withCompression(c Compression, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead || r.Header.Get("Range") != "" || isUpgrade(r) || !acceptsGzip(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, policy: c}
+		defer cw.close()
+		h.ServeHTTP(cw, r)
+	})
+}
+
+// acceptsGzip reports whether the Accept-Encoding of r lists gzip without a
+// zero quality.
+func acceptsGzip(r *http.Request) bool {
+	for _, v := range r.Header.Values("Accept-Encoding") {
+		for _, coding := range strings.Split(v, ",") {
+			name, params, _ := strings.Cut(coding, ";")
+			if !strings.EqualFold(strings.TrimSpace(name), "gzip") {
+				continue
+			}
+			q, ok := strings.CutPrefix(strings.TrimSpace(params), "q=")
+			if !ok {
+				return true
+			}
+			quality, err := strconv.ParseFloat(q, 64)
+			return err == nil && quality > 0
+		}
+	}
+	return false
+}
+
+// compressible reports whether the response with header could be gzipped,
+// leaving its size aside.
+func (c Compression) compressible(status int, header http.Header) bool {
+	if status != http.StatusOK || header.Get("Content-Encoding") != "" {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	for _, t := range c.Types {
+		if t == mediaType || strings.HasSuffix(t, "/*") && strings.HasPrefix(mediaType, t[:len(t)-1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// compressWriter gzips the response if it turns out compressible, buffering
+// the start of a body of unknown length until it is known to reach MinSize.
+// Unwrap lets http.ResponseController reach the writer underneath.
+type compressWriter struct {
+	http.ResponseWriter
+	policy Compression
+	status int
+	//decided is set once the header has gone out, gz is nil unless gzipping
+	decided bool
+	gz      *gzip.Writer
+	buf     bytes.Buffer
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	if w.status != 0 {
+		return
+	}
+	if code < 200 {
+		//informational responses go out as they are
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.status = code
+	if !w.policy.compressible(code, w.Header()) {
+		w.start(false)
+		return
+	}
+	w.Header().Add("Vary", "Accept-Encoding")
+	if cl := w.Header().Get("Content-Length"); cl != "" {
+		n, err := strconv.ParseInt(cl, 10, 64)
+		w.start(err == nil && n >= int64(w.policy.MinSize))
+	}
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.decided {
+		if w.gz != nil {
+			return w.gz.Write(b)
+		}
+		return w.ResponseWriter.Write(b)
+	}
+	w.buf.Write(b)
+	if w.buf.Len() >= w.policy.MinSize {
+		if err := w.start(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// start sends the header, gzipping the rest of the response if compress, and
+// then the buffered start of the body.
+func (w *compressWriter) start(compress bool) error {
+	w.decided = true
+	if compress {
+		h := w.Header()
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", "gzip")
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			//the encoded body is no longer byte for byte the same
+			h.Set("ETag", "W/"+etag)
+		}
+		w.gz = gzipWriters.Get().(*gzip.Writer)
+		w.gz.Reset(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	var err error
+	if w.gz != nil {
+		_, err = w.gz.Write(w.buf.Bytes())
+	} else {
+		_, err = w.ResponseWriter.Write(w.buf.Bytes())
+	}
+	w.buf.Reset()
+	return err
+}
+
+// Flush sends what has been written so far, deciding against compression if
+// the body is still below MinSize.
+func (w *compressWriter) Flush() {
+	if w.status != 0 && !w.decided {
+		w.start(w.buf.Len() >= w.policy.MinSize)
+	}
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// close ends the gzip stream, or sends a body that stayed below MinSize as
+// it is.
+func (w *compressWriter) close() {
+	if w.status != 0 && !w.decided {
+		w.start(false)
+	}
+	if w.gz != nil {
+		w.gz.Close()
+		w.gz.Reset(nil)
+		gzipWriters.Put(w.gz)
+		w.gz = nil
+	}
+}
+
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
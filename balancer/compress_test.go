@@ -0,0 +1,276 @@
+package balancer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// gunzip returns the body of a response, decompressed if it is gzipped.
+func gunzip(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	if w.Header().Get("Content-Encoding") != "gzip" {
+		return w.Body.String()
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(w.Body.Bytes()))
+	if err != nil {
+		t.Fatalf("gzip.NewReader: %v", err)
+	}
+	body, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("reading gzipped body: %v", err)
+	}
+	return string(body)
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		accept []string
+		want   bool
+	}{
+		{nil, false},
+		{[]string{"gzip"}, true},
+		{[]string{"GZIP"}, true},
+		{[]string{"deflate, br"}, false},
+		{[]string{"br;q=1.0, gzip;q=0.8"}, true},
+		{[]string{"gzip;q=0"}, false},
+		{[]string{"gzip; q=0.5"}, true},
+		{[]string{"gzip;q=junk"}, false},
+		{[]string{"deflate", "gzip"}, true},
+		{[]string{"*"}, false}, //only gzip named explicitly is taken
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		for _, v := range tt.accept {
+			r.Header.Add("Accept-Encoding", v)
+		}
+		if got := acceptsGzip(r); got != tt.want {
+			t.Errorf("acceptsGzip(%q) = %v, want %v", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestCompressible(t *testing.T) {
+	c := compressDefaults
+	tests := []struct {
+		status      int
+		contentType string
+		encoding    string
+		want        bool
+	}{
+		{http.StatusOK, "text/html; charset=utf-8", "", true},
+		{http.StatusOK, "text/plain", "", true},
+		{http.StatusOK, "application/json", "", true},
+		{http.StatusOK, "image/svg+xml", "", true},
+		{http.StatusOK, "image/png", "", false},
+		{http.StatusOK, "application/octet-stream", "", false},
+		{http.StatusOK, "textual/plain", "", false}, //text/* only matches the text type
+		{http.StatusOK, "", "", false},
+		{http.StatusOK, "text/html", "br", false},
+		{http.StatusNoContent, "text/html", "", false},
+		{http.StatusNotModified, "text/html", "", false},
+		{http.StatusPartialContent, "text/html", "", false},
+		{http.StatusNotFound, "text/html", "", false},
+	}
+	for _, tt := range tests {
+		header := http.Header{}
+		if tt.contentType != "" {
+			header.Set("Content-Type", tt.contentType)
+		}
+		if tt.encoding != "" {
+			header.Set("Content-Encoding", tt.encoding)
+		}
+		if got := c.compressible(tt.status, header); got != tt.want {
+			t.Errorf("compressible(%d, %q, encoding %q) = %v, want %v", tt.status, tt.contentType, tt.encoding, got, tt.want)
+		}
+	}
+}
+
+func TestCompression(t *testing.T) {
+	const minSize = 100
+	large, small := strings.Repeat("x", minSize), strings.Repeat("x", minSize-1)
+	tests := []struct {
+		name       string
+		method     string
+		accept     string
+		status     int
+		header     http.Header
+		knownSize  bool //whether the handler sets Content-Length
+		body       string
+		wantGzip   bool
+		wantLength string //Content-Length relayed, checked when not gzipped
+	}{
+		{"known size", http.MethodGet, "gzip", http.StatusOK, nil, true, large, true, ""},
+		{"known size too small", http.MethodGet, "gzip", http.StatusOK, nil, true, small, false, strconv.Itoa(len(small))},
+		{"unknown size", http.MethodGet, "gzip", http.StatusOK, nil, false, large, true, ""},
+		{"unknown size too small", http.MethodGet, "gzip", http.StatusOK, nil, false, small, false, ""},
+		{"not accepted", http.MethodGet, "br", http.StatusOK, nil, true, large, false, strconv.Itoa(len(large))},
+		{"zero quality", http.MethodGet, "gzip;q=0", http.StatusOK, nil, false, large, false, ""},
+		{"type not listed", http.MethodGet, "gzip", http.StatusOK, http.Header{"Content-Type": {"image/png"}}, false, large, false, ""},
+		{"already encoded", http.MethodGet, "gzip", http.StatusOK, http.Header{"Content-Encoding": {"br"}}, false, large, false, ""},
+		{"HEAD", http.MethodHead, "gzip", http.StatusOK, nil, true, large, false, strconv.Itoa(len(large))},
+		{"no content", http.MethodGet, "gzip", http.StatusNoContent, nil, false, "", false, ""},
+		{"not modified", http.MethodGet, "gzip", http.StatusNotModified, nil, false, "", false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := withCompression(Compression{Types: compressDefaults.Types, MinSize: minSize}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				for name, values := range tt.header {
+					w.Header()[name] = values
+				}
+				if tt.knownSize {
+					w.Header().Set("Content-Length", strconv.Itoa(len(tt.body)))
+				}
+				w.WriteHeader(tt.status)
+				if r.Method != http.MethodHead {
+					io.WriteString(w, tt.body)
+				}
+			}))
+			r := httptest.NewRequest(tt.method, "/", nil)
+			r.Header.Set("Accept-Encoding", tt.accept)
+			w := serve(h, r)
+
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+			if gzipped := w.Header().Get("Content-Encoding") == "gzip"; gzipped != tt.wantGzip {
+				t.Fatalf("gzipped = %v, want %v", gzipped, tt.wantGzip)
+			}
+			if tt.wantGzip && w.Header().Get("Content-Length") != "" {
+				t.Errorf("gzipped response keeps Content-Length %s", w.Header().Get("Content-Length"))
+			}
+			if !tt.wantGzip && w.Header().Get("Content-Length") != tt.wantLength {
+				t.Errorf("Content-Length = %q, want %q", w.Header().Get("Content-Length"), tt.wantLength)
+			}
+			want := tt.body
+			if tt.method == http.MethodHead {
+				want = ""
+			}
+			if got := gunzip(t, w); got != want {
+				t.Errorf("body = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+// TestCompressionFlush flushes a streaming response still below MinSize: it
+// must go out right away, uncompressed, instead of waiting in the buffer.
+func TestCompressionFlush(t *testing.T) {
+	const chunk = "data: 1\n\n"
+	w := httptest.NewRecorder()
+	h := withCompression(Compression{Types: []string{"text/event-stream"}, MinSize: 1024}, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(rw, chunk)
+		if w.Flushed || w.Body.Len() > 0 {
+			t.Error("response sent before the flush, below MinSize")
+		}
+		http.NewResponseController(rw).Flush()
+		if !w.Flushed {
+			t.Error("response not flushed")
+		}
+		if w.Body.String() != chunk {
+			t.Errorf("body after the flush = %q, want %q", w.Body.String(), chunk)
+		}
+		//later writes, past MinSize, keep the encoding decided at the flush
+		io.WriteString(rw, strings.Repeat("x", 2048))
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	h.ServeHTTP(w, r)
+	if enc := w.Header().Get("Content-Encoding"); enc != "" {
+		t.Errorf("Content-Encoding = %q, want none once decided at the flush", enc)
+	}
+	if want := chunk + strings.Repeat("x", 2048); w.Body.String() != want {
+		t.Errorf("body = %d bytes, want %d", w.Body.Len(), len(want))
+	}
+}
+
+// TestCompressionFlushGzipped flushes a response already being gzipped: what
+// was written must be decodable before the handler returns.
+func TestCompressionFlushGzipped(t *testing.T) {
+	first := strings.Repeat("a", 200)
+	w := httptest.NewRecorder()
+	h := withCompression(Compression{Types: []string{"text/plain"}, MinSize: 100}, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "text/plain")
+		io.WriteString(rw, first)
+		http.NewResponseController(rw).Flush()
+		if w.Header().Get("Content-Encoding") != "gzip" {
+			t.Fatal("response not gzipped")
+		}
+		zr, err := gzip.NewReader(bytes.NewReader(w.Body.Bytes()))
+		if err != nil {
+			t.Fatalf("gzip.NewReader: %v", err)
+		}
+		got := make([]byte, len(first))
+		if _, err := io.ReadFull(zr, got); err != nil || string(got) != first {
+			t.Errorf("flushed gzip stream = %q, %v, want %q", got, err, first)
+		}
+		io.WriteString(rw, "b")
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	h.ServeHTTP(w, r)
+	if got := gunzip(t, w); got != first+"b" {
+		t.Errorf("body = %q, want %q", got, first+"b")
+	}
+}
+
+func TestCompressionHeaders(t *testing.T) {
+	tests := []struct {
+		name     string
+		accept   string
+		etag     string
+		wantVary bool
+		wantETag string
+	}{
+		{"strong etag weakened", "gzip", `"v1"`, true, `W/"v1"`},
+		{"weak etag kept", "gzip", `W/"v1"`, true, `W/"v1"`},
+		{"not gzipped", "identity", `"v1"`, false, `"v1"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := withCompression(compressDefaults, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				w.Header().Set("Vary", "Origin")
+				w.Header().Set("ETag", tt.etag)
+				io.WriteString(w, strings.Repeat("x", 2048))
+			}))
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Accept-Encoding", tt.accept)
+			w := serve(h, r)
+
+			vary := w.Header().Values("Vary")
+			if hasVary := len(vary) == 2 && vary[0] == "Origin" && vary[1] == "Accept-Encoding"; hasVary != tt.wantVary {
+				t.Errorf("Vary = %q, want Accept-Encoding added: %v", vary, tt.wantVary)
+			}
+			if got := w.Header().Get("ETag"); got != tt.wantETag {
+				t.Errorf("ETag = %s, want %s", got, tt.wantETag)
+			}
+		})
+	}
+}
+
+// TestCompressionVaryBelowMinSize checks that a compressible type gets Vary
+// even when this response is too small to be gzipped, as a larger one for
+// the same URL would be.
+func TestCompressionVaryBelowMinSize(t *testing.T) {
+	h := withCompression(compressDefaults, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, "short")
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := serve(h, r)
+	if w.Header().Get("Content-Encoding") != "" {
+		t.Fatal("short response gzipped")
+	}
+	if got := w.Header().Get("Vary"); got != "Accept-Encoding" {
+		t.Errorf("Vary = %q, want Accept-Encoding", got)
+	}
+}
@@ -0,0 +1,65 @@
+package balancer
+
+import (
+	"net/http"
+	"time"
+)
+
+// ConcurrencyLimit caps the number of requests proxied at once across all
+// pools. A request over the limit waits up to Wait for a slot to free up
+// before it is refused. Upgraded connections hold their slot until closed.
+type ConcurrencyLimit struct {
+	Wait time.Duration
+
+	slots chan struct{}
+	clock Clock
+}
+
+func NewConcurrencyLimit(max int, wait time.Duration) *ConcurrencyLimit {
+	return &ConcurrencyLimit{Wait: wait, slots: make(chan struct{}, max), clock: realClock{}}
+}
+
+// acquire takes a slot, waiting up to l.Wait or until the client goes away,
+// and reports whether it got one.
+func (l *ConcurrencyLimit) acquire(r *http.Request) bool {
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+	if l.Wait <= 0 {
+		return false
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-l.clock.After(l.Wait):
+	case <-r.Context().Done():
+	}
+	return false
+}
+
+func (l *ConcurrencyLimit) release() {
+	<-l.slots
+}
+
+// limitConcurrency counts r as in flight and takes a slot of the concurrency
+// limit for it, responding 503 when none is available. The returned release
+// must be called once r is done, including when ok is false. The retries and
+// failovers of r run within its slot.
+func (l *LoadBalancer) limitConcurrency(w http.ResponseWriter, r *http.Request) (release func(), ok bool) {
+	inFlightRequests.Inc()
+	limit := l.concurrencyLimit
+	if limit == nil {
+		return inFlightRequests.Dec, true
+	}
+	if !limit.acquire(r) {
+		concurrencyRejectedTotal.Inc()
+		http.Error(w, "Too many concurrent requests", http.StatusServiceUnavailable)
+		return inFlightRequests.Dec, false
+	}
+	return func() {
+		limit.release()
+		inFlightRequests.Dec()
+	}, true
+}
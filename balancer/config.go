@@ -0,0 +1,1020 @@
+package balancer
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds every setting of the load balancer. It is populated from
+// flags and optionally overridden by a YAML or JSON file.
+type Config struct {
+	Port                     int                   `yaml:"port"`
+	Bind                     string                `yaml:"bind"`
+	Listen                   []string              `yaml:"listen"`
+	Strategy                 string                `yaml:"strategy"`
+	HealthPath               string                `yaml:"health_path"`
+	HealthStatus             string                `yaml:"health_status"`
+	HealthRise               int                   `yaml:"health_rise"`
+	HealthFall               int                   `yaml:"health_fall"`
+	SlowStart                time.Duration         `yaml:"slow_start"`
+	HealthInterval           time.Duration         `yaml:"health_interval"`
+	HealthBackoffMax         time.Duration         `yaml:"health_backoff_max"`
+	HealthBackoffFactor      float64               `yaml:"health_backoff_factor"`
+	HealthConcurrency        int                   `yaml:"health_concurrency"`
+	WarmupTimeout            time.Duration         `yaml:"warmup_timeout"`
+	WarmupRequireHealthy     bool                  `yaml:"warmup_require_healthy"`
+	ShutdownTimeout          time.Duration         `yaml:"shutdown_timeout"`
+	DrainDelay               time.Duration         `yaml:"drain_delay"`
+	DrainTimeout             time.Duration         `yaml:"drain_timeout"`
+	StatsPath                string                `yaml:"stats_path"`
+	MetricsPath              string                `yaml:"metrics_path"`
+	LivezPath                string                `yaml:"livez_path"`
+	ReadyzPath               string                `yaml:"readyz_path"`
+	VersionPath              string                `yaml:"version_path"`
+	VersionDetails           bool                  `yaml:"version_details"`
+	StickyCookie             string                `yaml:"sticky_cookie"`
+	StickyTTL                time.Duration         `yaml:"sticky_ttl"`
+	TrustForwardedFor        bool                  `yaml:"trust_forwarded_for"`
+	NoRetry                  bool                  `yaml:"no_retry"`
+	MaxRetries               int                   `yaml:"max_retries"`
+	RetryNonIdempotent       bool                  `yaml:"retry_non_idempotent"`
+	RetryMaxBody             int64                 `yaml:"retry_max_body"`
+	FailFast                 bool                  `yaml:"fail_fast"`
+	MaxAttempts              int                   `yaml:"max_attempts"`
+	RetryBackoff             time.Duration         `yaml:"retry_backoff"`
+	RetryBackoffMax          time.Duration         `yaml:"retry_backoff_max"`
+	RetryBackoffStrategy     string                `yaml:"retry_backoff_strategy"`
+	RetryBudgetRatio         float64               `yaml:"retry_budget"`
+	RetryBudgetWindow        time.Duration         `yaml:"retry_budget_window"`
+	BreakerFailures          int                   `yaml:"breaker_failures"`
+	BreakerCooldown          time.Duration         `yaml:"breaker_cooldown"`
+	TLSCert                  string                `yaml:"tls_cert"`
+	TLSKey                   string                `yaml:"tls_key"`
+	HTTPRedirectPort         int                   `yaml:"http_redirect_port"`
+	ClientH2C                bool                  `yaml:"client_h2c"`
+	ProxyProtocol            bool                  `yaml:"proxy_protocol"`
+	DisableHTTP2             bool                  `yaml:"disable_http2"`
+	ErrorWeightSensitivity   float64               `yaml:"error_weight_sensitivity"`
+	ErrorWeightWindow        int                   `yaml:"error_weight_window"`
+	OutlierInterval          time.Duration         `yaml:"outlier_interval"`
+	OutlierErrorRate         float64               `yaml:"outlier_error_rate"`
+	OutlierLatencyFactor     float64               `yaml:"outlier_latency_factor"`
+	OutlierMinRequests       int                   `yaml:"outlier_min_requests"`
+	OutlierBaseEjection      time.Duration         `yaml:"outlier_base_ejection"`
+	OutlierMaxEjection       time.Duration         `yaml:"outlier_max_ejection"`
+	OutlierMaxEjectedPercent int                   `yaml:"outlier_max_ejected_percent"`
+	PassiveFailures          int                   `yaml:"passive_failures"`
+	Passive5xx               bool                  `yaml:"passive_5xx"`
+	PassiveHeader            string                `yaml:"passive_header"`
+	PassiveHeaderValues      []string              `yaml:"passive_header_values"`
+	LogLevel                 string                `yaml:"log_level"`
+	LogFormat                string                `yaml:"log_format"`
+	AccessLog                string                `yaml:"access_log"`
+	RequestIDHeader          string                `yaml:"request_id_header"`
+	TrustRequestID           bool                  `yaml:"trust_request_id"`
+	Tracing                  bool                  `yaml:"tracing"`
+	LatencyWindow            int                   `yaml:"latency_window"`
+	AdminToken               string                `yaml:"admin_token"`
+	BackendOverride          bool                  `yaml:"backend_override"`
+	WeightOverrideTTL        time.Duration         `yaml:"weight_override_ttl"`
+	WeightOverrideMax        int                   `yaml:"weight_override_max"`
+	DialTimeout              time.Duration         `yaml:"dial_timeout"`
+	ResponseHeaderTimeout    time.Duration         `yaml:"response_header_timeout"`
+	RequestTimeout           time.Duration         `yaml:"request_timeout"`
+	TotalTimeout             time.Duration         `yaml:"total_timeout"`
+	MaxIdleConns             int                   `yaml:"max_idle_conns"`
+	MaxIdleConnsPerHost      int                   `yaml:"max_idle_conns_per_host"`
+	IdleConnTimeout          time.Duration         `yaml:"idle_conn_timeout"`
+	IsolateTransports        bool                  `yaml:"isolate_transports"`
+	ProxyBufferSize          int                   `yaml:"proxy_buffer_size"`
+	MaxRequestBytes          int64                 `yaml:"max_request_bytes"`
+	MaxHeaderBytes           int                   `yaml:"max_header_bytes"`
+	Compress                 bool                  `yaml:"compress"`
+	CompressTypes            []string              `yaml:"compress_types"`
+	CompressMinSize          int                   `yaml:"compress_min_size"`
+	ResponseHeaders          HeaderRules           `yaml:"response_headers"`
+	CORS                     CORSPolicy            `yaml:"cors"`
+	PreserveHost             bool                  `yaml:"preserve_host"`
+	HostOverride             string                `yaml:"host_override"`
+	MaxResponseBytes         int64                 `yaml:"max_response_bytes"`
+	UnavailableStatus        int                   `yaml:"unavailable_status"`
+	UnavailableBody          string                `yaml:"unavailable_body"`
+	UnavailableBodyFile      string                `yaml:"unavailable_body_file"`
+	UnavailableContentType   string                `yaml:"unavailable_content_type"`
+	UnavailableRetryAfter    time.Duration         `yaml:"unavailable_retry_after"`
+	BadGatewayStatus         int                   `yaml:"bad_gateway_status"`
+	RateLimit                float64               `yaml:"rate_limit"`
+	MaxConcurrent            int                   `yaml:"max_concurrent"`
+	MaxConcurrentWait        time.Duration         `yaml:"max_concurrent_wait"`
+	QueueWait                time.Duration         `yaml:"queue_wait"`
+	RateBurst                int                   `yaml:"rate_burst"`
+	ReapInterval             time.Duration         `yaml:"reap_interval"`
+	MirrorUrl                string                `yaml:"mirror_url"`
+	MirrorPercent            float64               `yaml:"mirror_percent"`
+	MirrorMaxBody            int64                 `yaml:"mirror_max_body"`
+	MirrorMaxInFlight        int                   `yaml:"mirror_max_in_flight"`
+	StateTTL                 time.Duration         `yaml:"state_ttl"`
+	ForwardedHeaders         bool                  `yaml:"forwarded_headers"`
+	TrustedProxies           []string              `yaml:"trusted_proxies"`
+	HashKey                  string                `yaml:"hash_key"`
+	HashVNodes               int                   `yaml:"hash_vnodes"`
+	DNSDiscovery             string                `yaml:"dns_discovery"`
+	DNSSRV                   bool                  `yaml:"dns_srv"`
+	DNSInterval              time.Duration         `yaml:"dns_interval"`
+	Backends                 []BackendConfig       `yaml:"backends"`
+	Pools                    map[string]PoolConfig `yaml:"pools"`
+	Routes                   []RouteConfig         `yaml:"routes"`
+}
+
+// PoolConfig describes a named pool of backends. Empty settings are
+// inherited from the top-level ones, which also make up the default pool;
+// the timeouts are only inherited when left out, so a pool can set 0 to have
+// no limit whatever the top-level ones.
+type PoolConfig struct {
+	Strategy              string          `yaml:"strategy" json:"strategy"`
+	HealthPath            string          `yaml:"health_path" json:"health_path"`
+	HealthStatus          string          `yaml:"health_status" json:"health_status"`
+	HealthRise            int             `yaml:"health_rise" json:"health_rise"`
+	HealthFall            int             `yaml:"health_fall" json:"health_fall"`
+	StickyCookie          string          `yaml:"sticky_cookie" json:"sticky_cookie"`
+	StickyTTL             time.Duration   `yaml:"sticky_ttl" json:"sticky_ttl"`
+	DialTimeout           *time.Duration  `yaml:"dial_timeout" json:"dial_timeout"`
+	ResponseHeaderTimeout *time.Duration  `yaml:"response_header_timeout" json:"response_header_timeout"`
+	RequestTimeout        *time.Duration  `yaml:"request_timeout" json:"request_timeout"`
+	TotalTimeout          *time.Duration  `yaml:"total_timeout" json:"total_timeout"`
+	ResponseHeaders       HeaderRules     `yaml:"response_headers" json:"response_headers"`
+	CORS                  CORSPolicy      `yaml:"cors" json:"cors"`
+	PreserveHost          *bool           `yaml:"preserve_host" json:"preserve_host"`
+	HostOverride          string          `yaml:"host_override" json:"host_override"`
+	Backends              []BackendConfig `yaml:"backends" json:"backends"`
+}
+
+// RouteConfig sends requests for Host whose path starts with Prefix to Pool.
+// Host may start with "*." to match any subdomain; either may be left empty.
+// StripPrefix removes Prefix from the path before proxying, so /api/users
+// reaches the backends of a route for /api as /users.
+type RouteConfig struct {
+	Host        string `yaml:"host" json:"host"`
+	Prefix      string `yaml:"prefix" json:"prefix"`
+	StripPrefix bool   `yaml:"strip_prefix" json:"strip_prefix"`
+	Pool        string `yaml:"pool" json:"pool"`
+}
+
+// BackendConfig describes one backend. H2C talks HTTP/2 without TLS to it,
+// as needed for gRPC; clients then have to reach the load balancer over
+// HTTP/2 as well, which the listener accepts as soon as one backend is h2c.
+// gRPC multiplexes every call of a client on one connection, but the load
+// balancer picks a backend per call, so the strategy still spreads calls
+// rather than pinning a whole client connection to one backend.
+// HealthAddress sends health checks to another host:port, or URL for another
+// scheme, than the traffic, e.g. a dedicated management port. TLS configures
+// the connections to an https backend, see BackendTLS. PreserveHost and
+// HostOverride override the HostRewrite of the pool for the backend.
+// Priority puts the backend in a failover tier, see ServerPool.GetNextPeer.
+type BackendConfig struct {
+	Url            string     `yaml:"url" json:"url"`
+	Weight         int        `yaml:"weight" json:"weight"`
+	HealthPath     string     `yaml:"health_path" json:"health_path"`
+	HealthStatus   string     `yaml:"health_status" json:"health_status"`
+	HealthAddress  string     `yaml:"health_address" json:"health_address"`
+	TLS            BackendTLS `yaml:"tls" json:"tls"`
+	PreserveHost   *bool      `yaml:"preserve_host" json:"preserve_host"`
+	HostOverride   string     `yaml:"host_override" json:"host_override"`
+	MaxConnections int        `yaml:"max_connections" json:"max_connections"`
+	Priority       int        `yaml:"priority" json:"priority"`
+	H2C            bool       `yaml:"h2c" json:"h2c"`
+}
+
+// defaultConfig holds the defaults of the settings.
+var defaultConfig = Config{
+	Port:                     3030,
+	Strategy:                 string(RoundRobin),
+	HealthStatus:             "200-299",
+	HealthRise:               defaultHealthCheck.Rise,
+	HealthFall:               defaultHealthCheck.Fall,
+	HealthConcurrency:        10,
+	HealthInterval:           2 * time.Minute,
+	HealthBackoffFactor:      2,
+	StatsPath:                "/lb/stats",
+	ShutdownTimeout:          30 * time.Second,
+	MetricsPath:              "/metrics",
+	StickyTTL:                time.Hour,
+	MaxRetries:               3,
+	MaxAttempts:              3,
+	RetryBudgetWindow:        10 * time.Second,
+	RetryMaxBody:             64 << 10,
+	RetryBackoff:             10 * time.Millisecond,
+	RetryBackoffMax:          time.Second,
+	RetryBackoffStrategy:     string(FixedBackoff),
+	BreakerCooldown:          30 * time.Second,
+	ErrorWeightWindow:        100,
+	OutlierMinRequests:       10,
+	OutlierBaseEjection:      30 * time.Second,
+	OutlierMaxEjection:       5 * time.Minute,
+	OutlierMaxEjectedPercent: 10,
+	Passive5xx:               true,
+	LogLevel:                 "info",
+	LogFormat:                "text",
+	LatencyWindow:            1024,
+	DialTimeout:              upstreamTimeouts.Dial,
+	ResponseHeaderTimeout:    upstreamTimeouts.ResponseHeader,
+	RequestTimeout:           upstreamTimeouts.Request,
+	TotalTimeout:             upstreamTimeouts.Total,
+	MaxIdleConns:             100,
+	MaxIdleConnsPerHost:      http.DefaultMaxIdleConnsPerHost,
+	IdleConnTimeout:          90 * time.Second,
+	ForwardedHeaders:         true,
+	HashKey:                  "path",
+	HashVNodes:               160,
+	DNSInterval:              30 * time.Second,
+	LivezPath:                "/livez",
+	ReadyzPath:               "/readyz",
+	VersionPath:              "/lb/version",
+	VersionDetails:           true,
+	ProxyBufferSize:          32 * 1024,
+	MaxHeaderBytes:           http.DefaultMaxHeaderBytes,
+	CompressTypes:            compressDefaults.Types,
+	CompressMinSize:          compressDefaults.MinSize,
+	RequestIDHeader:          "X-Request-ID",
+	TrustRequestID:           true,
+	UnavailableStatus:        http.StatusServiceUnavailable,
+	BadGatewayStatus:         http.StatusBadGateway,
+	ReapInterval:             time.Minute,
+	WeightOverrideTTL:        5 * time.Minute,
+	WeightOverrideMax:        100,
+	PreserveHost:             true,
+	MirrorPercent:            100,
+	MirrorMaxBody:            mirrorDefaults.MaxBody,
+	MirrorMaxInFlight:        mirrorDefaults.MaxInFlight,
+}
+
+// DefaultConfig returns the settings used when nothing else is configured,
+// which are also the defaults of the command line flags. It has no backends.
+func DefaultConfig() Config {
+	return defaultConfig
+}
+
+// LoadFile decodes the file at path on top of c, so any setting present in
+// the file takes precedence over the current value. YAML is a superset of
+// JSON, so both formats are accepted.
+func (c *Config) LoadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate reports every invalid setting, one per line, prefixed with the
+// offending field.
+func (c *Config) Validate() error {
+	v := &validator{}
+	fail := v.fail
+
+	if c.Port <= 0 || c.Port > 65535 {
+		fail("port", "must be between 1 and 65535, got %d", c.Port)
+	}
+	if c.Bind != "" {
+		host, port := c.splitBind()
+		if host == "" || strings.ContainsAny(host, "[]/ ") {
+			fail("bind", "must be a host or host:port, got %q", c.Bind)
+		} else if port != "" {
+			if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+				fail("bind", "invalid port in %q", c.Bind)
+			} else if len(c.Listen) > 0 {
+				fail("bind", "must not have a port when listen is set, got %q", c.Bind)
+			}
+		}
+	}
+	for i, addr := range c.Listen {
+		if _, port, err := net.SplitHostPort(addr); err != nil {
+			fail(fmt.Sprintf("listen[%d]", i), "%s", err)
+		} else if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+			fail(fmt.Sprintf("listen[%d]", i), "invalid port in %q", addr)
+		}
+	}
+	if _, err := ParseStrategy(c.Strategy); err != nil {
+		fail("strategy", "%s", err)
+	}
+	if _, _, err := parseStatusRange(c.HealthStatus); err != nil {
+		fail("health_status", "%s", err)
+	}
+	if c.HealthRise <= 0 {
+		fail("health_rise", "must be positive, got %d", c.HealthRise)
+	}
+	if c.HealthFall <= 0 {
+		fail("health_fall", "must be positive, got %d", c.HealthFall)
+	}
+	if c.SlowStart < 0 {
+		fail("slow_start", "must not be negative, got %s", c.SlowStart)
+	}
+	if c.HealthInterval <= 0 {
+		fail("health_interval", "must be positive, got %s", c.HealthInterval)
+	}
+	if c.HealthBackoffMax != 0 && c.HealthBackoffMax < c.HealthInterval {
+		fail("health_backoff_max", "must be 0 or at least health_interval %s, got %s", c.HealthInterval, c.HealthBackoffMax)
+	}
+	if c.HealthBackoffFactor <= 1 {
+		fail("health_backoff_factor", "must be greater than 1, got %g", c.HealthBackoffFactor)
+	}
+	if c.HealthConcurrency <= 0 {
+		fail("health_concurrency", "must be positive, got %d", c.HealthConcurrency)
+	}
+	if c.WarmupTimeout < 0 {
+		fail("warmup_timeout", "must not be negative, got %s", c.WarmupTimeout)
+	}
+	if c.WarmupRequireHealthy && c.WarmupTimeout == 0 {
+		fail("warmup_require_healthy", "needs warmup_timeout")
+	}
+	if c.WeightOverrideTTL <= 0 {
+		fail("weight_override_ttl", "must be positive, got %s", c.WeightOverrideTTL)
+	}
+	if c.WeightOverrideMax < 1 {
+		fail("weight_override_max", "must be at least 1, got %d", c.WeightOverrideMax)
+	}
+	if c.ShutdownTimeout < 0 {
+		fail("shutdown_timeout", "must not be negative, got %s", c.ShutdownTimeout)
+	}
+	if c.DrainDelay < 0 {
+		fail("drain_delay", "must not be negative, got %s", c.DrainDelay)
+	}
+	if c.DrainTimeout < 0 {
+		fail("drain_timeout", "must not be negative, got %s", c.DrainTimeout)
+	}
+	if c.StickyTTL < 0 {
+		fail("sticky_ttl", "must not be negative, got %s", c.StickyTTL)
+	}
+	if c.MaxRetries < 0 {
+		fail("max_retries", "must not be negative, got %d", c.MaxRetries)
+	}
+	if c.MaxAttempts < 0 {
+		fail("max_attempts", "must not be negative, got %d", c.MaxAttempts)
+	}
+	if c.RetryMaxBody < 0 {
+		fail("retry_max_body", "must not be negative, got %d", c.RetryMaxBody)
+	}
+	if c.RetryBudgetRatio < 0 {
+		fail("retry_budget", "must not be negative, got %g", c.RetryBudgetRatio)
+	}
+	if c.RetryBudgetWindow < time.Millisecond {
+		fail("retry_budget_window", "must be at least 1ms, got %s", c.RetryBudgetWindow)
+	}
+	if c.RetryBackoff < 0 {
+		fail("retry_backoff", "must not be negative, got %s", c.RetryBackoff)
+	}
+	if c.RetryBackoffMax < c.RetryBackoff {
+		fail("retry_backoff_max", "must not be below retry_backoff %s, got %s", c.RetryBackoff, c.RetryBackoffMax)
+	}
+	if _, err := ParseBackoffStrategy(c.RetryBackoffStrategy); err != nil {
+		fail("retry_backoff_strategy", "%s", err)
+	}
+	if c.LatencyWindow <= 0 {
+		fail("latency_window", "must be positive, got %d", c.LatencyWindow)
+	}
+	if c.DialTimeout < 0 {
+		fail("dial_timeout", "must not be negative, got %s", c.DialTimeout)
+	}
+	if c.ResponseHeaderTimeout < 0 {
+		fail("response_header_timeout", "must not be negative, got %s", c.ResponseHeaderTimeout)
+	}
+	if c.RequestTimeout < 0 {
+		fail("request_timeout", "must not be negative, got %s", c.RequestTimeout)
+	}
+	if c.ProxyBufferSize <= 0 {
+		fail("proxy_buffer_size", "must be positive, got %d", c.ProxyBufferSize)
+	}
+	if c.MaxRequestBytes < 0 {
+		fail("max_request_bytes", "must not be negative, got %d", c.MaxRequestBytes)
+	}
+	if c.MaxHeaderBytes <= 0 {
+		fail("max_header_bytes", "must be positive, got %d", c.MaxHeaderBytes)
+	}
+	if c.CompressMinSize < 0 {
+		fail("compress_min_size", "must not be negative, got %d", c.CompressMinSize)
+	}
+	for i, t := range c.CompressTypes {
+		if _, _, err := mime.ParseMediaType(t); err != nil || strings.Contains(t, ";") || strings.Count(t, "/") != 1 {
+			fail(fmt.Sprintf("compress_types[%d]", i), "must be a media type such as text/html, or text/* for every subtype, got %q", t)
+		}
+	}
+	if c.MaxResponseBytes < 0 {
+		fail("max_response_bytes", "must not be negative, got %d", c.MaxResponseBytes)
+	}
+	if c.UnavailableStatus < 400 || c.UnavailableStatus > 599 {
+		fail("unavailable_status", "must be an error status between 400 and 599, got %d", c.UnavailableStatus)
+	}
+	if c.BadGatewayStatus < 400 || c.BadGatewayStatus > 599 {
+		fail("bad_gateway_status", "must be an error status between 400 and 599, got %d", c.BadGatewayStatus)
+	}
+	if c.UnavailableBodyFile != "" {
+		if _, err := os.Stat(c.UnavailableBodyFile); err != nil {
+			fail("unavailable_body_file", "%s", err)
+		}
+	}
+	if c.UnavailableRetryAfter < 0 {
+		fail("unavailable_retry_after", "must not be negative, got %s", c.UnavailableRetryAfter)
+	}
+	if c.MaxConcurrent < 0 {
+		fail("max_concurrent", "must not be negative, got %d", c.MaxConcurrent)
+	}
+	if c.MaxConcurrentWait < 0 {
+		fail("max_concurrent_wait", "must not be negative, got %s", c.MaxConcurrentWait)
+	}
+	if c.MirrorUrl != "" {
+		if u, err := normalizeBackendUrl(c.MirrorUrl); err != nil || strings.HasPrefix(u, "unix:") {
+			fail("mirror_url", "must be an http or https URL without a path, got %q", c.MirrorUrl)
+		} else {
+			c.MirrorUrl = u
+		}
+	}
+	if c.MirrorPercent <= 0 || c.MirrorPercent > 100 {
+		fail("mirror_percent", "must be above 0 and at most 100, got %g", c.MirrorPercent)
+	}
+	if c.MirrorMaxBody < 0 {
+		fail("mirror_max_body", "must not be negative, got %d", c.MirrorMaxBody)
+	}
+	if c.MirrorMaxInFlight <= 0 {
+		fail("mirror_max_in_flight", "must be positive, got %d", c.MirrorMaxInFlight)
+	}
+	if c.ReapInterval <= 0 {
+		fail("reap_interval", "must be positive, got %s", c.ReapInterval)
+	}
+	if c.StateTTL < 0 {
+		fail("state_ttl", "must not be negative, got %s", c.StateTTL)
+	}
+	if c.QueueWait < 0 {
+		fail("queue_wait", "must not be negative, got %s", c.QueueWait)
+	}
+	if c.RateLimit < 0 {
+		fail("rate_limit", "must not be negative, got %g", c.RateLimit)
+	}
+	if c.RateBurst < 0 {
+		fail("rate_burst", "must not be negative, got %d", c.RateBurst)
+	}
+	for i, p := range c.TrustedProxies {
+		if _, err := parsePrefix(p); err != nil {
+			fail(fmt.Sprintf("trusted_proxies[%d]", i), "%s", err)
+		}
+	}
+	if err := parseHashKey(c.HashKey); err != nil {
+		fail("hash_key", "%s", err)
+	}
+	if c.HashVNodes <= 0 {
+		fail("hash_vnodes", "must be positive, got %d", c.HashVNodes)
+	}
+	if c.TotalTimeout < 0 {
+		fail("total_timeout", "must not be negative, got %s", c.TotalTimeout)
+	}
+	if c.MaxIdleConns < 0 {
+		fail("max_idle_conns", "must not be negative, got %d", c.MaxIdleConns)
+	}
+	if c.MaxIdleConnsPerHost <= 0 {
+		fail("max_idle_conns_per_host", "must be positive, got %d", c.MaxIdleConnsPerHost)
+	}
+	if c.IdleConnTimeout < 0 {
+		fail("idle_conn_timeout", "must not be negative, got %s", c.IdleConnTimeout)
+	}
+	if c.BreakerFailures < 0 {
+		fail("breaker_failures", "must not be negative, got %d", c.BreakerFailures)
+	}
+	if c.BreakerCooldown < 0 {
+		fail("breaker_cooldown", "must not be negative, got %s", c.BreakerCooldown)
+	}
+	if c.ErrorWeightSensitivity < 0 || c.ErrorWeightSensitivity > 1 {
+		fail("error_weight_sensitivity", "must be between 0 and 1, got %g", c.ErrorWeightSensitivity)
+	}
+	if c.ErrorWeightWindow <= 0 {
+		fail("error_weight_window", "must be positive, got %d", c.ErrorWeightWindow)
+	}
+	if c.OutlierInterval < 0 {
+		fail("outlier_interval", "must not be negative, got %s", c.OutlierInterval)
+	}
+	if c.OutlierInterval > 0 && c.OutlierErrorRate == 0 && c.OutlierLatencyFactor == 0 {
+		fail("outlier_interval", "needs outlier_error_rate or outlier_latency_factor")
+	}
+	if c.OutlierErrorRate < 0 || c.OutlierErrorRate > 1 {
+		fail("outlier_error_rate", "must be between 0 and 1, got %g", c.OutlierErrorRate)
+	}
+	if c.OutlierLatencyFactor != 0 && c.OutlierLatencyFactor <= 1 {
+		fail("outlier_latency_factor", "must be 0 or greater than 1, got %g", c.OutlierLatencyFactor)
+	}
+	if c.OutlierMinRequests <= 0 {
+		fail("outlier_min_requests", "must be positive, got %d", c.OutlierMinRequests)
+	}
+	if c.OutlierBaseEjection <= 0 {
+		fail("outlier_base_ejection", "must be positive, got %s", c.OutlierBaseEjection)
+	}
+	if c.OutlierMaxEjection < c.OutlierBaseEjection {
+		fail("outlier_max_ejection", "must be at least outlier_base_ejection %s, got %s", c.OutlierBaseEjection, c.OutlierMaxEjection)
+	}
+	if c.OutlierMaxEjectedPercent < 0 || c.OutlierMaxEjectedPercent > 100 {
+		fail("outlier_max_ejected_percent", "must be between 0 and 100, got %d", c.OutlierMaxEjectedPercent)
+	}
+	if c.PassiveFailures < 0 {
+		fail("passive_failures", "must not be negative, got %d", c.PassiveFailures)
+	}
+	if c.PassiveHeader != "" && len(c.PassiveHeaderValues) == 0 {
+		fail("passive_header_values", "at least one value is required with passive_header")
+	}
+	if _, err := parseLogLevel(c.LogLevel); err != nil {
+		fail("log_level", "%s", err)
+	}
+	if _, err := parseLogFormat(c.LogFormat); err != nil {
+		fail("log_format", "%s", err)
+	}
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		fail("tls_cert", "tls_cert and tls_key must be set together")
+	}
+	if c.HTTPRedirectPort < 0 || c.HTTPRedirectPort > 65535 {
+		fail("http_redirect_port", "must be between 0 and 65535, got %d", c.HTTPRedirectPort)
+	} else if c.HTTPRedirectPort > 0 && c.HTTPRedirectPort == c.Port {
+		fail("http_redirect_port", "must differ from port %d", c.Port)
+	}
+	if c.DisableHTTP2 && c.ClientH2C {
+		fail("client_h2c", "conflicts with disable_http2")
+	} else if c.DisableHTTP2 && c.usesH2C() {
+		fail("disable_http2", "h2c backends need HTTP/2 clients")
+	}
+	total := len(c.Backends)
+	if c.DNSDiscovery != "" {
+		if u, err := url.Parse(c.DNSDiscovery); err != nil {
+			fail("dns_discovery", "%s", err)
+		} else if u.Scheme != "http" && u.Scheme != "https" || u.Hostname() == "" {
+			fail("dns_discovery", "must be an http or https URL with a host name, got %q", c.DNSDiscovery)
+		}
+		if c.DNSInterval <= 0 {
+			fail("dns_interval", "must be positive, got %s", c.DNSInterval)
+		}
+		//backends may all come from DNS
+		total++
+	}
+	c.ResponseHeaders.validate(v, "response_headers.")
+	if c.RequestIDHeader != "" {
+		validateHeader(v, "request_id_header", c.RequestIDHeader, "")
+	}
+	c.CORS.validate(v, "cors.")
+	validateHost(v, "host_override", c.HostOverride)
+	validateBackends(v, "", c.Backends)
+	names := make([]string, 0, len(c.Pools))
+	for name := range c.Pools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		pc := c.Pools[name]
+		prefix := fmt.Sprintf("pools.%s.", name)
+		if name == defaultPool {
+			fail("pools", "%q is reserved for the top-level backends", defaultPool)
+			continue
+		}
+		pc.validate(v, prefix)
+		total += len(pc.Backends)
+		validateBackends(v, prefix, pc.Backends)
+	}
+	if total == 0 {
+		fail("backends", "at least one backend is required")
+	}
+	seen := make(map[RouteConfig]bool, len(c.Routes))
+	for i, rc := range c.Routes {
+		prefix := fmt.Sprintf("routes[%d].", i)
+		if rc.Host == "" && rc.Prefix == "" {
+			fail(prefix+"host", "host or prefix is required")
+		}
+		if rc.Prefix != "" && !strings.HasPrefix(rc.Prefix, "/") {
+			fail(prefix+"prefix", "must start with /, got %q", rc.Prefix)
+		}
+		if rc.StripPrefix && rc.Prefix == "" {
+			fail(prefix+"strip_prefix", "needs a prefix")
+		}
+		if strings.Contains(strings.TrimPrefix(rc.Host, "*."), "*") || strings.ContainsAny(rc.Host, ":/") {
+			fail(prefix+"host", "must be a host name without port, optionally starting with *., got %q", rc.Host)
+		}
+		key := RouteConfig{Host: strings.ToLower(rc.Host), Prefix: rc.Prefix}
+		if seen[key] {
+			fail(prefix+"prefix", "duplicate route for host %q and prefix %q", rc.Host, rc.Prefix)
+		}
+		seen[key] = true
+		if _, ok := c.Pools[rc.Pool]; !ok && (rc.Pool != defaultPool || !c.hasDefaultPool()) {
+			fail(prefix+"pool", "unknown pool %q", rc.Pool)
+		}
+	}
+	return v.err()
+}
+
+// validate reports the invalid settings of a named pool, prefixing fields
+// with prefix.
+func (pc *PoolConfig) validate(v *validator, prefix string) {
+	fail := v.fail
+	if pc.Strategy != "" {
+		if _, err := ParseStrategy(pc.Strategy); err != nil {
+			fail(prefix+"strategy", "%s", err)
+		}
+	}
+	if pc.HealthStatus != "" {
+		if _, _, err := parseStatusRange(pc.HealthStatus); err != nil {
+			fail(prefix+"health_status", "%s", err)
+		}
+	}
+	if pc.HealthRise < 0 {
+		fail(prefix+"health_rise", "must not be negative, got %d", pc.HealthRise)
+	}
+	if pc.HealthFall < 0 {
+		fail(prefix+"health_fall", "must not be negative, got %d", pc.HealthFall)
+	}
+	if pc.StickyTTL < 0 {
+		fail(prefix+"sticky_ttl", "must not be negative, got %s", pc.StickyTTL)
+	}
+	if pc.DialTimeout != nil && *pc.DialTimeout < 0 {
+		fail(prefix+"dial_timeout", "must not be negative, got %s", *pc.DialTimeout)
+	}
+	if pc.ResponseHeaderTimeout != nil && *pc.ResponseHeaderTimeout < 0 {
+		fail(prefix+"response_header_timeout", "must not be negative, got %s", *pc.ResponseHeaderTimeout)
+	}
+	if pc.RequestTimeout != nil && *pc.RequestTimeout < 0 {
+		fail(prefix+"request_timeout", "must not be negative, got %s", *pc.RequestTimeout)
+	}
+	if pc.TotalTimeout != nil && *pc.TotalTimeout < 0 {
+		fail(prefix+"total_timeout", "must not be negative, got %s", *pc.TotalTimeout)
+	}
+	pc.ResponseHeaders.validate(v, prefix+"response_headers.")
+	pc.CORS.validate(v, prefix+"cors.")
+	validateHost(v, prefix+"host_override", pc.HostOverride)
+	if len(pc.Backends) == 0 {
+		fail(prefix+"backends", "at least one backend is required")
+	}
+}
+
+// Validate reports every invalid setting of a backend added at runtime.
+func (b *BackendConfig) Validate() error {
+	v := &validator{}
+	b.validate(v, "")
+	return v.err()
+}
+
+// validateBackends validates the backends of one pool in place and rejects
+// URLs that are the same once normalized.
+func validateBackends(v *validator, prefix string, backends []BackendConfig) {
+	seen := make(map[string]int, len(backends))
+	for i := range backends {
+		field := fmt.Sprintf("%sbackends[%d].", prefix, i)
+		backends[i].validate(v, field)
+		if first, ok := seen[backends[i].Url]; ok {
+			v.fail(field+"url", "duplicate of %sbackends[%d]: %s", prefix, first, backends[i].Url)
+			continue
+		}
+		seen[backends[i].Url] = i
+	}
+}
+
+// validate checks b and normalizes its URL.
+func (b *BackendConfig) validate(v *validator, prefix string) {
+	if b.Url == "" {
+		v.fail(prefix+"url", "is required")
+	} else if u, err := normalizeBackendUrl(b.Url); err != nil {
+		v.fail(prefix+"url", "%s", err)
+	} else {
+		b.Url = u
+	}
+	if b.HealthStatus != "" {
+		if _, _, err := parseStatusRange(b.HealthStatus); err != nil {
+			v.fail(prefix+"health_status", "%s", err)
+		}
+	}
+	if b.HealthAddress != "" {
+		if u, err := normalizeHealthAddress(b.HealthAddress, b.Url); err != nil {
+			v.fail(prefix+"health_address", "%s", err)
+		} else {
+			b.HealthAddress = u
+		}
+	}
+	b.TLS.validate(v, prefix, b.Url)
+	validateHost(v, prefix+"host_override", b.HostOverride)
+	if b.Weight < 0 {
+		v.fail(prefix+"weight", "must not be negative, got %d", b.Weight)
+	}
+	if b.MaxConnections < 0 {
+		v.fail(prefix+"max_connections", "must not be negative, got %d", b.MaxConnections)
+	}
+	if b.Priority < 0 {
+		v.fail(prefix+"priority", "must not be negative, got %d", b.Priority)
+	}
+}
+
+// normalizeBackendUrl checks that s is an http or https URL with a host and
+// nothing else, and returns it with a lowercase host and an explicit port so
+// that equivalent spellings compare equal. A unix URL must have an absolute
+// socket path and nothing else instead.
+func normalizeBackendUrl(s string) (string, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", err
+	}
+	if isUnixSocket(u) {
+		if u.Host != "" || !strings.HasPrefix(u.Path, "/") || u.RawQuery != "" || u.Fragment != "" {
+			return "", fmt.Errorf("%q must be unix:// followed by an absolute socket path", s)
+		}
+		normalized := url.URL{Scheme: "unix", Path: path.Clean(u.Path)}
+		return normalized.String(), nil
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("%q must start with http://, https:// or unix://", s)
+	}
+	if u.Hostname() == "" {
+		return "", fmt.Errorf("%q has no host", s)
+	}
+	if u.User != nil {
+		return "", fmt.Errorf("%q must not contain credentials", s)
+	}
+	if (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("%q must not have a path, query or fragment", s)
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	normalized := url.URL{Scheme: u.Scheme, Host: net.JoinHostPort(strings.ToLower(u.Hostname()), port)}
+	return normalized.String(), nil
+}
+
+// normalizeHealthAddress turns a health address into the URL it names. A
+// bare host:port takes the scheme of the backend URL, http for a socket.
+func normalizeHealthAddress(s, backendUrl string) (string, error) {
+	if !strings.Contains(s, "://") {
+		host, port, err := net.SplitHostPort(s)
+		if err != nil || host == "" || port == "" {
+			return "", fmt.Errorf("%q must be host:port or an http or https URL", s)
+		}
+		scheme := "http"
+		if strings.HasPrefix(backendUrl, "https://") {
+			scheme = "https"
+		}
+		s = scheme + "://" + s
+	}
+	u, err := normalizeBackendUrl(s)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(u, "unix:") {
+		return "", fmt.Errorf("%q must be host:port or an http or https URL", s)
+	}
+	return u, nil
+}
+
+// validator collects one error per invalid field.
+type validator struct {
+	errs []error
+}
+
+func (v *validator) fail(field string, format string, args ...interface{}) {
+	v.errs = append(v.errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+}
+
+func (v *validator) err() error {
+	return errors.Join(v.errs...)
+}
+
+// PoolConfigs returns every pool of a validated config, including the default
+// pool made of the top-level backends, with inherited settings filled in. The
+// default pool is left out when it has neither static nor discovered backends.
+func (c *Config) PoolConfigs() map[string]PoolConfig {
+	pools := make(map[string]PoolConfig, len(c.Pools)+1)
+	def := c.defaultPoolConfig()
+	if c.hasDefaultPool() {
+		pools[defaultPool] = def
+	}
+	for name, pc := range c.Pools {
+		if pc.Strategy == "" {
+			pc.Strategy = def.Strategy
+		}
+		if pc.HealthPath == "" {
+			pc.HealthPath = def.HealthPath
+		}
+		if pc.HealthStatus == "" {
+			pc.HealthStatus = def.HealthStatus
+		}
+		if pc.HealthRise == 0 {
+			pc.HealthRise = def.HealthRise
+		}
+		if pc.HealthFall == 0 {
+			pc.HealthFall = def.HealthFall
+		}
+		if pc.StickyCookie == "" {
+			pc.StickyCookie = def.StickyCookie
+			pc.StickyTTL = def.StickyTTL
+		}
+		if pc.DialTimeout == nil {
+			pc.DialTimeout = def.DialTimeout
+		}
+		if pc.ResponseHeaderTimeout == nil {
+			pc.ResponseHeaderTimeout = def.ResponseHeaderTimeout
+		}
+		if pc.RequestTimeout == nil {
+			pc.RequestTimeout = def.RequestTimeout
+		}
+		if pc.TotalTimeout == nil {
+			pc.TotalTimeout = def.TotalTimeout
+		}
+		if pc.ResponseHeaders.empty() {
+			pc.ResponseHeaders = def.ResponseHeaders
+		}
+		if !pc.CORS.enabled() {
+			pc.CORS = def.CORS
+		}
+		if pc.PreserveHost == nil {
+			pc.PreserveHost = def.PreserveHost
+		}
+		if pc.HostOverride == "" {
+			pc.HostOverride = def.HostOverride
+		}
+		pools[name] = pc
+	}
+	return pools
+}
+
+// defaultPoolConfig returns the default pool, made of the top-level settings
+// and backends.
+func (c *Config) defaultPoolConfig() PoolConfig {
+	preserveHost := c.PreserveHost
+	dial, responseHeader, request, total := c.DialTimeout, c.ResponseHeaderTimeout, c.RequestTimeout, c.TotalTimeout
+	return PoolConfig{
+		Strategy:              c.Strategy,
+		HealthPath:            c.HealthPath,
+		HealthStatus:          c.HealthStatus,
+		HealthRise:            c.HealthRise,
+		HealthFall:            c.HealthFall,
+		StickyCookie:          c.StickyCookie,
+		StickyTTL:             c.StickyTTL,
+		DialTimeout:           &dial,
+		ResponseHeaderTimeout: &responseHeader,
+		RequestTimeout:        &request,
+		TotalTimeout:          &total,
+		ResponseHeaders:       c.ResponseHeaders,
+		CORS:                  c.CORS,
+		PreserveHost:          &preserveHost,
+		HostOverride:          c.HostOverride,
+		Backends:              c.Backends,
+	}
+}
+
+// ListenAddrs returns the addresses to serve on.
+func (c *Config) ListenAddrs() []string {
+	host, port := c.splitBind()
+	if len(c.Listen) > 0 {
+		addrs := make([]string, len(c.Listen))
+		for i, addr := range c.Listen {
+			if h, p, err := net.SplitHostPort(addr); err == nil && h == "" {
+				addr = net.JoinHostPort(host, p)
+			}
+			addrs[i] = addr
+		}
+		return addrs
+	}
+	if port == "" {
+		port = strconv.Itoa(c.Port)
+	}
+	return []string{net.JoinHostPort(host, port)}
+}
+
+// RedirectAddr returns the address of the HTTP to HTTPS redirect listener.
+func (c *Config) RedirectAddr() string {
+	host, _ := c.splitBind()
+	return net.JoinHostPort(host, strconv.Itoa(c.HTTPRedirectPort))
+}
+
+// splitBind splits bind into a host and a port, which are empty when unset.
+func (c *Config) splitBind() (host, port string) {
+	if host, port, err := net.SplitHostPort(c.Bind); err == nil {
+		return host, port
+	}
+	return strings.Trim(c.Bind, "[]"), ""
+}
+
+// ServerProtocols returns the protocols spoken to clients: HTTP/1.1, and
+// HTTP/2 unless disabled. HTTP/2 is negotiated with ALPN over TLS; without
+// TLS clients must use it with prior knowledge, which is accepted with
+// client_h2c or as soon as a backend is h2c, as gRPC clients do that. The
+// reverse proxy translates between the client's and the backend's protocol.
+func (c *Config) ServerProtocols() *http.Protocols {
+	p := new(http.Protocols)
+	p.SetHTTP1(true)
+	if c.DisableHTTP2 {
+		return p
+	}
+	p.SetHTTP2(true)
+	if c.ClientH2C || c.usesH2C() {
+		p.SetUnencryptedHTTP2(true)
+	}
+	return p
+}
+
+// usesH2C reports whether any backend is reached over h2c.
+func (c *Config) usesH2C() bool {
+	for _, pc := range c.PoolConfigs() {
+		for _, bc := range pc.Backends {
+			if bc.H2C {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c *Config) hasDefaultPool() bool {
+	return len(c.Backends) > 0 || c.DNSDiscovery != ""
+}
+
+// HealthCheck returns the probe settings of a validated pool config.
+func (pc *PoolConfig) HealthCheck() HealthCheck {
+	hc := defaultHealthCheck
+	hc.Path = pc.HealthPath
+	hc.MinStatus, hc.MaxStatus, _ = parseStatusRange(pc.HealthStatus)
+	hc.Rise, hc.Fall = pc.HealthRise, pc.HealthFall
+	return hc
+}
+
+func (c *Config) OutlierDetection() OutlierDetection {
+	return OutlierDetection{
+		Interval:          c.OutlierInterval,
+		ErrorRate:         c.OutlierErrorRate,
+		LatencyFactor:     c.OutlierLatencyFactor,
+		MinRequests:       c.OutlierMinRequests,
+		BaseEjection:      c.OutlierBaseEjection,
+		MaxEjection:       c.OutlierMaxEjection,
+		MaxEjectedPercent: c.OutlierMaxEjectedPercent,
+	}
+}
+
+func (c *Config) RetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		Disabled:        c.NoRetry,
+		MaxRetries:      c.MaxRetries,
+		NonIdempotent:   c.RetryNonIdempotent,
+		MaxBody:         c.RetryMaxBody,
+		MaxAttempts:     c.MaxAttempts,
+		Backoff:         c.RetryBackoff,
+		MaxBackoff:      c.RetryBackoffMax,
+		BackoffStrategy: BackoffStrategy(c.RetryBackoffStrategy),
+	}
+}
+
+// TrustedProxyPrefixes returns the trusted proxy ranges of a validated
+// config. The legacy trust_forwarded_for trusts every peer.
+func (c *Config) TrustedProxyPrefixes() []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, s := range c.TrustedProxies {
+		p, _ := parsePrefix(s)
+		prefixes = append(prefixes, p)
+	}
+	if c.TrustForwardedFor {
+		prefixes = append(prefixes, netip.MustParsePrefix("0.0.0.0/0"), netip.MustParsePrefix("::/0"))
+	}
+	return prefixes
+}
+
+func (pc *PoolConfig) UpstreamTimeouts() UpstreamTimeouts {
+	return UpstreamTimeouts{
+		Dial:           orZero(pc.DialTimeout),
+		ResponseHeader: orZero(pc.ResponseHeaderTimeout),
+		Request:        orZero(pc.RequestTimeout),
+		Total:          orZero(pc.TotalTimeout),
+	}
+}
+
+// orZero returns the timeout d, 0 for no limit if unset.
+func orZero(d *time.Duration) time.Duration {
+	if d == nil {
+		return 0
+	}
+	return *d
+}
+
+func (c *Config) UpstreamKeepAlive() UpstreamKeepAlive {
+	return UpstreamKeepAlive{
+		MaxIdleConns:        c.MaxIdleConns,
+		MaxIdleConnsPerHost: c.MaxIdleConnsPerHost,
+		IdleConnTimeout:     c.IdleConnTimeout,
+		Isolated:            c.IsolateTransports,
+	}
+}
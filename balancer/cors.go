@@ -0,0 +1,168 @@
+package balancer
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSPolicy answers cross-origin requests on behalf of the backends of a
+// pool. It is disabled without AllowedOrigins. An origin may be "*" for any,
+// or a scheme and host where the host may start with "*." to match any
+// subdomain. AllowedHeaders may be "*" to allow whatever a preflight asks for;
+// AllowedMethods defaults to GET, HEAD and POST.
+type CORSPolicy struct {
+	AllowedOrigins   []string      `yaml:"allowed_origins" json:"allowed_origins"`
+	AllowedMethods   []string      `yaml:"allowed_methods" json:"allowed_methods"`
+	AllowedHeaders   []string      `yaml:"allowed_headers" json:"allowed_headers"`
+	ExposedHeaders   []string      `yaml:"exposed_headers" json:"exposed_headers"`
+	AllowCredentials bool          `yaml:"allow_credentials" json:"allow_credentials"`
+	MaxAge           time.Duration `yaml:"max_age" json:"max_age"`
+}
+
+var corsResponseHeaders = []string{
+	"Access-Control-Allow-Origin",
+	"Access-Control-Allow-Credentials",
+	"Access-Control-Allow-Methods",
+	"Access-Control-Allow-Headers",
+	"Access-Control-Expose-Headers",
+	"Access-Control-Max-Age",
+}
+
+func (c CORSPolicy) enabled() bool {
+	return len(c.AllowedOrigins) > 0
+}
+
+func (c CORSPolicy) allowsOrigin(origin string) bool {
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+		scheme, host, ok := strings.Cut(allowed, "://*.")
+		if ok && strings.EqualFold(scheme, u.Scheme) && strings.HasSuffix(strings.ToLower(u.Host), "."+strings.ToLower(host)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c CORSPolicy) methods() string {
+	if len(c.AllowedMethods) == 0 {
+		return "GET, HEAD, POST"
+	}
+	return strings.Join(c.AllowedMethods, ", ")
+}
+
+// serve adds the CORS headers for r to w and reports whether r was a
+// preflight, which it answers itself instead of forwarding it.
+func (c CORSPolicy) serve(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+	h := w.Header()
+	h.Add("Vary", "Origin")
+	if preflight {
+		h.Add("Vary", "Access-Control-Request-Method")
+		h.Add("Vary", "Access-Control-Request-Headers")
+	}
+	if origin != "" && c.allowsOrigin(origin) {
+		if len(c.AllowedOrigins) == 1 && c.AllowedOrigins[0] == "*" {
+			h.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			h.Set("Access-Control-Allow-Origin", origin)
+		}
+		if c.AllowCredentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+		if preflight {
+			h.Set("Access-Control-Allow-Methods", c.methods())
+			if len(c.AllowedHeaders) == 1 && c.AllowedHeaders[0] == "*" {
+				if requested := r.Header.Get("Access-Control-Request-Headers"); requested != "" {
+					h.Set("Access-Control-Allow-Headers", requested)
+				}
+			} else if len(c.AllowedHeaders) > 0 {
+				h.Set("Access-Control-Allow-Headers", strings.Join(c.AllowedHeaders, ", "))
+			}
+			if c.MaxAge > 0 {
+				h.Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge.Seconds())))
+			}
+		} else if len(c.ExposedHeaders) > 0 {
+			h.Set("Access-Control-Expose-Headers", strings.Join(c.ExposedHeaders, ", "))
+		}
+	}
+	if preflight {
+		//a disallowed origin gets no CORS headers, which the browser refuses
+		w.WriteHeader(http.StatusNoContent)
+	}
+	return preflight
+}
+
+func (c CORSPolicy) validate(v *validator, prefix string) {
+	for _, origin := range c.AllowedOrigins {
+		if origin == "*" {
+			if c.AllowCredentials {
+				v.fail(prefix+"allowed_origins", `"*" cannot be combined with allow_credentials`)
+			}
+			continue
+		}
+		u, err := url.Parse(strings.Replace(origin, "://*.", "://", 1))
+		if err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" || strings.Contains(u.Host, "*") {
+			v.fail(prefix+"allowed_origins", "must be * or a scheme and host such as https://*.example.com, got %q", origin)
+		}
+	}
+	for _, method := range c.AllowedMethods {
+		if method == "" || strings.ContainsAny(method, " \t\r\n,") {
+			v.fail(prefix+"allowed_methods", "invalid method %q", method)
+		}
+	}
+	for _, name := range c.AllowedHeaders {
+		if name != "*" || len(c.AllowedHeaders) > 1 {
+			validateHeader(v, prefix+"allowed_headers", name, "")
+		}
+	}
+	for _, name := range c.ExposedHeaders {
+		validateHeader(v, prefix+"exposed_headers", name, "")
+	}
+	if c.MaxAge < 0 {
+		v.fail(prefix+"max_age", "must not be negative, got %s", c.MaxAge)
+	}
+}
+
+// SetCORS sets the CORS policy of the pool.
+func (s *ServerPool) SetCORS(c CORSPolicy) {
+	s.mux.Lock()
+	s.cors = c
+	s.mux.Unlock()
+}
+
+func (s *ServerPool) CORS() CORSPolicy {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.cors
+}
+
+// handleCORS applies the CORS policy of the pool to r and reports whether it
+// answered a preflight.
+func (s *ServerPool) handleCORS(w http.ResponseWriter, r *http.Request) bool {
+	c := s.CORS()
+	if !c.enabled() {
+		return false
+	}
+	return c.serve(w, r)
+}
+
+// stripCORS drops the CORS headers of a backend response when the pool
+// answers CORS itself, so they don't add up with those set by handleCORS.
+func (s *ServerPool) stripCORS(resp *http.Response) {
+	if !s.CORS().enabled() {
+		return
+	}
+	for _, name := range corsResponseHeaders {
+		resp.Header.Del(name)
+	}
+}
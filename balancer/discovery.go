@@ -0,0 +1,117 @@
+package balancer
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Discovery keeps the backends of the default pool in sync with the DNS
+// records of a host name, such as a headless Kubernetes service. Target is a
+// URL whose host is resolved: A/AAAA records use the URL's port, SRV records
+// bring their own. Discovered backends are added alongside the static ones.
+type Discovery struct {
+	Target   *url.URL
+	SRV      bool
+	Interval time.Duration
+
+	mux      sync.Mutex
+	backends []BackendConfig
+}
+
+// Backends returns the backends found by the last successful resolution.
+func (d *Discovery) Backends() []BackendConfig {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	return d.backends
+}
+
+func (d *Discovery) resolve(ctx context.Context) ([]BackendConfig, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	var hosts []string
+	if d.SRV {
+		_, records, err := net.DefaultResolver.LookupSRV(ctx, "", "", d.Target.Hostname())
+		if err != nil {
+			return nil, err
+		}
+		for _, srv := range records {
+			hosts = append(hosts, net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port))))
+		}
+	} else {
+		addrs, err := net.DefaultResolver.LookupHost(ctx, d.Target.Hostname())
+		if err != nil {
+			return nil, err
+		}
+		port := d.Target.Port()
+		if port == "" {
+			port = "80"
+			if d.Target.Scheme == "https" {
+				port = "443"
+			}
+		}
+		for _, addr := range addrs {
+			hosts = append(hosts, net.JoinHostPort(addr, port))
+		}
+	}
+	sort.Strings(hosts)
+	hosts = slices.Compact(hosts)
+	backends := make([]BackendConfig, 0, len(hosts))
+	for _, host := range hosts {
+		u := url.URL{Scheme: d.Target.Scheme, Host: host}
+		backends = append(backends, BackendConfig{Url: u.String(), Weight: 1})
+	}
+	return backends, nil
+}
+
+// refresh resolves the target and reports whether the set of backends
+// changed. Failed or empty lookups keep the current set, so a DNS hiccup does
+// not empty the pool.
+func (d *Discovery) refresh(ctx context.Context) bool {
+	backends, err := d.resolve(ctx)
+	if err != nil {
+		slog.Warn("dns discovery failed, keeping current backends", "target", d.Target.Host, "error", err)
+		return false
+	}
+	if len(backends) == 0 {
+		slog.Warn("dns discovery returned no records, keeping current backends", "target", d.Target.Host)
+		return false
+	}
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	if slices.Equal(backends, d.backends) {
+		return false
+	}
+	d.backends = backends
+	return true
+}
+
+// run re-resolves the target every Interval until ctx is done and applies
+// changes to rt.
+func (d *Discovery) run(ctx context.Context, rt *Router) {
+	t := rt.policies.clock.NewTicker(d.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C():
+			if !d.refresh(ctx) {
+				continue
+			}
+			added, removed, err := rt.Reconfigure()
+			if err != nil {
+				slog.Error("cannot apply discovered backends", "target", d.Target.Host, "error", err)
+				continue
+			}
+			applyBackendChanges(added, removed, "dns")
+		case <-ctx.Done():
+			return
+		}
+	}
+}
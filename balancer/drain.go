@@ -0,0 +1,55 @@
+package balancer
+
+import (
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Drain takes the whole load balancer out of an upstream balancer's rotation.
+// Once started /readyz fails so the upstream stops sending new connections;
+// after Delay new requests are refused with 503 while in-flight ones finish.
+type Drain struct {
+	Delay time.Duration
+
+	clock   Clock
+	mux     sync.RWMutex
+	started time.Time
+}
+
+// Start puts the load balancer into drain, unless it already is, and returns
+// when requests start being refused.
+func (d *Drain) Start() time.Time {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	if d.started.IsZero() {
+		d.started = d.clock.Now()
+		slog.Info("draining load balancer", "delay", d.Delay)
+	}
+	return d.started.Add(d.Delay)
+}
+
+func (d *Drain) Draining() bool {
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+	return !d.started.IsZero()
+}
+
+// Refusing reports whether new requests should be turned away.
+func (d *Drain) Refusing() bool {
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+	return !d.started.IsZero() && since(d.clock, d.started) >= d.Delay
+}
+
+// lbDrainHandler starts draining the load balancer on POST.
+func (l *LoadBalancer) lbDrainHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	l.drain.Start()
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,74 @@
+package balancer
+
+import (
+	"fmt"
+	"math"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// ErrorPage is the response sent when no backend can serve a request. A nil
+// Body keeps the plain text message.
+type ErrorPage struct {
+	Status      int
+	Body        []byte
+	ContentType string
+	RetryAfter  time.Duration
+}
+
+// serveUnavailable answers a request for which no backend is available, the
+// pool being empty or every backend down.
+func (p *policies) serveUnavailable(w http.ResponseWriter) {
+	p.unavailablePage.serve(w, "Service not available")
+}
+
+// serveBadGateway answers a request that backends were tried for but
+// failed, after every retry and failover allowed.
+func (p *policies) serveBadGateway(w http.ResponseWriter) {
+	p.badGatewayPage.serve(w, "Bad gateway")
+}
+
+func (p ErrorPage) serve(w http.ResponseWriter, text string) {
+	if p.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(p.RetryAfter.Seconds()))))
+	}
+	if p.Body == nil {
+		http.Error(w, text, p.Status)
+		return
+	}
+	w.Header().Set("Content-Type", p.ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Length", strconv.Itoa(len(p.Body)))
+	w.WriteHeader(p.Status)
+	w.Write(p.Body)
+}
+
+// UnavailablePage builds the page for requests no backend can serve from the
+// unavailable_* settings. A body file takes precedence over an inline body;
+// without a content type, one is guessed from the file extension or the body.
+func (c *Config) UnavailablePage() (ErrorPage, error) {
+	page := ErrorPage{Status: c.UnavailableStatus, ContentType: c.UnavailableContentType, RetryAfter: c.UnavailableRetryAfter}
+	switch {
+	case c.UnavailableBodyFile != "":
+		body, err := os.ReadFile(c.UnavailableBodyFile)
+		if err != nil {
+			return page, fmt.Errorf("unavailable_body_file: %w", err)
+		}
+		page.Body = body
+		if page.ContentType == "" {
+			page.ContentType = mime.TypeByExtension(filepath.Ext(c.UnavailableBodyFile))
+		}
+	case c.UnavailableBody != "":
+		page.Body = []byte(c.UnavailableBody)
+	default:
+		return page, nil
+	}
+	if page.ContentType == "" {
+		page.ContentType = http.DetectContentType(page.Body)
+	}
+	return page, nil
+}
@@ -0,0 +1,58 @@
+package balancer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestErrorStatus(t *testing.T) {
+	var hits atomic.Int64
+	failing := newTestBackend(t, hangUp(&hits))
+	tests := []struct {
+		name                    string
+		unavailable, badGateway int
+		down                    bool //whether the only backend is down rather than failing
+		want                    int
+	}{
+		{"no backend up", http.StatusServiceUnavailable, http.StatusBadGateway, true, http.StatusServiceUnavailable},
+		{"backend failed", http.StatusServiceUnavailable, http.StatusBadGateway, false, http.StatusBadGateway},
+		{"no backend up overridden", 599, 520, true, 599},
+		{"backend failed overridden", 599, 520, false, 520},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := newTestPool(t, WithBackend(failing.URL, 1), withSettings(func(cfg *Config) {
+				cfg.MaxRetries = 0
+				cfg.UnavailableStatus, cfg.BadGatewayStatus = tt.unavailable, tt.badGateway
+			}))
+			pool.Backends()[0].SetAlive(!tt.down)
+
+			w := serve(pool.Handler(), httptest.NewRequest(http.MethodGet, "/", nil))
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestErrorStatusConfig(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Backends = []BackendConfig{{Url: "http://10.0.0.1:80", Weight: 1}}
+	for _, tt := range []struct {
+		unavailable, badGateway int
+		err                     string
+	}{
+		{503, 502, ""},
+		{599, 520, ""},
+		{200, 502, "unavailable_status: must be an error status between 400 and 599, got 200"},
+		{503, 600, "bad_gateway_status: must be an error status between 400 and 599, got 600"},
+	} {
+		cfg.UnavailableStatus, cfg.BadGatewayStatus = tt.unavailable, tt.badGateway
+		err := cfg.Validate()
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || err.Error() != tt.err) {
+			t.Errorf("statuses %d, %d: Validate = %v, want %q", tt.unavailable, tt.badGateway, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package balancer
+
+import (
+	"sync"
+	"time"
+)
+
+// ErrorWeighting shifts round-robin traffic away from backends answering
+// with errors, softer than taking them out like the circuit breaker does.
+// Every response feeds a moving average of the error rate over roughly the
+// last Window requests of a backend, where 5xx responses and proxy errors
+// count as errors, and its weight is scaled down by Sensitivity times that
+// rate. At a Sensitivity of 1 a backend failing every request keeps 1% of its
+// weight, enough to notice it recovering; 0 disables the scaling.
+type ErrorWeighting struct {
+	Sensitivity float64
+	Window      int
+}
+
+// errorRate is a moving average of failed requests, from 0 to 1.
+type errorRate struct {
+	mux  sync.Mutex
+	rate float64
+}
+
+func (e *errorRate) observe(w ErrorWeighting, failed bool) {
+	if w.Sensitivity <= 0 {
+		return
+	}
+	x := 0.0
+	if failed {
+		x = 1
+	}
+	alpha := 2 / float64(w.Window+1)
+	e.mux.Lock()
+	e.rate += alpha * (x - e.rate)
+	e.mux.Unlock()
+}
+
+func (e *errorRate) get() float64 {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	return e.rate
+}
+
+// weightPercent returns the share of its weight, from 1 to 100, that b
+// receives in round-robin selection at now.
+func (b *Backend) weightPercent(now time.Time) int {
+	return max(1, b.slowStartPercent(now)*b.errorWeightPercent()/100)
+}
+
+// errorWeightPercent returns the share of its weight, from 1 to 100, that b
+// keeps given its recent error rate.
+func (b *Backend) errorWeightPercent() int {
+	w := b.pool.policies.errorWeighting
+	if w.Sensitivity <= 0 {
+		return 100
+	}
+	return min(100, max(1, int(100*(1-w.Sensitivity*b.errors.get()))))
+}
@@ -0,0 +1,84 @@
+package balancer
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+)
+
+var errBackendDown = errors.New("backend marked down")
+
+// waitingRequest is an attempt against a backend that has not received
+// response headers yet.
+type waitingRequest struct {
+	cancel context.CancelCauseFunc
+}
+
+// trackWaiting makes the attempt r cancellable by failWaiting until its
+// response headers arrive, when the fail_fast policy is on. The returned done
+// must be called once the attempt is over.
+//
+// Failing fast cancels the attempts still waiting for response headers from
+// a backend as soon as it is marked down, so they fail over right away
+// instead of running into a timeout. Only requests that may be replayed are
+// cut: the backend may still have been processing one, so a cancelled PUT or
+// DELETE can end up applied twice, once there and once on the backend it
+// fails over to. Responses already being relayed are left alone.
+func (b *Backend) trackWaiting(r *http.Request) (*http.Request, func()) {
+	p := b.pool.policies
+	if !p.failFast || isUpgrade(r) || !p.retry.replayable(r) {
+		return r, func() {}
+	}
+	//retries and failovers must not inherit the cancellation
+	ctx := context.WithValue(r.Context(), BaseContext, GetBaseContext(r))
+	ctx, cancel := context.WithCancelCause(ctx)
+	wr := &waitingRequest{cancel: cancel}
+	ctx = context.WithValue(ctx, Waiting, wr)
+
+	b.waitingMux.Lock()
+	if b.waiting == nil {
+		b.waiting = make(map[*waitingRequest]struct{})
+	}
+	b.waiting[wr] = struct{}{}
+	b.waitingMux.Unlock()
+	return r.WithContext(ctx), func() {
+		b.untrackWaiting(wr)
+		cancel(nil)
+	}
+}
+
+// responded stops tracking the attempt resp answers, whose body must not be
+// cut anymore.
+func (b *Backend) responded(resp *http.Response) {
+	if wr, ok := resp.Request.Context().Value(Waiting).(*waitingRequest); ok {
+		b.untrackWaiting(wr)
+	}
+}
+
+func (b *Backend) untrackWaiting(wr *waitingRequest) {
+	b.waitingMux.Lock()
+	delete(b.waiting, wr)
+	b.waitingMux.Unlock()
+}
+
+// failWaiting cancels every attempt waiting on b after it was marked down.
+func (b *Backend) failWaiting() {
+	b.waitingMux.Lock()
+	waiting := b.waiting
+	b.waiting = nil
+	b.waitingMux.Unlock()
+	if len(waiting) == 0 {
+		return
+	}
+	slog.Info("cancelling requests waiting on backend marked down", "pool", b.pool.Name, "backend", backendHost(b.Url), "requests", len(waiting))
+	for wr := range waiting {
+		wr.cancel(errBackendDown)
+	}
+}
+
+// cancelledAsDown reports whether the attempt r failed because failWaiting
+// cut it.
+func cancelledAsDown(r *http.Request) bool {
+	return errors.Is(context.Cause(r.Context()), errBackendDown)
+}
@@ -0,0 +1,67 @@
+package balancer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestFailFast(t *testing.T) {
+	tests := []struct {
+		method string
+		want   string //backend answering once the first one is marked down
+	}{
+		{http.MethodGet, "fallback"},
+		{http.MethodPost, "slow"}, //not replayable, left to finish
+	}
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			started, release := make(chan struct{}), make(chan struct{})
+			slow := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				close(started)
+				select {
+				case <-release:
+					w.Write([]byte("slow"))
+				case <-r.Context().Done():
+				}
+			})
+			fallback := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("fallback"))
+			})
+			defer close(release)
+			pool := newTestPool(t, WithBackend(slow.URL, 1), withSettings(func(cfg *Config) {
+				cfg.FailFast = true
+			}))
+
+			done := make(chan *httptest.ResponseRecorder)
+			go func() {
+				done <- serve(pool.Handler(), httptest.NewRequest(tt.method, "/", nil))
+			}()
+			<-started
+			b, err := newBackend(BackendConfig{Url: fallback.URL, Weight: 1}, pool)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b.SetAlive(true)
+			pool.AddBackend(b)
+			u, _ := url.Parse(slow.URL)
+			pool.MarkBackendStatus(u, false)
+
+			var w *httptest.ResponseRecorder
+			select {
+			case w = <-done:
+			case <-time.After(300 * time.Millisecond):
+				if tt.want == "fallback" {
+					t.Fatal("request still waiting on the backend marked down")
+				}
+				release <- struct{}{}
+				w = <-done
+			}
+			if w.Code != http.StatusOK || w.Body.String() != tt.want {
+				t.Errorf("got %d %q, want %d %q", w.Code, w.Body, http.StatusOK, tt.want)
+			}
+		})
+	}
+}
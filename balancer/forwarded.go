@@ -0,0 +1,41 @@
+package balancer
+
+import (
+	"net/http/httputil"
+)
+
+// ForwardedHeaders controls the X-Forwarded-For, -Host and -Proto headers
+// sent to backends. When enabled the client address is appended to
+// X-Forwarded-For and the original Host and scheme are passed on. Headers a
+// client sent itself are only kept when it is one of the trusted proxies, so
+// they cannot be spoofed by connecting directly. When disabled none are sent.
+type ForwardedHeaders struct {
+	Enabled bool
+}
+
+var forwardedHeaderNames = []string{"X-Forwarded-For", "X-Forwarded-Host", "X-Forwarded-Proto"}
+
+// set writes the forwarded headers of pr.Out. The reverse proxy has already
+// removed the inbound ones from it. trusted are the trusted proxies.
+func (f *ForwardedHeaders) set(pr *httputil.ProxyRequest, trusted proxyPrefixes) {
+	if !f.Enabled {
+		return
+	}
+	if trusted.trusts(pr.In) {
+		for _, name := range forwardedHeaderNames {
+			if v, ok := pr.In.Header[name]; ok {
+				pr.Out.Header[name] = v
+			}
+		}
+	}
+	host := pr.Out.Header.Get("X-Forwarded-Host")
+	proto := pr.Out.Header.Get("X-Forwarded-Proto")
+	pr.SetXForwarded()
+	//a trusted proxy in front knows the original host and scheme better
+	if host != "" {
+		pr.Out.Header.Set("X-Forwarded-Host", host)
+	}
+	if proto != "" {
+		pr.Out.Header.Set("X-Forwarded-Proto", proto)
+	}
+}
@@ -0,0 +1,35 @@
+package balancer
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestH2CBackend(t *testing.T) {
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		//gRPC sends its status in trailers
+		w.Header().Set("Trailer", "Grpc-Status")
+		body, _ := io.ReadAll(r.Body)
+		io.WriteString(w, r.Proto+" "+string(body))
+		w.Header().Set("Grpc-Status", "0")
+	}))
+	backend.Config.Protocols = new(http.Protocols)
+	backend.Config.Protocols.SetUnencryptedHTTP2(true)
+	backend.Start()
+	defer backend.Close()
+	pool := newTestPool(t, WithBackends(BackendConfig{Url: backend.URL, H2C: true}))
+
+	w := serve(pool.Handler(), httptest.NewRequest(http.MethodPost, "/echo", strings.NewReader("ping")))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if got, want := w.Body.String(), "HTTP/2.0 ping"; got != want {
+		t.Fatalf("backend answered %q, want %q", got, want)
+	}
+	if got := w.Result().Trailer.Get("Grpc-Status"); got != "0" {
+		t.Fatalf("Grpc-Status trailer = %q, want 0", got)
+	}
+}
@@ -0,0 +1,40 @@
+package balancer
+
+import (
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+)
+
+// withEndpoint serves h for requests to exactly path and hands everything
+// else to next. It is used to carve internal endpoints out of the proxied
+// URL space without the path cleaning redirects of http.ServeMux.
+func withEndpoint(path string, h http.Handler, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == path {
+			h.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withRecover turns a panic in h into a logged stack trace and a 500 instead
+// of a dropped connection. http.ErrAbortHandler is passed on, the reverse
+// proxy uses it to abort a response it has already started.
+func withRecover(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+			slog.ErrorContext(r.Context(), "panic serving request", "client", r.RemoteAddr, "path", r.URL.Path, "error", err, "stack", string(debug.Stack()))
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}()
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,49 @@
+package balancer
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRecoverPanic(t *testing.T) {
+	srv := httptest.NewServer(withRecover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/panic" {
+			panic("boom")
+		}
+		io.WriteString(w, "ok")
+	})))
+	defer srv.Close()
+
+	for _, tt := range []struct {
+		path   string
+		status int
+	}{
+		{"/panic", http.StatusInternalServerError},
+		{"/", http.StatusOK},
+		{"/panic", http.StatusInternalServerError},
+		{"/", http.StatusOK},
+	} {
+		resp, err := srv.Client().Get(srv.URL + tt.path)
+		if err != nil {
+			t.Fatalf("GET %s: %v", tt.path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("GET %s: status = %d, want %d", tt.path, resp.StatusCode, tt.status)
+		}
+	}
+}
+
+func TestRecoverAbortHandler(t *testing.T) {
+	h := withRecover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	defer func() {
+		if err := recover(); err != http.ErrAbortHandler {
+			t.Fatalf("recovered %v, want http.ErrAbortHandler passed on", err)
+		}
+	}()
+	serve(h, httptest.NewRequest(http.MethodGet, "/", nil))
+}
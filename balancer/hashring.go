@@ -0,0 +1,108 @@
+package balancer
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// HashPolicy configures the consistent-hash strategy. Key is "path" to hash
+// the URL path or "header:<name>" to hash a request header, falling back to
+// the path when the header is missing. Each backend gets VNodes points on the
+// ring per unit of weight.
+type HashPolicy struct {
+	Key    string
+	VNodes int
+}
+
+func parseHashKey(s string) error {
+	if s == "path" {
+		return nil
+	}
+	if name, ok := strings.CutPrefix(s, "header:"); ok && name != "" {
+		return nil
+	}
+	return fmt.Errorf("unknown hash key %q, want path or header:<name>", s)
+}
+
+func (p HashPolicy) key(r *http.Request) string {
+	if name, ok := strings.CutPrefix(p.Key, "header:"); ok {
+		if v := r.Header.Get(name); v != "" {
+			return v
+		}
+	}
+	return r.URL.Path
+}
+
+type ringPoint struct {
+	hash    uint64
+	backend *Backend
+}
+
+// HashRing places virtual nodes of every backend on a ring of hashes. A key
+// belongs to the first point at or after its hash, so adding or removing a
+// backend only moves the keys of the arcs it owns.
+type HashRing struct {
+	points []ringPoint
+}
+
+func newHashRing(backends []*Backend, vnodes int) *HashRing {
+	ring := &HashRing{}
+	for _, b := range backends {
+		n := vnodes * b.Weight
+		for i := 0; i < n; i++ {
+			h := hashKey(fmt.Sprintf("%s#%d", b.Url.String(), i))
+			ring.points = append(ring.points, ringPoint{hash: h, backend: b})
+		}
+	}
+	sort.Slice(ring.points, func(i, j int) bool { return ring.points[i].hash < ring.points[j].hash })
+	return ring
+}
+
+// get returns the owner of key, walking clockwise past backends that are
+// unavailable so only their keys are redistributed.
+func (ring *HashRing) get(key string, skip triedBackends) *Backend {
+	if len(ring.points) == 0 {
+		return nil
+	}
+	h := hashKey(key)
+	start := sort.Search(len(ring.points), func(i int) bool { return ring.points[i].hash >= h })
+	tried := make(map[*Backend]bool)
+	for i := 0; i < len(ring.points); i++ {
+		b := ring.points[(start+i)%len(ring.points)].backend
+		if tried[b] {
+			continue
+		}
+		if skip.available(b) {
+			return b
+		}
+		tried[b] = true
+	}
+	return nil
+}
+
+// shares returns the fraction of the keyspace each backend owns.
+func (ring *HashRing) shares() map[*Backend]float64 {
+	shares := make(map[*Backend]float64)
+	if len(ring.points) == 1 {
+		shares[ring.points[0].backend] = 1
+		return shares
+	}
+	for i, p := range ring.points {
+		prev := ring.points[(i+len(ring.points)-1)%len(ring.points)]
+		//unsigned subtraction wraps around for the first arc
+		shares[p.backend] += float64(p.hash-prev.hash) / math.MaxUint64
+	}
+	return shares
+}
+
+// nextConsistentHash returns the ring owner of the request's hash key. The
+// caller must hold s.mux for reading.
+func (s *ServerPool) nextConsistentHash(r *http.Request, tried triedBackends) *Backend {
+	if s.ring == nil {
+		return nil
+	}
+	return s.ring.get(s.policies.hash.key(r), tried)
+}
@@ -0,0 +1,67 @@
+package balancer
+
+import (
+	"net/http"
+	"strings"
+)
+
+// HeaderRules rewrite the headers of responses from backends before they are
+// relayed: Remove strips headers such as Server, then Set replaces and Add
+// appends values.
+type HeaderRules struct {
+	Set    map[string]string `yaml:"set" json:"set"`
+	Add    map[string]string `yaml:"add" json:"add"`
+	Remove []string          `yaml:"remove" json:"remove"`
+}
+
+func (h HeaderRules) empty() bool {
+	return len(h.Set) == 0 && len(h.Add) == 0 && len(h.Remove) == 0
+}
+
+func (h HeaderRules) apply(header http.Header) {
+	for _, name := range h.Remove {
+		header.Del(name)
+	}
+	for name, value := range h.Set {
+		header.Set(name, value)
+	}
+	for name, value := range h.Add {
+		header.Add(name, value)
+	}
+}
+
+func (h HeaderRules) validate(v *validator, prefix string) {
+	for name, value := range h.Set {
+		validateHeader(v, prefix+"set", name, value)
+	}
+	for name, value := range h.Add {
+		validateHeader(v, prefix+"add", name, value)
+	}
+	for _, name := range h.Remove {
+		validateHeader(v, prefix+"remove", name, "")
+	}
+}
+
+func validateHeader(v *validator, field, name, value string) {
+	if name == "" || strings.ContainsAny(name, " \t\r\n:()<>@,;\\\"/[]?={}") {
+		v.fail(field, "invalid header name %q", name)
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		v.fail(field, "value of %s must not contain line breaks", name)
+	}
+}
+
+// SetResponseHeaders sets the rules applied to responses of the pool.
+func (s *ServerPool) SetResponseHeaders(h HeaderRules) {
+	s.mux.Lock()
+	s.responseHeaders = h
+	s.mux.Unlock()
+}
+
+// rewriteResponseHeaders applies the header rules of the pool to resp.
+func (s *ServerPool) rewriteResponseHeaders(resp *http.Response) {
+	s.mux.RLock()
+	h := s.responseHeaders
+	s.mux.RUnlock()
+	h.apply(resp.Header)
+}
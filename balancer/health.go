@@ -0,0 +1,196 @@
+package balancer
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HealthCheck describes how backends are probed. An empty Path means a plain
+// TCP dial, otherwise a GET is issued and the status must fall in
+// [MinStatus, MaxStatus]. A backend goes down after Fall consecutive failed
+// probes and comes back after Rise consecutive passed ones.
+type HealthCheck struct {
+	Path      string
+	MinStatus int
+	MaxStatus int
+	Timeout   time.Duration
+	Rise      int
+	Fall      int
+}
+
+var defaultHealthCheck = HealthCheck{
+	MinStatus: 200,
+	MaxStatus: 299,
+	Timeout:   2 * time.Second,
+	Rise:      1,
+	Fall:      1,
+}
+
+// parseStatusRange accepts either a single code ("204") or a range ("200-299").
+func parseStatusRange(s string) (int, int, error) {
+	lo, hi, found := strings.Cut(s, "-")
+	min, err := strconv.Atoi(strings.TrimSpace(lo))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid status range %q", s)
+	}
+	max := min
+	if found {
+		if max, err = strconv.Atoi(strings.TrimSpace(hi)); err != nil {
+			return 0, 0, fmt.Errorf("invalid status range %q", s)
+		}
+	}
+	if min < 100 || max > 599 || min > max {
+		return 0, 0, fmt.Errorf("invalid status range %q", s)
+	}
+	return min, max, nil
+}
+
+// probe probes b, at its health address if it has one, letting its own
+// health path and status override those of the pool. It returns why b failed
+// the probe, nil if it passed.
+func (h HealthCheck) probe(b *Backend) error {
+	b.mux.RLock()
+	health := b.HealthUrl
+	if b.HealthPath != "" {
+		h.Path = b.HealthPath
+	}
+	if b.HealthStatus != "" {
+		//validated with the config
+		h.MinStatus, h.MaxStatus, _ = parseStatusRange(b.HealthStatus)
+	}
+	b.mux.RUnlock()
+	tlsConfig := b.tlsConfig.Load()
+	target := b.Url
+	if health != nil {
+		target = health
+	}
+	if h.Path == "" {
+		if tlsConfig != nil && target.Scheme == "https" {
+			return isBackendTLSAlive(target, tlsConfig, h.Timeout)
+		}
+		return isBackendAlive(target, h.Timeout)
+	}
+	if tlsConfig != nil {
+		//the transport of the backend carries its TLS config
+		return isBackendHealthy(b.Url, target, b.transport, h)
+	}
+	if health != nil {
+		return isBackendHealthy(b.Url, health, nil, h)
+	}
+	if isUnixSocket(b.Url) {
+		return isBackendHealthy(b.Url, unixTarget, b.transport, h)
+	}
+	return isBackendHealthy(b.Url, b.Url, nil, h)
+}
+
+// recordProbe counts the result of a probe that started at start towards
+// the rise and fall thresholds, err being why it failed, and returns whether
+// b is alive afterwards.
+func (h HealthCheck) recordProbe(b *Backend, err error, start time.Time) bool {
+	now := b.pool.policies.clock.Now()
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.lastProbe = probeResult{At: start, Duration: now.Sub(start), Err: err}
+	if err == nil {
+		b.healthPassed++
+		b.healthFailed = 0
+		if !b.Alive && b.healthPassed >= h.Rise {
+			b.Alive = true
+			b.aliveSince = now
+			b.changedAt = now
+		}
+	} else {
+		b.lastFailure = b.lastProbe
+		b.healthFailed++
+		b.healthPassed = 0
+		if b.Alive && b.healthFailed >= h.Fall {
+			b.Alive = false
+			b.changedAt = now
+			defer b.failWaiting()
+		}
+	}
+	return b.Alive
+}
+
+func isBackendAlive(u *url.URL, timeout time.Duration) error {
+	network, addr := backendAddr(u)
+	conn, err := net.DialTimeout(network, addr, timeout)
+	if err != nil {
+		logProbeError(u, err)
+		return err
+	}
+	defer conn.Close()
+	return nil
+}
+
+// isBackendHealthy probes the backend at u by sending a GET for the health
+// path to base over transport, nil for the default one.
+func isBackendHealthy(u, base *url.URL, transport http.RoundTripper, h HealthCheck) error {
+	client := http.Client{Timeout: h.Timeout, Transport: transport}
+	target := base.ResolveReference(&url.URL{Path: h.Path})
+	resp, err := client.Get(target.String())
+	if err != nil {
+		logProbeError(u, err)
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < h.MinStatus || resp.StatusCode > h.MaxStatus {
+		slog.Warn("site unhealthy", "backend", backendHost(u), "url", target.String(), "status", resp.StatusCode)
+		return fmt.Errorf("GET %s: status %d, want %d-%d", target, resp.StatusCode, h.MinStatus, h.MaxStatus)
+	}
+	return nil
+}
+
+// logProbeError logs why the backend at u failed a probe, telling failed TLS
+// handshakes, such as an untrusted certificate, from unreachable backends.
+func logProbeError(u *url.URL, err error) {
+	if isTLSError(err) {
+		slog.Warn("site TLS handshake failed", "backend", backendHost(u), "error", err)
+		return
+	}
+	slog.Warn("site unreachable", "backend", backendHost(u), "error", err)
+}
+
+// healthCheck probes the pools immediately and then every interval until ctx
+// is cancelled.
+func (l *LoadBalancer) healthCheck(ctx context.Context, interval time.Duration) {
+	l.runHealthCheck(false)
+	t := l.policies.clock.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C():
+			l.runHealthCheck(false)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// runHealthCheck probes the backends of every pool, all of them when forced
+// regardless of the health backoff.
+func (l *LoadBalancer) runHealthCheck(force bool) {
+	l.policies.healthCheckMux.Lock()
+	defer l.policies.healthCheckMux.Unlock()
+	slog.Debug("starting health check")
+	start := l.policies.clock.Now()
+	for _, pool := range l.router.Pools() {
+		pool.HeadthCheck(force)
+	}
+	slog.Debug("health check completed", "duration", since(l.policies.clock, start))
+}
+
+// probeBackends probes backends of s outside of the scheduled runs, such as
+// ones just added, waiting for a run in progress like runHealthCheck.
+func (s *ServerPool) probeBackends(backends []*Backend) {
+	s.policies.healthCheckMux.Lock()
+	defer s.policies.healthCheckMux.Unlock()
+	s.checkBackends(backends)
+}
@@ -0,0 +1,156 @@
+package balancer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestBalancer returns a load balancer with cfg serving pools, which
+// must share the policies of the first one.
+func newTestBalancer(cfg Config, pools ...*ServerPool) *LoadBalancer {
+	l := &LoadBalancer{cfg: cfg, policies: pools[0].policies}
+	l.router.policies = l.policies
+	l.router.pools = make(map[string]*ServerPool, len(pools))
+	for _, pool := range pools {
+		l.router.pools[pool.Name] = pool
+	}
+	return l
+}
+
+// TestProbeBackendsSerialized probes a backend just added while a health
+// check is running: it must not be probed twice at once.
+func TestProbeBackendsSerialized(t *testing.T) {
+	var probing, overlaps atomic.Int64
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		if probing.Add(1) > 1 {
+			overlaps.Add(1)
+		}
+		time.Sleep(20 * time.Millisecond)
+		probing.Add(-1)
+	})
+	pool := newTestPool(t, WithBackend(backend.URL, 1), WithHealthPath("/health"))
+	l := newTestBalancer(Config{}, pool)
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			l.runHealthCheck(true)
+		}()
+		go func() {
+			defer wg.Done()
+			pool.probeBackends(pool.Backends())
+		}()
+	}
+	wg.Wait()
+	if overlaps.Load() > 0 {
+		t.Errorf("backend probed concurrently %d times", overlaps.Load())
+	}
+}
+
+func TestHealthCheckConcurrency(t *testing.T) {
+	const probe = 100 * time.Millisecond
+	var opts []PoolOption
+	for range 5 {
+		backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(probe)
+		})
+		opts = append(opts, WithBackend(backend.URL, 1))
+	}
+	pool := newTestPool(t, append(opts, WithHealthPath("/health"))...)
+	tests := []struct {
+		concurrency int
+		min, max    time.Duration
+	}{
+		{10, probe, 3 * probe}, //as long as the slowest probe
+		{1, 5 * probe, 10 * probe},
+	}
+	for _, tt := range tests {
+		pool.policies.healthConcurrency = tt.concurrency
+		start := time.Now()
+		up := pool.checkBackends(pool.Backends())
+		if d := time.Since(start); d < tt.min || d > tt.max {
+			t.Errorf("concurrency %d: health check took %s, want between %s and %s", tt.concurrency, d, tt.min, tt.max)
+		}
+		if up != 5 {
+			t.Errorf("concurrency %d: %d backends up, want 5", tt.concurrency, up)
+		}
+	}
+}
+
+func TestHealthAddress(t *testing.T) {
+	tests := []struct {
+		name       string
+		healthPath string
+		status     int //answered by the health port, 0 to close it
+		wantAlive  bool
+	}{
+		{"http passing", "/health", http.StatusOK, true},
+		{"http failing", "/health", http.StatusInternalServerError, false},
+		{"tcp open", "", http.StatusOK, true},
+		{"tcp closed", "", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var trafficProbes atomic.Int64
+			traffic := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == tt.healthPath {
+					trafficProbes.Add(1)
+				}
+			})
+			health := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+			})
+			healthAddr := health.Listener.Addr().String()
+			if tt.status == 0 {
+				health.Close()
+			}
+			pool := newTestPool(t, WithBackends(BackendConfig{Url: traffic.URL, Weight: 1, HealthAddress: healthAddr}), WithHealthPath(tt.healthPath))
+			b := pool.Backends()[0]
+			pool.checkBackends(pool.Backends())
+			if b.IsAlive() != tt.wantAlive {
+				t.Errorf("alive = %v, want %v", b.IsAlive(), tt.wantAlive)
+			}
+			if tt.healthPath != "" && trafficProbes.Load() > 0 {
+				t.Error("traffic port probed")
+			}
+			//traffic still goes to the main URL
+			if w := serve(pool.Handler(), httptest.NewRequest(http.MethodGet, "/", nil)); tt.wantAlive && w.Code != http.StatusOK {
+				t.Errorf("proxied status = %d, want %d", w.Code, http.StatusOK)
+			}
+		})
+	}
+}
+
+func TestNormalizeHealthAddress(t *testing.T) {
+	tests := []struct {
+		in, backend string
+		want, err   string
+	}{
+		{"10.0.0.1:9000", "http://10.0.0.1:8080", "http://10.0.0.1:9000", ""},
+		{"10.0.0.1:9000", "https://10.0.0.1:8443", "https://10.0.0.1:9000", ""},
+		{"10.0.0.1:9000", "unix:///run/app.sock", "http://10.0.0.1:9000", ""},
+		{"https://mgmt.example.com", "http://10.0.0.1:8080", "https://mgmt.example.com:443", ""},
+		{"10.0.0.1", "http://10.0.0.1:8080", "", "must be host:port or an http or https URL"},
+		{":9000", "http://10.0.0.1:8080", "", "must be host:port or an http or https URL"},
+		{"unix:///run/health.sock", "http://10.0.0.1:8080", "", "must be host:port or an http or https URL"},
+	}
+	for _, tt := range tests {
+		got, err := normalizeHealthAddress(tt.in, tt.backend)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("normalizeHealthAddress(%q, %q) error = %v, want %q", tt.in, tt.backend, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("normalizeHealthAddress(%q, %q) = %q, %v, want %q", tt.in, tt.backend, got, err, tt.want)
+		}
+	}
+}
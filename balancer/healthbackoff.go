@@ -0,0 +1,49 @@
+package balancer
+
+import "time"
+
+// HealthBackoff spaces out the probes of a backend that stays down: each
+// failed probe multiplies the time until the next one by Factor, starting
+// from the health check Interval and up to Max. A backend that comes back is
+// probed every Interval again. A zero Max disables the backoff.
+type HealthBackoff struct {
+	Interval time.Duration
+	Factor   float64
+	Max      time.Duration
+}
+
+// record schedules the next probe of b after one that started at start and
+// left it alive or down.
+func (h HealthBackoff) record(b *Backend, alive bool, start time.Time) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if alive || h.Max <= 0 {
+		b.probeDelay, b.nextProbe = 0, time.Time{}
+		return
+	}
+	if b.probeDelay == 0 {
+		b.probeDelay = min(h.Interval, h.Max)
+	} else {
+		b.probeDelay = min(time.Duration(float64(b.probeDelay)*h.Factor), h.Max)
+	}
+	b.nextProbe = start.Add(b.probeDelay)
+}
+
+// due returns the backends to probe in a scheduled health check at now.
+// Probes only run on the ticks of the health check, so one is due when its
+// time is closer to this tick than to the next.
+func (h HealthBackoff) due(backends []*Backend, now time.Time) []*Backend {
+	if h.Max <= 0 {
+		return backends
+	}
+	due := make([]*Backend, 0, len(backends))
+	for _, b := range backends {
+		b.mux.RLock()
+		next := b.nextProbe
+		b.mux.RUnlock()
+		if !now.Add(h.Interval / 2).Before(next) {
+			due = append(due, b)
+		}
+	}
+	return due
+}
@@ -0,0 +1,67 @@
+package balancer
+
+import (
+	"net/url"
+	"strings"
+)
+
+// HostRewrite sets the Host header of requests to backends: the client's
+// when Preserve is set, for backends serving several virtual hosts, the
+// backend's own host otherwise. An Override host is sent regardless.
+//
+// preserve_host defaults to true: the proxy has always sent the client's
+// Host, as NewSingleHostReverseProxy does too since it only rewrites the
+// URL, so defaulting to the backend host would break the virtual-hosted
+// backends relying on it. Set it to false for backends that only answer
+// to their own name.
+type HostRewrite struct {
+	Preserve bool
+	Override string
+}
+
+// SetHostRewrite sets the Host header rewrite of the pool's backends.
+func (s *ServerPool) SetHostRewrite(h HostRewrite) {
+	s.mux.Lock()
+	s.hostRewrite = h
+	s.mux.Unlock()
+}
+
+// hostRewrite returns the Host rewrite of b: the pool's, with the settings
+// b has of its own.
+func (b *Backend) hostRewrite() HostRewrite {
+	b.pool.mux.RLock()
+	h := b.pool.hostRewrite
+	b.pool.mux.RUnlock()
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	if b.PreserveHost != nil {
+		h.Preserve = *b.PreserveHost
+	}
+	if b.HostOverride != "" {
+		h.Override = b.HostOverride
+	}
+	return h
+}
+
+// host returns the Host header for a request from a client for in, empty
+// for the host of the backend URL.
+func (h HostRewrite) host(in string) string {
+	switch {
+	case h.Override != "":
+		return h.Override
+	case h.Preserve:
+		return in
+	}
+	return ""
+}
+
+// validateHost checks that host is a host name or address with an optional
+// port, as sent in a Host header.
+func validateHost(v *validator, field, host string) {
+	if host == "" {
+		return
+	}
+	if u, err := url.Parse("//" + host); err != nil || u.Host != host || strings.ContainsAny(host, " \t@") {
+		v.fail(field, "must be a host with an optional port, got %q", host)
+	}
+}
@@ -0,0 +1,41 @@
+package balancer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHostRewrite(t *testing.T) {
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host))
+	})
+	backendHost := strings.TrimPrefix(backend.URL, "http://")
+	yes, no := true, false
+	tests := []struct {
+		name    string
+		pool    []PoolOption
+		backend BackendConfig
+		want    string
+	}{
+		{"default", nil, BackendConfig{}, "client.example.com"},
+		{"backend host", []PoolOption{WithPreserveHost(false)}, BackendConfig{}, backendHost},
+		{"preserved", []PoolOption{WithPreserveHost(true)}, BackendConfig{}, "client.example.com"},
+		{"overridden", []PoolOption{WithPreserveHost(true), WithHostOverride("api.internal")}, BackendConfig{}, "api.internal"},
+		{"backend preserves", []PoolOption{WithPreserveHost(false)}, BackendConfig{PreserveHost: &yes}, "client.example.com"},
+		{"backend does not preserve", []PoolOption{WithPreserveHost(true)}, BackendConfig{PreserveHost: &no}, backendHost},
+		{"backend overrides", []PoolOption{WithHostOverride("api.internal")}, BackendConfig{HostOverride: "b1.internal:8080"}, "b1.internal:8080"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc := tt.backend
+			bc.Url, bc.Weight = backend.URL, 1
+			pool := newTestPool(t, append(tt.pool, WithBackends(bc))...)
+			r := httptest.NewRequest(http.MethodGet, "http://client.example.com/", nil)
+			if got := serve(pool.Handler(), r).Body.String(); got != tt.want {
+				t.Errorf("backend got Host %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
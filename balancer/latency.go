@@ -0,0 +1,64 @@
+package balancer
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// LatencyTracker keeps a ring of the window most recent request durations.
+// Recording is O(1) under a short lock; percentiles are computed on demand.
+type LatencyTracker struct {
+	window int
+
+	mux     sync.Mutex
+	samples []time.Duration
+	next    int
+	sum     time.Duration
+}
+
+func (t *LatencyTracker) Observe(d time.Duration) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if len(t.samples) < t.window {
+		t.samples = append(t.samples, d)
+		t.sum += d
+		return
+	}
+	t.sum += d - t.samples[t.next]
+	t.samples[t.next] = d
+	t.next = (t.next + 1) % t.window
+}
+
+// Average returns the mean of the recorded samples, 0 if there are none.
+func (t *LatencyTracker) Average() time.Duration {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if len(t.samples) == 0 {
+		return 0
+	}
+	return t.sum / time.Duration(len(t.samples))
+}
+
+// Percentiles returns the nearest-rank value for each of ps, given in [0, 100].
+func (t *LatencyTracker) Percentiles(ps ...float64) []time.Duration {
+	t.mux.Lock()
+	sorted := append([]time.Duration(nil), t.samples...)
+	t.mux.Unlock()
+
+	result := make([]time.Duration, len(ps))
+	if len(sorted) == 0 {
+		return result
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	for i, p := range ps {
+		idx := int(p/100*float64(len(sorted))+0.5) - 1
+		if idx < 0 {
+			idx = 0
+		} else if idx >= len(sorted) {
+			idx = len(sorted) - 1
+		}
+		result[i] = sorted[idx]
+	}
+	return result
+}
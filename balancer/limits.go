@@ -0,0 +1,81 @@
+package balancer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+)
+
+var errResponseTooLarge = errors.New("response body exceeds max-response-bytes")
+
+// limitRequest caps the body of r at max bytes, 0 meaning unlimited, and
+// reports whether it responded 413. A request announcing a longer body gets a
+// 413 before reaching a backend; one that streams past the limit is cut
+// there, answered with a 413 if the backend has not responded yet, and its
+// connection closed. Such a request is never retried, nor does it count
+// against the backend: it would fail the same way anywhere. A body within the
+// limit is only replayed by a retry if it was small enough to be buffered,
+// see RetryPolicy.
+func limitRequest(w http.ResponseWriter, r *http.Request, max int64) bool {
+	if max <= 0 {
+		return false
+	}
+	if r.ContentLength > max {
+		http.Error(w, "Request entity too large", http.StatusRequestEntityTooLarge)
+		return true
+	}
+	if r.Body != nil && r.Body != http.NoBody {
+		r.Body = http.MaxBytesReader(w, r.Body, max)
+	}
+	return false
+}
+
+// requestTooLarge reports whether the proxy error e is a request body
+// exceeding the max_request_bytes limit.
+func requestTooLarge(e error) bool {
+	var tooLarge *http.MaxBytesError
+	return errors.As(e, &tooLarge)
+}
+
+// limitResponse wraps the body of resp so relaying it fails once it grows
+// past the max_response_bytes limit, 0 meaning unlimited. Longer responses
+// are cut, which the client sees as a truncated or reset response. Switching
+// protocols responses are left alone, the proxy needs their body to be the
+// raw connection.
+func (b *Backend) limitResponse(resp *http.Response) {
+	max := b.pool.policies.maxResponseBytes
+	if max <= 0 || resp.StatusCode == http.StatusSwitchingProtocols {
+		return
+	}
+	resp.Body = &limitedBody{ReadCloser: resp.Body, remaining: max, limit: max, backend: b, path: resp.Request.URL.Path, ctx: resp.Request.Context()}
+}
+
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+	limit     int64
+	backend   *Backend
+	path      string
+	ctx       context.Context
+}
+
+func (l *limitedBody) Read(p []byte) (int, error) {
+	if l.remaining <= 0 {
+		//only fail when there is actually more to read
+		var probe [1]byte
+		n, err := l.ReadCloser.Read(probe[:])
+		if n == 0 {
+			return 0, err
+		}
+		slog.ErrorContext(l.ctx, "response too large, cutting connection", "pool", l.backend.pool.Name, "backend", backendHost(l.backend.Url), "path", l.path, "limit", l.limit)
+		return 0, errResponseTooLarge
+	}
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+	n, err := l.ReadCloser.Read(p)
+	l.remaining -= int64(n)
+	return n, err
+}
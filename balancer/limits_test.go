@@ -0,0 +1,56 @@
+package balancer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestMaxRequestBytes(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		streamed bool
+		wantCode int
+		maxCalls int64
+	}{
+		{"within limit", strings.Repeat("x", 100), false, http.StatusOK, 1},
+		{"announced over limit", strings.Repeat("x", 101), false, http.StatusRequestEntityTooLarge, 0},
+		{"streamed over limit", strings.Repeat("x", 1000), true, http.StatusRequestEntityTooLarge, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int64
+			backend := newTestBackend(t, echoBody(&calls))
+			pool := newTestPool(t, WithBackend(backend.URL, 1), withSettings(func(cfg *Config) {
+				cfg.MaxRequestBytes = 100
+			}))
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if !limitRequest(w, r, pool.policies.maxRequestBytes) {
+					pool.Handler().ServeHTTP(w, r)
+				}
+			})
+
+			r := httptest.NewRequest(http.MethodPut, "/", strings.NewReader(tt.body))
+			if tt.streamed {
+				r.ContentLength = -1
+			}
+			w := serve(handler, r)
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantCode)
+			}
+			if w.Code == http.StatusOK && w.Body.String() != tt.body {
+				t.Errorf("backend got %d bytes, want %d", w.Body.Len(), len(tt.body))
+			}
+			//a body cut at the limit is not retried
+			if calls.Load() > tt.maxCalls {
+				t.Errorf("backend got %d requests, want at most %d", calls.Load(), tt.maxCalls)
+			}
+			if !pool.Backends()[0].IsAlive() {
+				t.Error("backend marked down for a request too large")
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package balancer
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+func parseLogLevel(level string) (slog.Level, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return lvl, fmt.Errorf("unknown log level %q", level)
+	}
+	return lvl, nil
+}
+
+func parseLogFormat(format string) (string, error) {
+	switch f := strings.ToLower(format); f {
+	case "text", "json":
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown log format %q", format)
+}
+
+// NewLogger builds the process logger. Per-request logs are emitted at debug
+// level so that info output stays quiet under load.
+func NewLogger(level, format string) (*slog.Logger, error) {
+	lvl, err := parseLogLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	f, err := parseLogFormat(format)
+	if err != nil {
+		return nil, err
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	if f == "json" {
+		return slog.New(requestIDHandler{slog.NewJSONHandler(os.Stderr, opts)}), nil
+	}
+	return slog.New(requestIDHandler{slog.NewTextHandler(os.Stderr, opts)}), nil
+}
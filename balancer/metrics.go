@@ -0,0 +1,155 @@
+package balancer
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	requestsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "lb_requests_total",
+		Help: "Total number of requests received by the load balancer.",
+	})
+	inFlightRequests = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "lb_in_flight_requests",
+		Help: "Number of requests currently being proxied, including those waiting for a concurrency slot.",
+	})
+	concurrencyRejectedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "lb_concurrency_rejected_total",
+		Help: "Number of requests refused because -max-concurrent requests were already in flight.",
+	})
+	backendRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "lb_backend_requests_total",
+		Help: "Number of requests proxied to each backend, including retries.",
+	}, []string{"pool", "backend"})
+	retriesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "lb_backend_retries_total",
+		Help: "Number of retries against the same backend after a proxy error.",
+	}, []string{"pool", "backend"})
+	proxyErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "lb_backend_proxy_errors_total",
+		Help: "Number of failed proxy attempts per backend.",
+	}, []string{"pool", "backend"})
+	markedDownTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "lb_backend_marked_down_total",
+		Help: "Number of times a backend was marked down after failing requests.",
+	}, []string{"pool", "backend"})
+	ejectionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "lb_backend_ejections_total",
+		Help: "Number of times a backend was ejected by outlier detection.",
+	}, []string{"pool", "backend"})
+	queueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "lb_queue_depth",
+		Help: "Number of requests waiting for a backend of the pool to get below max_connections.",
+	}, []string{"pool"})
+	queueMaxWait = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "lb_queue_max_wait_seconds",
+		Help: "Longest time a request of the pool waited for a backend to get below max_connections.",
+	}, []string{"pool"})
+	queueTimeoutsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "lb_queue_timeouts_total",
+		Help: "Number of requests refused after waiting -queue-wait for a backend to get below max_connections.",
+	}, []string{"pool"})
+	mirrorRequestsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "lb_mirror_requests_total",
+		Help: "Number of requests copied to the mirror.",
+	})
+	mirrorErrorsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "lb_mirror_errors_total",
+		Help: "Number of mirrored requests that failed or got a 5xx from the mirror.",
+	})
+	mirrorSkippedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "lb_mirror_skipped_total",
+		Help: "Number of requests picked for mirroring but not copied, their body being too large or too many copies pending.",
+	})
+
+	backendAliveDesc = prometheus.NewDesc("lb_backend_alive",
+		"Whether the backend is currently considered alive (1) or not (0).", []string{"pool", "backend"}, nil)
+	backendConnectionsDesc = prometheus.NewDesc("lb_backend_active_connections",
+		"Number of requests currently in flight to the backend.", []string{"pool", "backend"}, nil)
+	backendOpenConnectionsDesc = prometheus.NewDesc("lb_backend_open_connections",
+		"Number of connections open to the backend, in use or idle.", []string{"pool", "backend"}, nil)
+	backendIdleConnectionsDesc = prometheus.NewDesc("lb_backend_idle_connections",
+		"Number of connections open to the backend and kept idle for reuse.", []string{"pool", "backend"}, nil)
+	stateEntriesDesc = prometheus.NewDesc("lb_state_entries",
+		"Number of entries of state kept per client or backend, evicted by the state reaper.", []string{"state"}, nil)
+)
+
+func init() {
+	prometheus.MustRegister(requestsTotal, inFlightRequests, concurrencyRejectedTotal, backendRequestsTotal, retriesTotal, proxyErrorsTotal, markedDownTotal, ejectionsTotal, queueDepth, queueMaxWait, queueTimeoutsTotal, mirrorRequestsTotal, mirrorErrorsTotal, mirrorSkippedTotal)
+}
+
+// backendMetrics caches the labelled series of a backend so the hot path
+// does not have to look them up in the vectors on every request.
+type backendMetrics struct {
+	pool        string
+	host        string
+	requests    prometheus.Counter
+	retries     prometheus.Counter
+	proxyErrors prometheus.Counter
+	markedDown  prometheus.Counter
+	ejections   prometheus.Counter
+}
+
+func newBackendMetrics(pool, host string) *backendMetrics {
+	return &backendMetrics{
+		pool:        pool,
+		host:        host,
+		requests:    backendRequestsTotal.WithLabelValues(pool, host),
+		retries:     retriesTotal.WithLabelValues(pool, host),
+		proxyErrors: proxyErrorsTotal.WithLabelValues(pool, host),
+		markedDown:  markedDownTotal.WithLabelValues(pool, host),
+		ejections:   ejectionsTotal.WithLabelValues(pool, host),
+	}
+}
+
+// delete drops the backend's series once it has been removed from the pool.
+func (m *backendMetrics) delete() {
+	backendRequestsTotal.DeleteLabelValues(m.pool, m.host)
+	retriesTotal.DeleteLabelValues(m.pool, m.host)
+	proxyErrorsTotal.DeleteLabelValues(m.pool, m.host)
+	markedDownTotal.DeleteLabelValues(m.pool, m.host)
+	ejectionsTotal.DeleteLabelValues(m.pool, m.host)
+}
+
+// poolCollector reports backend gauges at scrape time instead of keeping
+// them up to date on every request. Being per LoadBalancer, it is registered
+// on a registry of its own, see New.
+type poolCollector struct {
+	router *Router
+	//rateLimiter is nil when rate limiting is disabled
+	rateLimiter *RateLimiter
+}
+
+func (c poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- backendAliveDesc
+	ch <- backendConnectionsDesc
+	ch <- backendOpenConnectionsDesc
+	ch <- backendIdleConnectionsDesc
+	ch <- stateEntriesDesc
+}
+
+func (c poolCollector) Collect(ch chan<- prometheus.Metric) {
+	buckets := 0
+	if c.rateLimiter != nil {
+		buckets = c.rateLimiter.size()
+	}
+	ch <- prometheus.MustNewConstMetric(stateEntriesDesc, prometheus.GaugeValue, float64(buckets), "rate_limit_buckets")
+	transports := 0
+	for _, pool := range c.router.Pools() {
+		transports += pool.transports.Load().size()
+	}
+	ch <- prometheus.MustNewConstMetric(stateEntriesDesc, prometheus.GaugeValue, float64(transports), "upstream_transports")
+	for _, pool := range c.router.Pools() {
+		for _, b := range pool.Backends() {
+			alive := 0.0
+			if b.IsAlive() {
+				alive = 1
+			}
+			ch <- prometheus.MustNewConstMetric(backendAliveDesc, prometheus.GaugeValue, alive, pool.Name, backendHost(b.Url))
+			ch <- prometheus.MustNewConstMetric(backendConnectionsDesc, prometheus.GaugeValue, float64(b.ActiveConnections()), pool.Name, backendHost(b.Url))
+			open, idle := b.UpstreamConnections()
+			ch <- prometheus.MustNewConstMetric(backendOpenConnectionsDesc, prometheus.GaugeValue, float64(open), pool.Name, backendHost(b.Url))
+			ch <- prometheus.MustNewConstMetric(backendIdleConnectionsDesc, prometheus.GaugeValue, float64(idle), pool.Name, backendHost(b.Url))
+		}
+	}
+}
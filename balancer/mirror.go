@@ -0,0 +1,127 @@
+package balancer
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Mirror copies Percent of the requests to Target, a shadow backend such as
+// a new version under test, and discards its responses. Copies are sent in
+// the background so clients never wait on the shadow; requests whose body is
+// larger than MaxBody, and requests arriving while MaxInFlight copies are
+// still pending, are not mirrored. Upgrades are never mirrored.
+type Mirror struct {
+	Target      *url.URL
+	Percent     float64
+	MaxBody     int64
+	MaxInFlight int
+
+	client *http.Client
+	slots  chan struct{}
+}
+
+// mirrorTimeout bounds a mirrored request including its response body.
+const mirrorTimeout = 30 * time.Second
+
+// mirrorDefaults are the limits of a Mirror unless configured otherwise.
+var mirrorDefaults = Mirror{MaxBody: 1 << 20, MaxInFlight: 100}
+
+func NewMirror(target *url.URL, percent float64, maxBody int64, maxInFlight int) *Mirror {
+	return &Mirror{
+		Target:      target,
+		Percent:     percent,
+		MaxBody:     maxBody,
+		MaxInFlight: maxInFlight,
+		client:      &http.Client{Timeout: mirrorTimeout},
+		slots:       make(chan struct{}, maxInFlight),
+	}
+}
+
+// mirrorRequest sends a copy of r to m, nil when mirroring is disabled, if
+// it is picked, and returns r with its body replaced by one that can still
+// be read if it had to be buffered for the copy.
+func mirrorRequest(m *Mirror, r *http.Request) *http.Request {
+	if m == nil || isUpgrade(r) || rand.Float64()*100 >= m.Percent {
+		return r
+	}
+	body, ok := bufferBody(r, m.MaxBody)
+	if !ok {
+		mirrorSkippedTotal.Inc()
+		return r
+	}
+	select {
+	case m.slots <- struct{}{}:
+	default:
+		mirrorSkippedTotal.Inc()
+		return r
+	}
+	//the copy outlives the client request but keeps its values for logging
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), mirrorTimeout)
+	out := r.Clone(ctx)
+	out.URL.Scheme = m.Target.Scheme
+	out.URL.Host = m.Target.Host
+	out.RequestURI = ""
+	out.Header.Del(BackendOverrideHeader)
+	out.Header.Del(WeightOverrideHeader)
+	out.Header.Del(AdminTokenHeader)
+	out.Header.Del("Connection")
+	out.Body = nil
+	out.ContentLength = int64(len(body))
+	if body != nil {
+		out.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	go func() {
+		defer func() {
+			cancel()
+			<-m.slots
+		}()
+		m.send(out)
+	}()
+	return r
+}
+
+func (m *Mirror) send(r *http.Request) {
+	mirrorRequestsTotal.Inc()
+	resp, err := m.client.Do(r)
+	if err != nil {
+		mirrorErrorsTotal.Inc()
+		slog.WarnContext(r.Context(), "mirror error", "mirror", m.Target.Host, "path", r.URL.Path, "error", err)
+		return
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 500 {
+		mirrorErrorsTotal.Inc()
+		slog.WarnContext(r.Context(), "mirror error", "mirror", m.Target.Host, "path", r.URL.Path, "status", resp.StatusCode)
+	}
+}
+
+// bufferBody reads the body of r, up to max bytes, and puts back a body
+// replaying what was read followed by the rest. It reports false when the
+// body is larger than max or cannot be read, leaving the error to the proxy.
+func bufferBody(r *http.Request, max int64) ([]byte, bool) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, true
+	}
+	if r.ContentLength > max {
+		return nil, false
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, max+1))
+	r.Body = replayBody{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+	if err != nil || int64(len(body)) > max {
+		return nil, false
+	}
+	return body, true
+}
+
+// replayBody reads from Reader but closes the original body.
+type replayBody struct {
+	io.Reader
+	io.Closer
+}
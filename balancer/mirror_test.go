@@ -0,0 +1,140 @@
+package balancer
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// mirrored is a request received by the mirror.
+type mirrored struct {
+	method, path string
+	header       http.Header
+	body         string
+}
+
+// newTestMirror starts a mirror answering status and sending the requests it
+// gets to the returned channel, with all requests picked.
+func newTestMirror(t *testing.T, status int, maxBody int64, maxInFlight int) (*Mirror, <-chan mirrored) {
+	t.Helper()
+	got := make(chan mirrored, 10)
+	srv := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got <- mirrored{r.Method, r.URL.RequestURI(), r.Header, string(body)}
+		w.WriteHeader(status)
+	})
+	target, _ := url.Parse(srv.URL)
+	return NewMirror(target, 100, maxBody, maxInFlight), got
+}
+
+func TestMirrorRequest(t *testing.T) {
+	m, got := newTestMirror(t, http.StatusOK, 1<<10, 10)
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Backend", "primary")
+		io.WriteString(w, "primary got "+string(body))
+	})
+	pool := newTestPool(t, WithBackend(backend.URL, 1))
+
+	const body = "binary\x00body\r\n\xff"
+	r := httptest.NewRequest(http.MethodPost, "/orders?id=7", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/octet-stream")
+	r.Header.Set(AdminTokenHeader, "secret")
+	w := serve(pool.Handler(), mirrorRequest(m, r))
+
+	if w.Code != http.StatusOK || w.Body.String() != "primary got "+body || w.Header().Get("X-Backend") != "primary" {
+		t.Errorf("response = %d %q, want the primary's 200 %q", w.Code, w.Body, "primary got "+body)
+	}
+	req := <-got
+	if req.method != http.MethodPost || req.path != "/orders?id=7" || req.body != body {
+		t.Errorf("mirror got %s %s %q, want %s %s %q", req.method, req.path, req.body, http.MethodPost, "/orders?id=7", body)
+	}
+	if ct := req.header.Get("Content-Type"); ct != "application/octet-stream" {
+		t.Errorf("mirror Content-Type = %q, want it copied", ct)
+	}
+	if token := req.header.Get(AdminTokenHeader); token != "" {
+		t.Errorf("mirror got %s %q, want it removed", AdminTokenHeader, token)
+	}
+}
+
+func TestMirrorSkipped(t *testing.T) {
+	tests := []struct {
+		name          string
+		contentLength int64 //-1 for a body of unknown length
+		pending       bool  //whether the only pending slot is taken
+		wantSkipped   bool
+	}{
+		{"within limit", 8, false, false},
+		{"over limit", 9, false, true},
+		{"unknown length over limit", -1, false, true},
+		{"slots full", 8, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, got := newTestMirror(t, http.StatusOK, 8, 1)
+			if tt.pending {
+				m.slots <- struct{}{}
+			}
+			body := strings.Repeat("x", 9)
+			if tt.contentLength >= 0 {
+				body = body[:tt.contentLength]
+			}
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+			r.ContentLength = tt.contentLength
+
+			want := testutil.ToFloat64(mirrorSkippedTotal)
+			if tt.wantSkipped {
+				want++
+			}
+			r = mirrorRequest(m, r)
+			if got := testutil.ToFloat64(mirrorSkippedTotal); got != want {
+				t.Errorf("lb_mirror_skipped_total = %g, want %g", got, want)
+			}
+			//the primary still gets the whole body, even when partly read for the copy
+			if b, _ := io.ReadAll(r.Body); string(b) != body {
+				t.Errorf("primary body = %q, want %q", b, body)
+			}
+			if !tt.wantSkipped {
+				if req := <-got; req.body != body {
+					t.Errorf("mirror body = %q, want %q", req.body, body)
+				}
+			}
+			if len(got) != 0 {
+				t.Error("skipped request mirrored")
+			}
+		})
+	}
+}
+
+func TestMirrorErrors(t *testing.T) {
+	down := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {})
+	downUrl, _ := url.Parse(down.URL)
+	down.Close()
+	failing, _ := newTestMirror(t, http.StatusServiceUnavailable, 1<<10, 10)
+	tests := []struct {
+		name   string
+		mirror *Mirror
+	}{
+		{"mirror down", NewMirror(downUrl, 100, 1<<10, 10)},
+		{"mirror 5xx", failing},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, "ok")
+			})
+			pool := newTestPool(t, WithBackend(backend.URL, 1))
+			errors := testutil.ToFloat64(mirrorErrorsTotal)
+			w := serve(pool.Handler(), mirrorRequest(tt.mirror, httptest.NewRequest(http.MethodGet, "/", nil)))
+			if w.Code != http.StatusOK || w.Body.String() != "ok" {
+				t.Errorf("response = %d %q, want 200 %q", w.Code, w.Body, "ok")
+			}
+			waitFor(t, func() bool { return testutil.ToFloat64(mirrorErrorsTotal) == errors+1 })
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package balancer
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// PoolOption customizes the pool built by NewServerPool. Settings that are
+// not given are those of DefaultConfig.
+type PoolOption func(*poolOptions)
+
+// poolOptions are the pool settings and the policies given to NewServerPool.
+type poolOptions struct {
+	PoolConfig
+	cfg   Config
+	clock Clock
+}
+
+// WithBackend adds the backend at url with weight.
+func WithBackend(url string, weight int) PoolOption {
+	return func(pc *poolOptions) {
+		pc.Backends = append(pc.Backends, BackendConfig{Url: url, Weight: weight})
+	}
+}
+
+// WithBackends adds backends, which may set per-backend health checks and
+// connection limits.
+func WithBackends(backends ...BackendConfig) PoolOption {
+	return func(pc *poolOptions) {
+		pc.Backends = append(pc.Backends, backends...)
+	}
+}
+
+// WithStrategy sets the load balancing strategy, e.g. "least-connections".
+func WithStrategy(strategy string) PoolOption {
+	return func(pc *poolOptions) {
+		pc.Strategy = strategy
+	}
+}
+
+// WithHealthPath probes path over HTTP instead of dialing the backends.
+func WithHealthPath(path string) PoolOption {
+	return func(pc *poolOptions) {
+		pc.HealthPath = path
+	}
+}
+
+// WithHealthStatus sets the status code or range, e.g. "200-399", treated as
+// healthy by HTTP health checks.
+func WithHealthStatus(status string) PoolOption {
+	return func(pc *poolOptions) {
+		pc.HealthStatus = status
+	}
+}
+
+// WithHealthThresholds sets the consecutive passed and failed health checks
+// that bring a backend up and down.
+func WithHealthThresholds(rise, fall int) PoolOption {
+	return func(pc *poolOptions) {
+		pc.HealthRise = rise
+		pc.HealthFall = fall
+	}
+}
+
+// WithStickyCookie pins clients to a backend with the cookie name, kept for
+// ttl or the browser session if 0.
+func WithStickyCookie(name string, ttl time.Duration) PoolOption {
+	return func(pc *poolOptions) {
+		pc.StickyCookie = name
+		pc.StickyTTL = ttl
+	}
+}
+
+// WithTimeouts sets the timeouts of requests to the backends.
+func WithTimeouts(t UpstreamTimeouts) PoolOption {
+	return func(pc *poolOptions) {
+		pc.DialTimeout = &t.Dial
+		pc.ResponseHeaderTimeout = &t.ResponseHeader
+		pc.RequestTimeout = &t.Request
+		pc.TotalTimeout = &t.Total
+	}
+}
+
+// WithResponseHeaders rewrites the responses relayed from the backends.
+func WithResponseHeaders(rules HeaderRules) PoolOption {
+	return func(pc *poolOptions) {
+		pc.ResponseHeaders = rules
+	}
+}
+
+// WithCORS answers CORS preflights and sets the CORS headers of responses.
+func WithCORS(policy CORSPolicy) PoolOption {
+	return func(pc *poolOptions) {
+		pc.CORS = policy
+	}
+}
+
+// WithPreserveHost sends backends the Host of the client when set, the
+// default, the host of their URL otherwise; see HostRewrite.
+func WithPreserveHost(preserve bool) PoolOption {
+	return func(pc *poolOptions) {
+		pc.PreserveHost = &preserve
+	}
+}
+
+// WithHostOverride sends backends host as the Host header.
+func WithHostOverride(host string) PoolOption {
+	return func(pc *poolOptions) {
+		pc.HostOverride = host
+	}
+}
+
+// WithPolicies takes retries, circuit breaking and the other policies of cfg
+// that are not per pool, such as those New applies to all of its pools,
+// instead of those of DefaultConfig. The pools, routes and backends of cfg
+// are ignored.
+func WithPolicies(cfg Config) PoolOption {
+	return func(o *poolOptions) {
+		o.cfg = cfg
+	}
+}
+
+// WithPoolClock makes the pool tell the time from c instead of the system
+// clock, as WithClock does for a LoadBalancer.
+func WithPoolClock(c Clock) PoolOption {
+	return func(o *poolOptions) {
+		o.clock = c
+	}
+}
+
+// NewServerPool builds a standalone pool, not served by a LoadBalancer, to be
+// mounted with Handler. Its backends are assumed up until RunHealthCheck
+// says otherwise. The pool holds its own policies, see WithPolicies.
+func NewServerPool(name string, opts ...PoolOption) (*ServerPool, error) {
+	o := poolOptions{cfg: DefaultConfig(), clock: realClock{}}
+	o.PoolConfig = o.cfg.defaultPoolConfig()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	pc := o.PoolConfig
+	v := &validator{}
+	pc.validate(v, "")
+	validateBackends(v, "", pc.Backends)
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+	//only the policies of cfg are used, checked against the backends of the pool
+	cfg := o.cfg
+	cfg.Backends, cfg.Pools, cfg.Routes = pc.Backends, nil, nil
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	p, err := newPolicies(&cfg, o.clock)
+	if err != nil {
+		return nil, err
+	}
+	pool := &ServerPool{Name: name, lastUp: -1, policies: p}
+	backends := make([]*Backend, 0, len(pc.Backends))
+	for _, bc := range pc.Backends {
+		b, err := newBackend(bc, pool)
+		if err != nil {
+			return nil, err
+		}
+		backends = append(backends, b)
+	}
+	pool.Configure(pc)
+	pool.SetBackends(backends)
+	for _, b := range backends {
+		b.SetAlive(true)
+	}
+	return pool, nil
+}
+
+// Handler returns the handler proxying requests to the pool, for a pool that
+// is not served by a LoadBalancer.
+func (s *ServerPool) Handler() http.Handler {
+	return http.HandlerFunc(s.serve)
+}
+
+// RunHealthCheck health checks the backends of a pool built by NewServerPool
+// every interval until ctx is done. Pools of a LoadBalancer are checked by
+// its Run.
+func (s *ServerPool) RunHealthCheck(ctx context.Context, interval time.Duration) {
+	s.HeadthCheck(false)
+	t := s.policies.clock.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C():
+			s.HeadthCheck(false)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package balancer
+
+import (
+	"cmp"
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// OutlierDetection ejects backends that fail or slow down requests much more
+// than the rest of their pool, on top of health checks and circuit breakers.
+// Every Interval, the backends that served at least MinRequests since the
+// last sweep are compared: one is ejected when its error rate, counting 5xx
+// responses and proxy errors, exceeds the pool median by ErrorRate, or when
+// its mean latency exceeds LatencyFactor times the median. Both checks are
+// off at 0. An ejected backend takes no traffic for BaseEjection times the
+// number of times it has been ejected, up to MaxEjection; that count goes
+// down again with every sweep it passes. At most MaxEjectedPercent of the
+// backends of a pool, and at least one, are ejected at once. A zero Interval
+// disables outlier detection.
+type OutlierDetection struct {
+	Interval          time.Duration
+	ErrorRate         float64
+	LatencyFactor     float64
+	MinRequests       int
+	BaseEjection      time.Duration
+	MaxEjection       time.Duration
+	MaxEjectedPercent int
+}
+
+// outlierState counts the requests of a backend between two sweeps and keeps
+// its ejection.
+type outlierState struct {
+	mux      sync.Mutex
+	requests int
+	errors   int
+	latency  time.Duration
+
+	ejected      atomic.Bool
+	ejectedUntil time.Time
+	ejections    int
+}
+
+// observe counts a finished attempt, failed or not, when d is enabled.
+func (o *outlierState) observe(d OutlierDetection, failed bool) {
+	if d.Interval <= 0 {
+		return
+	}
+	o.mux.Lock()
+	o.requests++
+	if failed {
+		o.errors++
+	}
+	o.mux.Unlock()
+}
+
+func (o *outlierState) observeLatency(d OutlierDetection, latency time.Duration) {
+	if d.Interval <= 0 {
+		return
+	}
+	o.mux.Lock()
+	o.latency += latency
+	o.mux.Unlock()
+}
+
+// Ejection returns whether b is ejected, until when, and how many times in a
+// row it has been.
+func (b *Backend) Ejection() (ejected bool, until time.Time, ejections int) {
+	b.outlier.mux.Lock()
+	defer b.outlier.mux.Unlock()
+	return b.outlier.ejected.Load(), b.outlier.ejectedUntil, b.outlier.ejections
+}
+
+// run sweeps every pool of rt each Interval until ctx is done.
+func (d OutlierDetection) run(ctx context.Context, rt *Router) {
+	t := rt.policies.clock.NewTicker(d.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case now := <-t.C():
+			for _, pool := range rt.Pools() {
+				pool.detectOutliers(d, now)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// outlierSample is what a backend did during the last interval.
+type outlierSample struct {
+	backend   *Backend
+	errorRate float64
+	latency   time.Duration
+}
+
+// detectOutliers brings back backends whose ejection is over and ejects the
+// ones standing out from the others during the last interval.
+func (s *ServerPool) detectOutliers(d OutlierDetection, now time.Time) {
+	backends := s.Backends()
+	ejected := 0
+	var samples []outlierSample
+	for _, b := range backends {
+		o := &b.outlier
+		o.mux.Lock()
+		requests, errors, latency := o.requests, o.errors, o.latency
+		o.requests, o.errors, o.latency = 0, 0, 0
+		if o.ejected.Load() && !now.Before(o.ejectedUntil) {
+			o.ejected.Store(false)
+			slog.Info("backend returned from ejection", "pool", s.Name, "backend", backendHost(b.Url), "ejections", o.ejections)
+		}
+		isEjected := o.ejected.Load()
+		o.mux.Unlock()
+		if isEjected {
+			ejected++
+			continue
+		}
+		if requests >= max(d.MinRequests, 1) && b.IsAlive() {
+			samples = append(samples, outlierSample{
+				backend:   b,
+				errorRate: float64(errors) / float64(requests),
+				latency:   latency / time.Duration(requests),
+			})
+		}
+	}
+	var outliers []outlierSample
+	if len(samples) >= 2 {
+		medianRate := median(samples, func(x outlierSample) float64 { return x.errorRate })
+		medianLatency := median(samples, func(x outlierSample) float64 { return float64(x.latency) })
+		for _, x := range samples {
+			if d.ErrorRate > 0 && x.errorRate > medianRate+d.ErrorRate ||
+				d.LatencyFactor > 0 && float64(x.latency) > medianLatency*d.LatencyFactor {
+				outliers = append(outliers, x)
+			}
+		}
+	}
+	//backends that behaved work off their past ejections
+	for _, x := range samples {
+		if !slices.ContainsFunc(outliers, func(o outlierSample) bool { return o.backend == x.backend }) {
+			x.backend.outlier.mux.Lock()
+			x.backend.outlier.ejections = max(x.backend.outlier.ejections-1, 0)
+			x.backend.outlier.mux.Unlock()
+		}
+	}
+	//worst first, in case the ejection cap is reached
+	slices.SortFunc(outliers, func(a, b outlierSample) int {
+		return cmp.Or(cmp.Compare(b.errorRate, a.errorRate), cmp.Compare(b.latency, a.latency))
+	})
+	limit := max(len(backends)*d.MaxEjectedPercent/100, 1)
+	for _, x := range outliers {
+		if ejected >= limit {
+			slog.Warn("outlier not ejected, too many backends ejected already", "pool", s.Name, "backend", backendHost(x.backend.Url), "ejected", ejected)
+			continue
+		}
+		ejected++
+		x.backend.eject(d, now, x)
+	}
+}
+
+func (b *Backend) eject(d OutlierDetection, now time.Time, x outlierSample) {
+	o := &b.outlier
+	o.mux.Lock()
+	o.ejections++
+	duration := min(d.BaseEjection*time.Duration(o.ejections), d.MaxEjection)
+	o.ejectedUntil = now.Add(duration)
+	o.ejected.Store(true)
+	ejections := o.ejections
+	o.mux.Unlock()
+	b.metrics.ejections.Inc()
+	slog.Warn("ejecting outlier backend", "pool", b.pool.Name, "backend", backendHost(b.Url), "error_rate", x.errorRate, "latency", x.latency, "duration", duration, "ejections", ejections)
+}
+
+func median(samples []outlierSample, value func(outlierSample) float64) float64 {
+	values := make([]float64, len(samples))
+	for i, x := range samples {
+		values[i] = value(x)
+	}
+	slices.Sort(values)
+	n := len(values)
+	if n%2 == 1 {
+		return values[n/2]
+	}
+	return (values[n/2-1] + values[n/2]) / 2
+}
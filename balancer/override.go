@@ -0,0 +1,38 @@
+package balancer
+
+import (
+	"net/http"
+)
+
+// BackendOverrideHeader names the backend a client asks for, see
+// overridePeer.
+const BackendOverrideHeader = "X-LB-Backend"
+
+// overridePeer returns the backend requested in the override header when
+// backend overrides are enabled and it is available, or nil to fall back to
+// the strategy. Overrides let clients pick the backend of a request by URL,
+// e.g. to send test traffic to a canary; they must only be enabled where
+// clients can be trusted to bypass the strategy. A requested backend that
+// is not in s gets a 421 and ok false, as does an invalid URL with a 400.
+// The caller must not hold s.mux.
+func (s *ServerPool) overridePeer(w http.ResponseWriter, r *http.Request) (peer *Backend, ok bool) {
+	requested := r.Header.Get(BackendOverrideHeader)
+	if !s.policies.backendOverride || requested == "" {
+		return nil, true
+	}
+	backendUrl, err := normalizeBackendUrl(requested)
+	if err != nil {
+		http.Error(w, "Invalid "+BackendOverrideHeader+" header: "+err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	b := s.Backend(backendUrl)
+	if b == nil {
+		http.Error(w, "Backend "+backendUrl+" is not in pool "+s.Name, http.StatusMisdirectedRequest)
+		return nil, false
+	}
+	if !b.IsAvailable() {
+		return nil, true
+	}
+	b.breaker.Begin()
+	return b, true
+}
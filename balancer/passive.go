@@ -0,0 +1,67 @@
+package balancer
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// PassivePolicy marks a backend down after Failures consecutive failed live
+// requests, without waiting for the next active probe. Connection errors
+// always count, 5xx responses only with Count5xx. So do responses whose Header
+// holds one of DegradedValues, which also count against the circuit breaker;
+// backends use that to report trouble while still answering 200. A Failures
+// threshold of 0 disables passive checks; the active check brings backends
+// back either way.
+type PassivePolicy struct {
+	Failures       int
+	Count5xx       bool
+	Header         string
+	DegradedValues []string
+}
+
+func (b *Backend) recordPassiveFailure() {
+	failures := b.pool.policies.passive.Failures
+	if failures <= 0 {
+		return
+	}
+	if atomic.AddInt64(&b.passiveFailures, 1) < int64(failures) {
+		return
+	}
+	atomic.StoreInt64(&b.passiveFailures, 0)
+	if b.IsAlive() {
+		slog.Warn("backend marked down by passive health check", "pool", b.pool.Name, "backend", backendHost(b.Url), "failures", failures)
+		b.pool.MarkBackendStatus(b.Url, false)
+	}
+}
+
+func (b *Backend) recordPassiveSuccess() {
+	atomic.StoreInt64(&b.passiveFailures, 0)
+}
+
+// degraded reports whether the headers of resp say the backend is degraded.
+// Values are compared case-insensitively.
+func (p PassivePolicy) degraded(resp *http.Response) bool {
+	if p.Header == "" {
+		return false
+	}
+	for _, v := range resp.Header.Values(p.Header) {
+		for _, bad := range p.DegradedValues {
+			if strings.EqualFold(strings.TrimSpace(v), bad) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// inspectResponse feeds a backend response into passive health checking.
+func (b *Backend) inspectResponse(resp *http.Response) {
+	p := b.pool.policies.passive
+	if p.Count5xx && resp.StatusCode >= http.StatusInternalServerError || p.degraded(resp) {
+		b.recordPassiveFailure()
+		return
+	}
+	b.recordPassiveSuccess()
+}
@@ -0,0 +1,101 @@
+package balancer
+
+import (
+	"sync"
+	"time"
+)
+
+// policies are the settings of a Config that are not per pool, shared by
+// the pools of a LoadBalancer or held by a pool built by NewServerPool.
+// They are set when the LoadBalancer or pool is built; a reload only swaps
+// the pools and routes.
+type policies struct {
+	retry RetryPolicy
+	//retryBudget is nil when the budget is disabled
+	retryBudget *RetryBudget
+	breaker     BreakerPolicy
+	passive     PassivePolicy
+	//trustedProxies are the peers whose X-Forwarded-* headers are believed
+	trustedProxies proxyPrefixes
+	forwarded      ForwardedHeaders
+	bufferPool     *BufferPool
+	//maxRequestBytes and maxResponseBytes cap the bodies of a request and of
+	//a response, 0 for no limit, see limitRequest and limitResponse
+	maxRequestBytes  int64
+	maxResponseBytes int64
+	//unavailablePage answers requests for which no backend is available,
+	//badGatewayPage those that every backend tried for has failed
+	unavailablePage ErrorPage
+	badGatewayPage  ErrorPage
+	//drainTimeout bounds how long a removed backend may take to finish its
+	//requests in flight, see release
+	drainTimeout time.Duration
+	//slowStart is how long a backend that just came up takes to ramp up to
+	//its full weight, 0 to disable
+	slowStart         time.Duration
+	healthBackoff     HealthBackoff
+	healthConcurrency int
+	errorWeighting    ErrorWeighting
+	outlierDetection  OutlierDetection
+	//latencyWindow is the number of recent latency samples kept per backend
+	latencyWindow   int
+	keepAlive       UpstreamKeepAlive
+	backendOverride bool
+	weightOverrides WeightOverrides
+	failFast        bool
+	requestIDs      RequestIDPolicy
+	//queueWait is how long a request waits for a backend below its
+	//max_connections, see queuedPeer
+	queueWait time.Duration
+	hash      HashPolicy
+	tracing   bool
+	//clock tells the time, the system clock unless set by WithClock or
+	//WithPoolClock
+	clock Clock
+
+	//healthCheckMux serializes health check runs of the pools, scheduled or
+	//triggered, so a backend is never probed twice at once
+	healthCheckMux sync.Mutex
+}
+
+// newPolicies returns the policies of a validated cfg, telling the time from
+// c.
+func newPolicies(cfg *Config, c Clock) (*policies, error) {
+	page, err := cfg.UnavailablePage()
+	if err != nil {
+		return nil, err
+	}
+	p := &policies{
+		retry:             cfg.RetryPolicy(),
+		breaker:           BreakerPolicy{Failures: cfg.BreakerFailures, Cooldown: cfg.BreakerCooldown},
+		passive:           PassivePolicy{Failures: cfg.PassiveFailures, Count5xx: cfg.Passive5xx, Header: cfg.PassiveHeader, DegradedValues: cfg.PassiveHeaderValues},
+		trustedProxies:    cfg.TrustedProxyPrefixes(),
+		forwarded:         ForwardedHeaders{Enabled: cfg.ForwardedHeaders},
+		bufferPool:        NewBufferPool(cfg.ProxyBufferSize),
+		maxRequestBytes:   cfg.MaxRequestBytes,
+		maxResponseBytes:  cfg.MaxResponseBytes,
+		unavailablePage:   page,
+		badGatewayPage:    ErrorPage{Status: cfg.BadGatewayStatus},
+		drainTimeout:      cfg.DrainTimeout,
+		slowStart:         cfg.SlowStart,
+		healthBackoff:     HealthBackoff{Interval: cfg.HealthInterval, Factor: cfg.HealthBackoffFactor, Max: cfg.HealthBackoffMax},
+		healthConcurrency: cfg.HealthConcurrency,
+		errorWeighting:    ErrorWeighting{Sensitivity: cfg.ErrorWeightSensitivity, Window: cfg.ErrorWeightWindow},
+		outlierDetection:  cfg.OutlierDetection(),
+		latencyWindow:     cfg.LatencyWindow,
+		keepAlive:         cfg.UpstreamKeepAlive(),
+		backendOverride:   cfg.BackendOverride,
+		weightOverrides:   WeightOverrides{Token: cfg.AdminToken, TTL: cfg.WeightOverrideTTL, Max: cfg.WeightOverrideMax},
+		failFast:          cfg.FailFast,
+		requestIDs:        RequestIDPolicy{Header: cfg.RequestIDHeader, Trust: cfg.TrustRequestID},
+		queueWait:         cfg.QueueWait,
+		hash:              HashPolicy{Key: cfg.HashKey, VNodes: cfg.HashVNodes},
+		tracing:           cfg.Tracing,
+		clock:             c,
+	}
+	if cfg.RetryBudgetRatio > 0 {
+		p.retryBudget = NewRetryBudget(cfg.RetryBudgetRatio, cfg.RetryBudgetWindow)
+		p.retryBudget.clock = c
+	}
+	return p, nil
+}
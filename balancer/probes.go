@@ -0,0 +1,40 @@
+package balancer
+
+import (
+	"net/http"
+)
+
+// livezHandler reports that the process is up and serving.
+func livezHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+}
+
+// readyzHandler reports whether the load balancer can serve traffic, which
+// takes at least one alive backend in any pool and not being drained.
+func (l *LoadBalancer) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if l.drain.Draining() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("draining\n"))
+		return
+	}
+	if !l.router.Ready() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("no backend available\n"))
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
+
+// Ready reports whether any backend of any pool is alive.
+func (rt *Router) Ready() bool {
+	for _, pool := range rt.Pools() {
+		for _, b := range pool.Backends() {
+			if b.IsAlive() {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,188 @@
+package balancer
+
+import (
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+)
+
+// newBackend builds a backend of pool with a reverse proxy that retries and
+// fails over within that pool, following its policies. It starts out down
+// until a health check or the caller marks it alive.
+func newBackend(bc BackendConfig, pool *ServerPool) (*Backend, error) {
+	p := pool.policies
+	serverUrl, err := url.Parse(bc.Url)
+	if err != nil {
+		return nil, err
+	}
+	var healthUrl *url.URL
+	if bc.HealthAddress != "" {
+		if healthUrl, err = url.Parse(bc.HealthAddress); err != nil {
+			return nil, err
+		}
+	}
+	var tlsConfig *tls.Config
+	if bc.TLS.enabled() {
+		if tlsConfig, err = bc.TLS.config(); err != nil {
+			return nil, err
+		}
+	}
+	target := serverUrl
+	if isUnixSocket(serverUrl) {
+		target = unixTarget
+	}
+	var backend *Backend
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			stripPrefix(pr.Out.URL, GetStripPrefixFromContext(pr.In))
+			pr.SetURL(target)
+			pr.Out.Host = backend.hostRewrite().host(pr.In.Host)
+			pr.Out.Header.Del(BackendOverrideHeader)
+			pr.Out.Header.Del(WeightOverrideHeader)
+			pr.Out.Header.Del(AdminTokenHeader)
+			p.forwarded.set(pr, p.trustedProxies)
+			injectTrace(p.tracing, pr.Out)
+			//failed attempts are replayed from pr.In, see inbound
+			pr.Out = pr.Out.WithContext(context.WithValue(pr.Out.Context(), Inbound, pr.In))
+		},
+		BufferPool: p.bufferPool,
+	}
+	backend = &Backend{
+		Url:            serverUrl,
+		h2c:            bc.H2C,
+		Weight:         bc.Weight,
+		HealthPath:     bc.HealthPath,
+		HealthStatus:   bc.HealthStatus,
+		HealthUrl:      healthUrl,
+		PreserveHost:   bc.PreserveHost,
+		HostOverride:   bc.HostOverride,
+		MaxConnections: bc.MaxConnections,
+		Priority:       bc.Priority,
+		ReverseProxy:   proxy,
+		pool:           pool,
+	}
+	backend.breaker.policy, backend.breaker.clock = p.breaker, p.clock
+	backend.latency.window = p.latencyWindow
+	backend.tlsConfig.Store(tlsConfig)
+	backend.life, backend.stop = context.WithCancel(context.Background())
+	backend.transport = backendTransport{backend}
+	proxy.Transport = backend.transport
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		backend.responded(resp)
+		failed := resp.StatusCode >= http.StatusInternalServerError
+		if failed || p.passive.degraded(resp) {
+			backend.breaker.Failure()
+		} else {
+			backend.breaker.Success()
+		}
+		backend.errors.observe(p.errorWeighting, failed)
+		backend.outlier.observe(p.outlierDetection, failed)
+		backend.inspectResponse(resp)
+		backend.limitResponse(resp)
+		stripRequestID(p.requestIDs, resp)
+		pool.stripCORS(resp)
+		pool.rewriteResponseHeaders(resp)
+		return nil
+	}
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, e error) {
+		switch classifyProxyError(r, e) {
+		case clientFault:
+			backend.breaker.Abort()
+			if requestTooLarge(e) {
+				slog.WarnContext(r.Context(), "request too large", "pool", pool.Name, "backend", backendHost(serverUrl), "path", r.URL.Path, "limit", p.maxRequestBytes)
+				http.Error(w, "Request entity too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			slog.DebugContext(r.Context(), "client cancelled request", "pool", pool.Name, "backend", backendHost(serverUrl), "path", r.URL.Path, "error", e)
+			return
+		case balancerFault:
+			backend.breaker.Abort()
+			slog.InfoContext(r.Context(), "attempt cancelled", "pool", pool.Name, "backend", backendHost(serverUrl), "path", r.URL.Path, "error", context.Cause(r.Context()))
+		default:
+			slog.WarnContext(r.Context(), "proxy error", "pool", pool.Name, "backend", backendHost(serverUrl), "path", r.URL.Path, "retry", GetRetryFromContext(r), "error", e)
+			backend.metrics.proxyErrors.Inc()
+			backend.errors.observe(p.errorWeighting, true)
+			backend.outlier.observe(p.outlierDetection, true)
+			backend.breaker.Failure()
+			backend.recordPassiveFailure()
+		}
+
+		if isUpgrade(r) {
+			//the connection may already be hijacked, never replay it
+			p.serveBadGateway(w)
+			return
+		}
+		if !p.retry.replayable(r) {
+			p.serveBadGateway(w)
+			return
+		}
+
+		//the client is gone or the whole request timed out, don't replay
+		base := GetBaseContext(r)
+		if abandoned(w, base) {
+			return
+		}
+
+		//retry the same backend first, nothing has been written to w yet,
+		//unless its breaker has just opened or it has been marked down or removed
+		retries := GetRetryFromContext(r)
+		wantRetry := !p.retry.Disabled && retries < p.retry.MaxRetries && backend.breaker.Ready() && !cancelledAsDown(r) && !backend.released()
+		if wantRetry && !p.retryBudget.Allow() {
+			slog.WarnContext(r.Context(), "retry budget exhausted", "pool", pool.Name, "backend", backendHost(serverUrl), "path", r.URL.Path)
+			p.serveBadGateway(w)
+			return
+		}
+		if wantRetry {
+			backend.metrics.retries.Inc()
+			select {
+			case <-p.clock.After(p.retry.delay(retries)):
+				ctx := context.WithValue(base, Retry, retries+1)
+				in := inbound(r)
+				rewindBody(in)
+				backend.proxy(w, in.WithContext(ctx))
+			case <-base.Done():
+				abandoned(w, base)
+			}
+			return
+		}
+
+		//retries exhausted, take the backend out and fail over to another one
+		pool.MarkBackendStatus(serverUrl, false)
+		attemps := GetAttemptsFromContext(r)
+		slog.InfoContext(r.Context(), "failing over", "pool", pool.Name, "client", r.RemoteAddr, "path", r.URL.Path, "attempt", attemps+1)
+		ctx := context.WithValue(base, Attempts, attemps+1)
+		ctx = context.WithValue(ctx, Retry, 0)
+		in := inbound(r)
+		rewindBody(in)
+
+		pool.ServeHTTP(w, in.WithContext(ctx))
+	}
+	return backend, nil
+}
+
+// inbound returns the request that the proxy rewrote into r, the request of
+// a failed attempt. Replaying it rather than r has the next attempt rewrite
+// the path, Host and headers of the client afresh instead of applying the
+// rewrites a second time.
+func inbound(r *http.Request) *http.Request {
+	if in, ok := r.Context().Value(Inbound).(*http.Request); ok {
+		return in
+	}
+	return r
+}
+
+// abandoned reports whether ctx, the context spanning all attempts of a
+// request, is done. A client that hit the total timeout gets a 504; one that
+// disconnected gets nothing.
+func abandoned(w http.ResponseWriter, ctx context.Context) bool {
+	switch ctx.Err() {
+	case nil:
+		return false
+	case context.DeadlineExceeded:
+		http.Error(w, "Gateway timeout", http.StatusGatewayTimeout)
+	}
+	return true
+}
@@ -0,0 +1,218 @@
+package balancer
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// hangUp reads the request body and then drops the connection without a
+// response, failing the attempt after the body has been consumed.
+func hangUp(hits *atomic.Int64) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		io.ReadAll(r.Body)
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	}
+}
+
+// echoBody answers with the request body.
+func echoBody(hits *atomic.Int64) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}
+}
+
+// headerCounter counts the responses started on the ResponseWriter.
+type headerCounter struct {
+	*httptest.ResponseRecorder
+	headers int
+}
+
+func (w *headerCounter) WriteHeader(code int) {
+	w.headers++
+	w.ResponseRecorder.WriteHeader(code)
+}
+
+func (w *headerCounter) Write(b []byte) (int, error) {
+	if w.headers == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseRecorder.Write(b)
+}
+
+func TestFailoverWritesResponseOnce(t *testing.T) {
+	var failed, served atomic.Int64
+	bad := newTestBackend(t, hangUp(&failed))
+	good := newTestBackend(t, echoBody(&served))
+	pool := newTestPool(t, WithBackend(bad.URL, 1), WithBackend(good.URL, 1))
+
+	w := &headerCounter{ResponseRecorder: httptest.NewRecorder()}
+	pool.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.headers != 1 {
+		t.Errorf("response started %d times, want once", w.headers)
+	}
+	if w.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if got, want := failed.Load(), int64(pool.policies.retry.MaxRetries+1); got != want {
+		t.Errorf("failing backend got %d attempts, want %d", got, want)
+	}
+	if served.Load() != 1 {
+		t.Errorf("healthy backend got %d requests, want 1", served.Load())
+	}
+}
+
+func TestRetryReplaysBody(t *testing.T) {
+	body := strings.Repeat("0123456789", 1000)
+	tests := []struct {
+		name       string
+		maxBody    int64
+		status     int
+		wantServed int64
+	}{
+		{"buffered", int64(len(body)), http.StatusOK, 1},
+		{"too large to buffer", int64(len(body)) - 1, http.StatusBadGateway, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var failed, served atomic.Int64
+			bad := newTestBackend(t, hangUp(&failed))
+			good := newTestBackend(t, echoBody(&served))
+			pool := newTestPool(t, WithBackend(bad.URL, 1), WithBackend(good.URL, 1), withSettings(func(cfg *Config) {
+				cfg.MaxRetries, cfg.RetryMaxBody = 0, tt.maxBody
+			}))
+
+			w := serve(pool.Handler(), httptest.NewRequest(http.MethodPut, "/", strings.NewReader(body)))
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			if failed.Load() != 1 {
+				t.Errorf("failing backend got %d attempts, want 1", failed.Load())
+			}
+			if served.Load() != tt.wantServed {
+				t.Errorf("healthy backend got %d requests, want %d", served.Load(), tt.wantServed)
+			}
+			if tt.status == http.StatusOK && w.Body.String() != body {
+				t.Errorf("healthy backend got a body of %d bytes, want %d", w.Body.Len(), len(body))
+			}
+		})
+	}
+}
+
+func TestClientCancelReachesBackend(t *testing.T) {
+	started, cancelled := make(chan struct{}), make(chan struct{})
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		select {
+		case <-r.Context().Done():
+			close(cancelled)
+		case <-time.After(5 * time.Second):
+		}
+	})
+	pool := newTestPool(t, WithBackend(backend.URL, 1))
+	lb := httptest.NewServer(pool.Handler())
+	defer lb.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, lb.URL, nil)
+	go func() {
+		<-started
+		cancel()
+	}()
+	if _, err := lb.Client().Do(req); err == nil {
+		t.Fatal("request succeeded despite being cancelled")
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("backend request not cancelled after the client went away")
+	}
+}
+
+func TestTotalTimeoutReachesBackend(t *testing.T) {
+	cancelled := make(chan struct{})
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			close(cancelled)
+		case <-time.After(5 * time.Second):
+		}
+	})
+	pool := newTestPool(t, WithBackend(backend.URL, 1), WithTimeouts(UpstreamTimeouts{Dial: time.Second, ResponseHeader: 5 * time.Second, Total: 50 * time.Millisecond}))
+
+	w := serve(pool.Handler(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusGatewayTimeout {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusGatewayTimeout)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("backend request not cancelled after the total timeout")
+	}
+}
+
+func TestFailoverTriesEachBackendOnce(t *testing.T) {
+	var first, second atomic.Int64
+	pool := newTestPool(t, WithBackend(newTestBackend(t, hangUp(&first)).URL, 1), WithBackend(newTestBackend(t, hangUp(&second)).URL, 1), withSettings(func(cfg *Config) {
+		cfg.MaxRetries, cfg.MaxAttempts = 0, 5
+	}))
+
+	w := serve(pool.Handler(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusBadGateway)
+	}
+	if first.Load() != 1 || second.Load() != 1 {
+		t.Errorf("backends got %d and %d attempts, want one each", first.Load(), second.Load())
+	}
+}
+
+func TestNoRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		failing  int //backends hanging up, listed before the healthy one
+		healthy  bool
+		wantCode int
+		wantHits int64 //attempts against the failing backends
+	}{
+		{"single backend", 1, false, http.StatusBadGateway, 1},
+		{"failover", 1, true, http.StatusOK, 1},
+		{"failover once", 3, false, http.StatusBadGateway, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var failed, served atomic.Int64
+			var opts []PoolOption
+			for range tt.failing {
+				opts = append(opts, WithBackend(newTestBackend(t, hangUp(&failed)).URL, 1))
+			}
+			if tt.healthy {
+				opts = append(opts, WithBackend(newTestBackend(t, echoBody(&served)).URL, 1))
+			}
+			pool := newTestPool(t, append(opts, withSettings(func(cfg *Config) {
+				cfg.NoRetry = true
+			}))...)
+
+			w := serve(pool.Handler(), httptest.NewRequest(http.MethodGet, "/", nil))
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantCode)
+			}
+			if failed.Load() != tt.wantHits {
+				t.Errorf("failing backends got %d attempts, want %d", failed.Load(), tt.wantHits)
+			}
+			if pool.Backends()[0].IsAlive() {
+				t.Error("failing backend not marked down")
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package balancer
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// proxyFault tells who caused an error of the proxy, and so whether it is
+// held against the backend.
+type proxyFault int
+
+const (
+	//the backend refused, reset or timed out the attempt: it counts towards
+	//the breaker, passive health and outlier detection, and is retried or
+	//failed over
+	backendFault proxyFault = iota
+	//the client went away or sent too large a body: nothing is counted, a
+	//circuit breaker trial is given back and the request is not replayed
+	clientFault
+	//the load balancer cancelled the attempt, as the backend was marked down
+	//or removed meanwhile: nothing is counted, a circuit breaker trial is
+	//given back and the request fails over
+	balancerFault
+)
+
+// classifyProxyError returns who caused err, returned by the proxy for the
+// attempt r.
+func classifyProxyError(r *http.Request, err error) proxyFault {
+	switch {
+	case requestTooLarge(err), GetBaseContext(r).Err() == context.Canceled:
+		return clientFault
+	case cancelledAsDown(r), errors.Is(context.Cause(r.Context()), errBackendReleased):
+		return balancerFault
+	}
+	return backendFault
+}
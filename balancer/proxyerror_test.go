@@ -0,0 +1,176 @@
+package balancer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClassifyProxyError(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	timedOut, cancelTimeout := context.WithTimeout(context.Background(), 0)
+	defer cancelTimeout()
+	cause := func(err error) context.Context {
+		ctx, cancel := context.WithCancelCause(context.Background())
+		cancel(err)
+		return ctx
+	}
+	dialErr := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	tests := []struct {
+		name string
+		ctx  context.Context //of the attempt
+		base context.Context //of the request, the attempt's if nil
+		err  error
+		want proxyFault
+	}{
+		{"client cancel", cancelled, nil, context.Canceled, clientFault},
+		{"client cancel during attempt timeout", timedOut, cancelled, context.Canceled, clientFault},
+		{"attempt timeout", timedOut, context.Background(), context.DeadlineExceeded, backendFault},
+		{"dial error", context.Background(), nil, dialErr, backendFault},
+		{"too large body", context.Background(), nil, &http.MaxBytesError{Limit: 10}, clientFault},
+		{"marked down", cause(errBackendDown), context.Background(), context.Canceled, balancerFault},
+		{"released", cause(errBackendReleased), context.Background(), context.Canceled, balancerFault},
+	}
+	for _, tt := range tests {
+		ctx := tt.ctx
+		if tt.base != nil {
+			ctx = context.WithValue(ctx, BaseContext, tt.base)
+		}
+		r := httptest.NewRequestWithContext(ctx, http.MethodGet, "/", nil)
+		if got := classifyProxyError(r, tt.err); got != tt.want {
+			t.Errorf("%s: classifyProxyError = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestProxyErrorSideEffects checks what each kind of proxy error does to the
+// breaker of the backend and whether the request is retried.
+func TestProxyErrorSideEffects(t *testing.T) {
+	closed := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {})
+	closed.Close()
+	tests := []struct {
+		name      string
+		url       string //of the backend, a server hanging until done if empty
+		timeout   time.Duration
+		body      string
+		cancel    bool //whether the client goes away once the backend has the request
+		want      int
+		failures  int
+		wantCalls int64
+	}{
+		{"client cancel", "", 0, "", true, http.StatusOK, 0, 1}, //nothing written to a client gone
+		{"attempt timeout", "", 50 * time.Millisecond, "", false, http.StatusBadGateway, 2, 2},
+		{"dial error", closed.URL, 0, "", false, http.StatusBadGateway, 2, 0},
+		{"too large body", "", 0, strings.Repeat("x", 1000), false, http.StatusRequestEntityTooLarge, 0, -1}, //cut before or after reaching it
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int64
+			started := make(chan struct{}, 10)
+			url := tt.url
+			if url == "" {
+				url = newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+					calls.Add(1)
+					//the server only notices the client leaving past the body
+					io.ReadAll(r.Body)
+					started <- struct{}{}
+					<-r.Context().Done()
+				}).URL
+			}
+			timeouts := upstreamTimeouts
+			timeouts.Request = tt.timeout
+			pool := newTestPool(t, WithBackend(url, 1), WithTimeouts(timeouts), withSettings(func(cfg *Config) {
+				cfg.BreakerFailures, cfg.BreakerCooldown = 100, time.Hour
+				cfg.MaxRetries, cfg.RetryBackoff = 1, 0
+				cfg.MaxRequestBytes = 100
+			}))
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if !limitRequest(w, r, pool.policies.maxRequestBytes) {
+					pool.Handler().ServeHTTP(w, r)
+				}
+			})
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			r := httptest.NewRequestWithContext(ctx, http.MethodPut, "/", strings.NewReader(tt.body))
+			r.ContentLength = -1
+			if tt.cancel {
+				go func() {
+					<-started
+					cancel()
+				}()
+			}
+			w := serve(handler, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+			b := pool.Backends()[0]
+			b.breaker.mux.Lock()
+			failures := b.breaker.failures
+			b.breaker.mux.Unlock()
+			if failures != tt.failures {
+				t.Errorf("breaker failures = %d, want %d", failures, tt.failures)
+			}
+			if tt.wantCalls >= 0 && calls.Load() != tt.wantCalls {
+				t.Errorf("backend got %d attempts, want %d", calls.Load(), tt.wantCalls)
+			}
+			if tt.failures == 0 && !b.IsAlive() {
+				t.Error("backend marked down for an error that is not its fault")
+			}
+		})
+	}
+}
+
+// TestBreakerTrialClientCancel cancels the trial request of a half-open
+// breaker: the next request must be let through as the trial instead.
+func TestBreakerTrialClientCancel(t *testing.T) {
+	var hang atomic.Bool
+	hang.Store(true)
+	started := make(chan struct{}, 1)
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		if hang.Load() {
+			started <- struct{}{}
+			<-r.Context().Done()
+		}
+	})
+	pool := newTestPool(t, WithBackend(backend.URL, 1), withSettings(func(cfg *Config) {
+		cfg.BreakerFailures, cfg.BreakerCooldown = 1, time.Hour
+	}))
+	b := pool.Backends()[0]
+	b.breaker.Failure()
+	b.breaker.mux.Lock()
+	b.breaker.openedAt = b.breaker.clock.Now().Add(-b.breaker.policy.Cooldown)
+	b.breaker.mux.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		if b.breaker.Ready() {
+			t.Error("breaker ready for another request during the trial")
+		}
+		cancel()
+	}()
+	serve(pool.Handler(), httptest.NewRequestWithContext(ctx, http.MethodGet, "/", nil))
+	if got := b.breaker.State(); got != "half-open" {
+		t.Fatalf("breaker = %s after a cancelled trial, want half-open", got)
+	}
+	if !b.breaker.Ready() {
+		t.Fatal("trial slot not given back after the client cancelled")
+	}
+
+	hang.Store(false)
+	if w := serve(pool.Handler(), httptest.NewRequest(http.MethodGet, "/", nil)); w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if got := b.breaker.State(); got != "closed" {
+		t.Errorf("breaker = %s after a passed trial, want closed", got)
+	}
+}
@@ -0,0 +1,100 @@
+package balancer
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// requestQueue holds the requests of a pool waiting for a backend to finish
+// a request. Waiters are woken together and race for the freed slot; the
+// losers go back to waiting.
+type requestQueue struct {
+	waiters atomic.Int64
+	mux     sync.Mutex
+	//freed is closed and replaced whenever a slot frees up
+	freed chan struct{}
+}
+
+// wait returns a channel closed once a slot frees up.
+func (q *requestQueue) wait() <-chan struct{} {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	if q.freed == nil {
+		q.freed = make(chan struct{})
+	}
+	return q.freed
+}
+
+// notify wakes the waiting requests, if any.
+func (q *requestQueue) notify() {
+	if q.waiters.Load() == 0 {
+		return
+	}
+	q.mux.Lock()
+	if q.freed != nil {
+		close(q.freed)
+		q.freed = nil
+	}
+	q.mux.Unlock()
+}
+
+// saturated reports whether a backend not tried yet for the request could
+// serve it but for its max_connections.
+func (s *ServerPool) saturated(tried triedBackends) bool {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	for _, b := range s.backends {
+		busy := b.MaxConnections > 0 && b.ActiveConnections() >= int64(b.MaxConnections)
+		if busy && !tried[b] && b.inRotation() {
+			return true
+		}
+	}
+	return false
+}
+
+// queuedPeer waits up to the queue wait of the policies for a backend to get below its
+// max_connections when they are all at it, and returns the peer picked then,
+// or nil once the wait expires or the request is cancelled.
+func (s *ServerPool) queuedPeer(r *http.Request) *Backend {
+	wait := s.policies.queueWait
+	if wait <= 0 || !s.saturated(GetTriedFromContext(r)) {
+		return nil
+	}
+	s.queue.waiters.Add(1)
+	queueDepth.WithLabelValues(s.Name).Inc()
+	clock := s.policies.clock
+	start := clock.Now()
+	defer func() {
+		s.queue.waiters.Add(-1)
+		queueDepth.WithLabelValues(s.Name).Dec()
+		s.observeQueueWait(since(clock, start))
+	}()
+	expired := clock.After(wait)
+	for {
+		freed := s.queue.wait()
+		if peer := s.GetNextPeer(r); peer != nil {
+			return peer
+		}
+		select {
+		case <-freed:
+		case <-expired:
+			queueTimeoutsTotal.WithLabelValues(s.Name).Inc()
+			return nil
+		case <-r.Context().Done():
+			return nil
+		}
+	}
+}
+
+// observeQueueWait records the longest time a request of the pool waited.
+func (s *ServerPool) observeQueueWait(d time.Duration) {
+	for {
+		longest := s.maxQueueWait.Load()
+		if int64(d) <= longest || s.maxQueueWait.CompareAndSwap(longest, int64(d)) {
+			break
+		}
+	}
+	queueMaxWait.WithLabelValues(s.Name).Set(time.Duration(s.maxQueueWait.Load()).Seconds())
+}
@@ -0,0 +1,136 @@
+package balancer
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+const testQueueWait = 5 * time.Second
+
+// newQueuePool returns a pool named name whose only backend takes one
+// request at a time, each held until release is closed, with requests
+// queued up to testQueueWait of fc.
+func newQueuePool(t *testing.T, name string, fc *fakeClock) (pool *ServerPool, release chan struct{}) {
+	t.Helper()
+	release = make(chan struct{})
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	})
+	pool, err := NewServerPool(name, WithBackends(BackendConfig{Url: backend.URL, Weight: 1, MaxConnections: 1}), withSettings(func(cfg *Config) {
+		cfg.QueueWait = testQueueWait
+	}), WithPoolClock(fc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pool, release
+}
+
+// send serves r through pool in the background, the response sent on the
+// returned channel.
+func send(pool *ServerPool, r *http.Request) <-chan *httptest.ResponseRecorder {
+	done := make(chan *httptest.ResponseRecorder, 1)
+	go func() { done <- serve(pool.Handler(), r) }()
+	return done
+}
+
+// fill sends a request taking the only slot of the backend of pool.
+func fill(t *testing.T, pool *ServerPool) <-chan *httptest.ResponseRecorder {
+	t.Helper()
+	done := send(pool, httptest.NewRequest(http.MethodGet, "/", nil))
+	b := pool.Backends()[0]
+	waitFor(t, func() bool { return b.ActiveConnections() == 1 })
+	return done
+}
+
+func TestQueueServed(t *testing.T) {
+	fc := newFakeClock()
+	pool, release := newQueuePool(t, "queue-served", fc)
+	depth, timeouts := queueDepth.WithLabelValues(pool.Name), queueTimeoutsTotal.WithLabelValues(pool.Name)
+	timedOut := testutil.ToFloat64(timeouts)
+	first := fill(t, pool)
+	queued := send(pool, httptest.NewRequest(http.MethodGet, "/", nil))
+	waitFor(t, func() bool { return testutil.ToFloat64(depth) == 1 })
+	fc.blockUntil(t, 1)
+
+	fc.Advance(time.Second)
+	close(release)
+	for _, done := range []<-chan *httptest.ResponseRecorder{first, queued} {
+		if w := <-done; w.Code != http.StatusOK {
+			t.Errorf("status = %d, want %d", w.Code, http.StatusOK)
+		}
+	}
+	if got := testutil.ToFloat64(depth); got != 0 {
+		t.Errorf("lb_queue_depth = %g, want 0", got)
+	}
+	if got := testutil.ToFloat64(queueMaxWait.WithLabelValues(pool.Name)); got != 1 {
+		t.Errorf("lb_queue_max_wait_seconds = %g, want 1", got)
+	}
+	if got := testutil.ToFloat64(timeouts); got != timedOut {
+		t.Errorf("lb_queue_timeouts_total = %g, want %g", got, timedOut)
+	}
+}
+
+func TestQueueTimeout(t *testing.T) {
+	fc := newFakeClock()
+	pool, release := newQueuePool(t, "queue-timeout", fc)
+	defer close(release)
+	depth, timeouts := queueDepth.WithLabelValues(pool.Name), queueTimeoutsTotal.WithLabelValues(pool.Name)
+	timedOut := testutil.ToFloat64(timeouts)
+	first := fill(t, pool)
+	queued := send(pool, httptest.NewRequest(http.MethodGet, "/", nil))
+	waitFor(t, func() bool { return testutil.ToFloat64(depth) == 1 })
+	fc.blockUntil(t, 1)
+
+	fc.Advance(testQueueWait - time.Millisecond)
+	select {
+	case w := <-queued:
+		t.Fatalf("request answered %d before -queue-wait", w.Code)
+	case <-time.After(10 * time.Millisecond):
+	}
+	fc.Advance(time.Millisecond)
+	if w := <-queued; w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+	if got := testutil.ToFloat64(timeouts); got != timedOut+1 {
+		t.Errorf("lb_queue_timeouts_total = %g, want %g", got, timedOut+1)
+	}
+	if got := testutil.ToFloat64(depth); got != 0 {
+		t.Errorf("lb_queue_depth = %g, want 0", got)
+	}
+	if got := testutil.ToFloat64(queueMaxWait.WithLabelValues(pool.Name)); got != testQueueWait.Seconds() {
+		t.Errorf("lb_queue_max_wait_seconds = %g, want %g", got, testQueueWait.Seconds())
+	}
+	release <- struct{}{}
+	if w := <-first; w.Code != http.StatusOK {
+		t.Errorf("first request status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestQueueCancelled(t *testing.T) {
+	fc := newFakeClock()
+	pool, release := newQueuePool(t, "queue-cancelled", fc)
+	defer close(release)
+	depth, timeouts := queueDepth.WithLabelValues(pool.Name), queueTimeoutsTotal.WithLabelValues(pool.Name)
+	timedOut := testutil.ToFloat64(timeouts)
+	fill(t, pool)
+	ctx, cancel := context.WithCancel(context.Background())
+	queued := send(pool, httptest.NewRequestWithContext(ctx, http.MethodGet, "/", nil))
+	waitFor(t, func() bool { return testutil.ToFloat64(depth) == 1 })
+
+	cancel()
+	<-queued
+	if got := testutil.ToFloat64(depth); got != 0 {
+		t.Errorf("lb_queue_depth = %g, want 0 once the client left", got)
+	}
+	if got := pool.queue.waiters.Load(); got != 0 {
+		t.Errorf("waiters = %d, want 0 once the client left", got)
+	}
+	if got := testutil.ToFloat64(timeouts); got != timedOut {
+		t.Errorf("lb_queue_timeouts_total = %g, want %g, a cancelled request not counted", got, timedOut)
+	}
+}
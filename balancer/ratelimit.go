@@ -0,0 +1,102 @@
+package balancer
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket per client IP. Each bucket holds up to Burst
+// tokens and refills at Rate tokens per second; a request takes one token.
+// Idle buckets are dropped by the StateReaper.
+type RateLimiter struct {
+	Rate  float64
+	Burst int
+
+	mux     sync.Mutex
+	buckets map[string]*tokenBucket
+	clock   Clock
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if burst <= 0 {
+		burst = int(math.Max(1, math.Ceil(rate)))
+	}
+	return &RateLimiter{
+		Rate:    rate,
+		Burst:   burst,
+		buckets: make(map[string]*tokenBucket),
+		clock:   realClock{},
+	}
+}
+
+// Allow takes a token from key's bucket. When the bucket is empty it returns
+// false and how long until the next token is available.
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	now := l.clock.Now()
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(l.Burst), last: now}
+		l.buckets[key] = b
+	} else {
+		b.tokens = math.Min(float64(l.Burst), b.tokens+now.Sub(b.last).Seconds()*l.Rate)
+		b.last = now
+	}
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / l.Rate * float64(time.Second))
+	return false, wait
+}
+
+// idle is how long a bucket takes to refill from empty.
+func (l *RateLimiter) idle() time.Duration {
+	return time.Duration(float64(l.Burst) / l.Rate * float64(time.Second))
+}
+
+// expire drops the buckets idle for ttl, 0 for the time they take to refill.
+func (l *RateLimiter) expire(ttl time.Duration) {
+	now := l.clock.Now()
+	if ttl <= 0 {
+		ttl = l.idle()
+	}
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= ttl {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// size returns the number of buckets.
+func (l *RateLimiter) size() int {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	return len(l.buckets)
+}
+
+// limitRate responds 429 with Retry-After when the client has exceeded the
+// rate limit and reports whether it did.
+func (l *LoadBalancer) limitRate(w http.ResponseWriter, r *http.Request) bool {
+	if l.rateLimiter == nil {
+		return false
+	}
+	ok, wait := l.rateLimiter.Allow(l.policies.trustedProxies.clientIP(r))
+	if ok {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, "Too many requests", http.StatusTooManyRequests)
+	return true
+}
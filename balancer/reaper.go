@@ -0,0 +1,41 @@
+package balancer
+
+import (
+	"context"
+	"time"
+)
+
+// StateReaper evicts, every Interval, the state kept per client or backend
+// that is no longer in use: rate limit buckets idle for TTL and transports
+// of backends removed from their pool. A TTL of 0 keeps buckets until they
+// would have refilled completely, as a new one behaves the same; a shorter
+// one bounds memory further but lets a client that stays quiet for TTL start
+// over with a full bucket.
+type StateReaper struct {
+	Interval time.Duration
+	TTL      time.Duration
+}
+
+// run reaps the state of limiter, nil when rate limiting is disabled, and of
+// the pools of rt every Interval until ctx is done.
+func (s StateReaper) run(ctx context.Context, limiter *RateLimiter, rt *Router) {
+	t := rt.policies.clock.NewTicker(s.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C():
+			s.reap(limiter, rt)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s StateReaper) reap(limiter *RateLimiter, rt *Router) {
+	if limiter != nil {
+		limiter.expire(s.TTL)
+	}
+	for _, pool := range rt.Pools() {
+		pool.transports.Load().prune(pool.Backends())
+	}
+}
@@ -0,0 +1,115 @@
+package balancer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// newReaperBalancer returns a balancer on fc with a pool of two backends
+// with transports of their own, both made already, and a rate limiter
+// refilling a bucket of 10 in 10s.
+func newReaperBalancer(t *testing.T, fc *fakeClock) (*LoadBalancer, *RateLimiter) {
+	t.Helper()
+	pool := newStrategyPool(t, RoundRobin, 2, withSettings(func(cfg *Config) {
+		cfg.IsolateTransports = true
+	}), WithPoolClock(fc))
+	for _, b := range pool.Backends() {
+		pool.transports.Load().get(b)
+	}
+	limiter := NewRateLimiter(1, 10)
+	limiter.clock = fc
+	return newTestBalancer(Config{}, pool), limiter
+}
+
+// checkStateEntries checks the lb_state_entries series collected for l and
+// limiter.
+func checkStateEntries(t *testing.T, l *LoadBalancer, limiter *RateLimiter, buckets, transports int) {
+	t.Helper()
+	want := fmt.Sprintf(`# HELP lb_state_entries Number of entries of state kept per client or backend, evicted by the state reaper.
+# TYPE lb_state_entries gauge
+lb_state_entries{state="rate_limit_buckets"} %d
+lb_state_entries{state="upstream_transports"} %d
+`, buckets, transports)
+	if err := testutil.CollectAndCompare(poolCollector{router: &l.router, rateLimiter: limiter}, strings.NewReader(want), "lb_state_entries"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestStateReaperReap(t *testing.T) {
+	tests := []struct {
+		name string
+		ttl  time.Duration
+		idle time.Duration //how long a bucket must be idle to be evicted
+	}{
+		{"refill time", 0, 10 * time.Second},
+		{"ttl", time.Minute, time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newFakeClock()
+			l, limiter := newReaperBalancer(t, fc)
+			reaper := StateReaper{Interval: time.Second, TTL: tt.ttl}
+			limiter.Allow("10.0.0.1")
+			limiter.Allow("10.0.0.2")
+			checkStateEntries(t, l, limiter, 2, 2)
+
+			fc.Advance(tt.idle - time.Second)
+			limiter.Allow("10.0.0.2")
+			reaper.reap(limiter, &l.router)
+			checkStateEntries(t, l, limiter, 2, 2)
+
+			//10.0.0.1 is now idle for the TTL, 10.0.0.2 only for a second
+			fc.Advance(time.Second)
+			reaper.reap(limiter, &l.router)
+			checkStateEntries(t, l, limiter, 1, 2)
+			if _, ok := limiter.buckets["10.0.0.2"]; !ok {
+				t.Error("active bucket evicted")
+			}
+		})
+	}
+}
+
+func TestStateReaperTransports(t *testing.T) {
+	fc := newFakeClock()
+	l, limiter := newReaperBalancer(t, fc)
+	pool := l.router.Pool("test")
+	kept, removed := pool.Backends()[0], pool.Backends()[1]
+	if _, ok := pool.RemoveBackend(removed.Url.String()); !ok {
+		t.Fatal("RemoveBackend did not find the backend")
+	}
+	//the transport of a removed backend lingers until the reaper runs
+	checkStateEntries(t, l, limiter, 0, 2)
+
+	StateReaper{Interval: time.Second}.reap(limiter, &l.router)
+	checkStateEntries(t, l, limiter, 0, 1)
+	transports := pool.transports.Load()
+	if _, ok := transports.backends[kept.Url.String()]; !ok {
+		t.Errorf("transport of %s evicted while in the pool", kept.Url)
+	}
+	if _, ok := transports.backends[removed.Url.String()]; ok {
+		t.Errorf("transport of the removed %s kept", removed.Url)
+	}
+}
+
+// TestStateReaperRun checks that the reaper runs every Interval of the clock
+// of the balancer.
+func TestStateReaperRun(t *testing.T) {
+	fc := newFakeClock()
+	l, limiter := newReaperBalancer(t, fc)
+	limiter.Allow("10.0.0.1")
+	pool := l.router.Pool("test")
+	pool.RemoveBackend(pool.Backends()[1].Url.String())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go StateReaper{Interval: time.Minute, TTL: time.Minute}.run(ctx, limiter, &l.router)
+	fc.blockUntil(t, 1)
+
+	fc.Advance(time.Minute)
+	waitFor(t, func() bool { return limiter.size() == 0 && pool.transports.Load().size() == 1 })
+	checkStateEntries(t, l, limiter, 0, 1)
+}
@@ -0,0 +1,54 @@
+package balancer
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+var errBackendReleased = errors.New("backend removed")
+
+// release waits for the requests in flight to b, which has just been
+// removed from its pool by a reload, discovery or the admin API, to finish
+// and then releases it. It gets no new requests in the meantime. Requests
+// still running after the drain timeout, if there is one, are cancelled;
+// replayable ones fail over to the remaining backends.
+func (b *Backend) release(source string) {
+	defer b.stop()
+	timeout, clock := b.pool.policies.drainTimeout, b.pool.policies.clock
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		deadline = clock.After(timeout)
+	}
+	tick := clock.NewTicker(100 * time.Millisecond)
+	defer tick.Stop()
+	for b.ActiveConnections() > 0 {
+		select {
+		case <-tick.C():
+		case <-deadline:
+			slog.Warn("releasing removed backend with requests in flight", "pool", b.pool.Name, "backend", b.Url.String(), "active_connections", b.ActiveConnections(), "drain_timeout", timeout, "source", source)
+			return
+		}
+	}
+	slog.Debug("released removed backend", "pool", b.pool.Name, "backend", b.Url.String(), "source", source)
+}
+
+// released reports whether b has been released after its removal.
+func (b *Backend) released() bool {
+	return b.life.Err() != nil
+}
+
+// withRelease ties the attempt r to the lifetime of b. The returned done must
+// be called once the attempt is over.
+func (b *Backend) withRelease(r *http.Request) (*http.Request, func()) {
+	//retries and failovers must not inherit the cancellation
+	ctx := context.WithValue(r.Context(), BaseContext, GetBaseContext(r))
+	ctx, cancel := context.WithCancelCause(ctx)
+	stop := context.AfterFunc(b.life, func() { cancel(errBackendReleased) })
+	return r.WithContext(ctx), func() {
+		stop()
+		cancel(nil)
+	}
+}
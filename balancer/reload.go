@@ -0,0 +1,36 @@
+package balancer
+
+import (
+	"log/slog"
+)
+
+// Reload swaps the pools with their settings, such as timeouts, response
+// headers and CORS, routes and backends for the ones cfg describes. Other
+// settings require a restart to change.
+func (l *LoadBalancer) Reload(cfg Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	added, removed, err := l.router.Configure(&cfg)
+	if err != nil {
+		return err
+	}
+	applyBackendChanges(added, removed, "reload")
+	return nil
+}
+
+// applyBackendChanges logs backends added to or removed from the pools,
+// releases the removed ones once drained and probes the new ones, which only
+// take traffic once they pass.
+func applyBackendChanges(added, removed []*Backend, source string) {
+	for _, b := range removed {
+		slog.Info("removed backend", "pool", b.pool.Name, "backend", b.Url.String(), "active_connections", b.ActiveConnections(), "source", source)
+		go b.release(source)
+	}
+	for _, b := range added {
+		slog.Info("added backend", "pool", b.pool.Name, "backend", b.Url.String(), "weight", b.Weight, "source", source)
+	}
+	for _, b := range added {
+		go b.pool.probeBackends([]*Backend{b})
+	}
+}
@@ -0,0 +1,92 @@
+package balancer
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+)
+
+// RequestIDPolicy tags every request with an ID in Header, sent to the
+// backend, echoed in the response and added to the log lines of the request.
+// An ID the client sent is kept when Trust is set, otherwise it is replaced.
+// An empty Header disables request IDs.
+type RequestIDPolicy struct {
+	Header string
+	Trust  bool
+}
+
+// maxRequestIDLength bounds the incoming IDs that are trusted, longer ones
+// are replaced so a client can't bloat every log line.
+const maxRequestIDLength = 128
+
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// withRequestID assigns r its request ID as set by p, sets it on the request
+// passed on as well as on the response, and keeps it in the context for
+// logging.
+func withRequestID(p RequestIDPolicy, h http.Handler) http.Handler {
+	if p.Header == "" {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(p.Header)
+		if !p.Trust || !validRequestID(id) {
+			id = newRequestID()
+		}
+		r.Header.Set(p.Header, id)
+		w.Header().Set(p.Header, id)
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), RequestID, id)))
+	})
+}
+
+// stripRequestID drops a request ID header the backend sent back, so it
+// doesn't add up with the one set by withRequestID.
+func stripRequestID(p RequestIDPolicy, resp *http.Response) {
+	if p.Header != "" {
+		resp.Header.Del(p.Header)
+	}
+}
+
+func GetRequestIDFromContext(r *http.Request) string {
+	id, _ := r.Context().Value(RequestID).(string)
+	return id
+}
+
+// requestIDHandler adds the request ID found in the context of a log call,
+// as made with slog.InfoContext and friends, to its record.
+type requestIDHandler struct {
+	slog.Handler
+}
+
+func (h requestIDHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id, ok := ctx.Value(RequestID).(string); ok {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDHandler) WithGroup(name string) slog.Handler {
+	return requestIDHandler{h.Handler.WithGroup(name)}
+}
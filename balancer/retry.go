@@ -0,0 +1,123 @@
+package balancer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+// BackoffStrategy selects how the delay before a retry grows.
+type BackoffStrategy string
+
+const (
+	FixedBackoff             BackoffStrategy = "fixed"
+	ExponentialBackoff       BackoffStrategy = "exponential"
+	ExponentialJitterBackoff BackoffStrategy = "exponential-jitter"
+)
+
+func ParseBackoffStrategy(s string) (BackoffStrategy, error) {
+	switch BackoffStrategy(s) {
+	case FixedBackoff, ExponentialBackoff, ExponentialJitterBackoff:
+		return BackoffStrategy(s), nil
+	}
+	return "", fmt.Errorf("unknown backoff strategy %q", s)
+}
+
+// RetryPolicy bounds how hard a single request is pushed through. A retry
+// replays the request against the same backend after a delay set by
+// BackoffStrategy, see delay; once MaxRetries is exhausted the backend is
+// marked down and the request fails over to a different backend, which
+// counts as an attempt. Only idempotent requests are replayed unless
+// NonIdempotent is set. Disabled skips the retries: a failed attempt marks
+// the backend down right away and fails over once, and the request is
+// answered with a 502 if that attempt fails too. The body of a request is
+// consumed by the attempt sending it, so one with a body is only replayed if
+// it was buffered beforehand, which takes a body of at most MaxBody bytes,
+// and within max-request-bytes.
+type RetryPolicy struct {
+	Disabled        bool
+	MaxRetries      int
+	MaxAttempts     int
+	Backoff         time.Duration
+	MaxBackoff      time.Duration
+	BackoffStrategy BackoffStrategy
+	NonIdempotent   bool
+	MaxBody         int64
+}
+
+// jitter returns a random number in [0, n) for the exponential-jitter
+// backoff, swapped by tests for a seeded source.
+var jitter = rand.Int64N
+
+// delay returns how long to wait before the retry following retries earlier
+// ones. The fixed strategy always waits Backoff; the exponential ones double
+// it with every retry up to MaxBackoff, and exponential-jitter then waits a
+// random time up to that, so clients retrying together spread out.
+func (p RetryPolicy) delay(retries int) time.Duration {
+	if p.BackoffStrategy == FixedBackoff || p.BackoffStrategy == "" {
+		return p.Backoff
+	}
+	d := p.Backoff
+	for i := 0; i < retries && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	d = min(d, p.MaxBackoff)
+	if p.BackoffStrategy == ExponentialJitterBackoff && d > 0 {
+		d = time.Duration(jitter(int64(d) + 1))
+	}
+	return d
+}
+
+// maxAttempts returns the number of failovers allowed for a request.
+func (p RetryPolicy) maxAttempts() int {
+	if p.Disabled {
+		return min(p.MaxAttempts, 1)
+	}
+	return p.MaxAttempts
+}
+
+// replayable reports whether r may be sent again after a failed attempt. A
+// POST or PATCH may already have been applied by the backend when the
+// connection broke, so replaying it risks a duplicate write. A request with
+// a body is only replayable once keepBody buffered it.
+func (p RetryPolicy) replayable(r *http.Request) bool {
+	if !p.replayableMethod(r) {
+		return false
+	}
+	return r.Body == nil || r.Body == http.NoBody || r.GetBody != nil
+}
+
+func (p RetryPolicy) replayableMethod(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return p.NonIdempotent
+}
+
+// keepBody buffers the body of r, if r could be replayed and its body is at
+// most MaxBody bytes, and sets GetBody to resend it. Larger bodies are left
+// streaming, and r is then not replayable.
+func (p RetryPolicy) keepBody(r *http.Request) {
+	if r.Body == nil || r.Body == http.NoBody || r.GetBody != nil || isUpgrade(r) || !p.replayableMethod(r) {
+		return
+	}
+	body, ok := bufferBody(r, p.MaxBody)
+	if !ok {
+		return
+	}
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+}
+
+// rewindBody restores the body of r, the request of a failed attempt that is
+// about to be replayed.
+func rewindBody(r *http.Request) {
+	if r.GetBody != nil {
+		r.Body, _ = r.GetBody()
+	}
+}
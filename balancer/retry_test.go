@@ -0,0 +1,62 @@
+package balancer
+
+import (
+	"math/rand/v2"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDelay(t *testing.T) {
+	tests := []struct {
+		strategy BackoffStrategy
+		want     []time.Duration //delays before the first retries
+	}{
+		{FixedBackoff, []time.Duration{10, 10, 10, 10, 10, 10, 10, 10}},
+		{"", []time.Duration{10, 10, 10, 10, 10, 10, 10, 10}},
+		{ExponentialBackoff, []time.Duration{10, 20, 40, 80, 100, 100, 100, 100}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.strategy), func(t *testing.T) {
+			p := RetryPolicy{Backoff: 10, MaxBackoff: 100, BackoffStrategy: tt.strategy}
+			var got []time.Duration
+			for retries := range len(tt.want) {
+				got = append(got, p.delay(retries))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("delays = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDelayJitter(t *testing.T) {
+	p := RetryPolicy{Backoff: 10, MaxBackoff: 100, BackoffStrategy: ExponentialJitterBackoff}
+	delays := func(seed uint64) []time.Duration {
+		set(t, &jitter, rand.New(rand.NewPCG(seed, seed)).Int64N)
+		var delays []time.Duration
+		for range 100 {
+			for retries := range 8 {
+				delays = append(delays, p.delay(retries))
+			}
+		}
+		return delays
+	}
+
+	got := delays(1)
+	for i, d := range got {
+		retries := i % 8
+		if ceiling := min(10<<retries, 100); d < 0 || d > time.Duration(ceiling) {
+			t.Fatalf("delay before retry %d = %v, want within [0, %v]", retries+1, d, ceiling)
+		}
+	}
+	if !slices.Equal(delays(1), got) {
+		t.Error("delays differ with the same seed")
+	}
+	if slices.Equal(delays(2), got) {
+		t.Error("delays do not differ with another seed")
+	}
+	if !slices.Contains(got, 0) || !slices.Contains(got, 100) {
+		t.Error("delays never reach the bounds of the jitter")
+	}
+}
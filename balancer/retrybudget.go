@@ -0,0 +1,111 @@
+package balancer
+
+import (
+	"sync"
+	"time"
+)
+
+// RetryBudget caps retries against the same backend at Ratio of the
+// requests received over the last Window, but always allows MinRetries per
+// window so that a quiet load balancer can still retry. When failures spike
+// the budget runs out and failed requests get a 502 instead of multiplying
+// the load on the remaining backends. Failovers are not charged: the backend
+// that failed is marked down either way, and MaxAttempts bounds them.
+type RetryBudget struct {
+	Ratio      float64
+	Window     time.Duration
+	MinRetries int
+
+	mux     sync.Mutex
+	buckets [10]budgetBucket
+	clock   Clock
+}
+
+// budgetBucket counts one tenth of the window.
+type budgetBucket struct {
+	slot     int64
+	requests int
+	retries  int
+}
+
+func NewRetryBudget(ratio float64, window time.Duration) *RetryBudget {
+	return &RetryBudget{Ratio: ratio, Window: window, MinRetries: 10, clock: realClock{}}
+}
+
+// bucket returns the bucket of now, resetting it if it is stale. The caller
+// must hold rb.mux.
+func (rb *RetryBudget) bucket(now time.Time) (*budgetBucket, int64) {
+	slot := now.UnixNano() / int64(rb.Window/time.Duration(len(rb.buckets)))
+	b := &rb.buckets[slot%int64(len(rb.buckets))]
+	if b.slot != slot {
+		*b = budgetBucket{slot: slot}
+	}
+	return b, slot
+}
+
+// totals sums the buckets within the window. The caller must hold rb.mux.
+func (rb *RetryBudget) totals(slot int64) (requests, retries int) {
+	for _, b := range rb.buckets {
+		if b.slot > slot-int64(len(rb.buckets)) {
+			requests += b.requests
+			retries += b.retries
+		}
+	}
+	return requests, retries
+}
+
+// limit returns how many retries the window allows. The caller must hold
+// rb.mux.
+func (rb *RetryBudget) limit(requests int) int {
+	return max(rb.MinRetries, int(rb.Ratio*float64(requests)))
+}
+
+// Request counts a new incoming request.
+func (rb *RetryBudget) Request() {
+	if rb == nil {
+		return
+	}
+	rb.mux.Lock()
+	defer rb.mux.Unlock()
+	b, _ := rb.bucket(rb.clock.Now())
+	b.requests++
+}
+
+// Allow takes one retry from the budget and reports whether there was one.
+func (rb *RetryBudget) Allow() bool {
+	if rb == nil {
+		return true
+	}
+	rb.mux.Lock()
+	defer rb.mux.Unlock()
+	b, slot := rb.bucket(rb.clock.Now())
+	requests, retries := rb.totals(slot)
+	if retries >= rb.limit(requests) {
+		return false
+	}
+	b.retries++
+	return true
+}
+
+type RetryBudgetStats struct {
+	Ratio    float64 `json:"ratio"`
+	Window   string  `json:"window"`
+	Requests int     `json:"requests"`
+	Retries  int     `json:"retries"`
+	Limit    int     `json:"limit"`
+}
+
+// Stats returns the usage of the current window.
+func (rb *RetryBudget) Stats() RetryBudgetStats {
+	rb.mux.Lock()
+	defer rb.mux.Unlock()
+	_, slot := rb.bucket(rb.clock.Now())
+	requests, retries := rb.totals(slot)
+	return RetryBudgetStats{
+		Ratio:    rb.Ratio,
+		Window:   rb.Window.String(),
+		Requests: requests,
+		Retries:  retries,
+		Limit:    rb.limit(requests),
+	}
+}
@@ -0,0 +1,80 @@
+package balancer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryBudgetAllow(t *testing.T) {
+	rb := NewRetryBudget(0.2, 10*time.Second)
+	for i := 0; i < 100; i++ {
+		rb.Request()
+	}
+	allowed := 0
+	for i := 0; i < 50; i++ {
+		if rb.Allow() {
+			allowed++
+		}
+	}
+	if allowed != 20 {
+		t.Fatalf("allowed %d retries for 100 requests at 0.2, want 20", allowed)
+	}
+
+	quiet := NewRetryBudget(0.2, 10*time.Second)
+	quiet.Request()
+	allowed = 0
+	for i := 0; i < 50; i++ {
+		if quiet.Allow() {
+			allowed++
+		}
+	}
+	if allowed != quiet.MinRetries {
+		t.Fatalf("allowed %d retries for a single request, want MinRetries %d", allowed, quiet.MinRetries)
+	}
+}
+
+func TestRetryBudgetExhausted(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxRetries int
+		status     int
+		failed     int64
+		served     int64
+		markedDown bool
+	}{
+		//the retry is refused by the budget, the backend keeps its retries
+		{"retry refused", 3, http.StatusBadGateway, 1, 0, false},
+		//failovers are not charged, the backend is marked down all the same
+		{"failover allowed", 0, http.StatusOK, 1, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var failed, served atomic.Int64
+			bad := newTestBackend(t, hangUp(&failed))
+			good := newTestBackend(t, echoBody(&served))
+			pool := newTestPool(t, WithBackend(bad.URL, 1), WithBackend(good.URL, 1), withSettings(func(cfg *Config) {
+				cfg.MaxRetries = tt.maxRetries
+			}))
+			budget := NewRetryBudget(0, 10*time.Second)
+			budget.MinRetries = 0
+			pool.policies.retryBudget = budget
+
+			w := serve(pool.Handler(), httptest.NewRequest(http.MethodGet, "/", nil))
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+			if failed.Load() != tt.failed || served.Load() != tt.served {
+				t.Errorf("backends got %d and %d requests, want %d and %d", failed.Load(), served.Load(), tt.failed, tt.served)
+			}
+			if down := !pool.Backends()[0].IsAlive(); down != tt.markedDown {
+				t.Errorf("failing backend marked down = %v, want %v", down, tt.markedDown)
+			}
+			if used := budget.Stats().Retries; used != 0 {
+				t.Errorf("budget spent %d retries, want none", used)
+			}
+		})
+	}
+}
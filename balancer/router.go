@@ -0,0 +1,210 @@
+package balancer
+
+import (
+	"net"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const defaultPool = "default"
+
+// Route matches requests by Host and path prefix, either may be empty to
+// match any. Host is lowercase without a port and may start with "*." to
+// match every subdomain. Prefix matches whole path segments, so /api matches
+// /api and /api/users but not /apiary, unless it ends with a slash.
+// StripPrefix removes Prefix from the path sent to the backends.
+type Route struct {
+	Host        string
+	Prefix      string
+	StripPrefix bool
+	Pool        *ServerPool
+}
+
+func (route *Route) matches(host, path string) bool {
+	switch {
+	case route.Host == "":
+	case strings.HasPrefix(route.Host, "*."):
+		if !strings.HasSuffix(host, route.Host[1:]) {
+			return false
+		}
+	case route.Host != host:
+		return false
+	}
+	return hasPathPrefix(path, route.Prefix)
+}
+
+// hasPathPrefix reports whether path starts with the path segments of
+// prefix.
+func hasPathPrefix(path, prefix string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	return len(path) == len(prefix) || strings.HasSuffix(prefix, "/") || path[len(prefix)] == '/'
+}
+
+// precedes orders routes from most to least specific: exact hosts before
+// wildcards before any host, then longer wildcards and longer prefixes first.
+func (route *Route) precedes(other *Route) bool {
+	rank := func(r *Route) int {
+		switch {
+		case r.Host == "":
+			return 0
+		case strings.HasPrefix(r.Host, "*."):
+			return 1
+		}
+		return 2
+	}
+	if a, b := rank(route), rank(other); a != b {
+		return a > b
+	}
+	if len(route.Host) != len(other.Host) {
+		return len(route.Host) > len(other.Host)
+	}
+	return len(route.Prefix) > len(other.Prefix)
+}
+
+// requestHost returns the lowercase Host of r without its port.
+func requestHost(r *http.Request) string {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// Router maps the Host and path of requests to named server pools. The most
+// specific matching route wins and unmatched requests go to the default pool.
+type Router struct {
+	mux    sync.RWMutex
+	pools  map[string]*ServerPool
+	routes []Route
+	//cfg is the last applied config, reapplied when discovery finds changes
+	cfg *Config
+	//policies are handed to the pools created by Configure
+	policies *policies
+	//discovery adds its backends to the default pool, nil when disabled
+	discovery *Discovery
+}
+
+// Match returns the route that r matches, which is the default pool without
+// a prefix if none does. Its Pool is nil when there is no default pool.
+func (rt *Router) Match(r *http.Request) Route {
+	rt.mux.RLock()
+	defer rt.mux.RUnlock()
+	//routes are sorted most specific first
+	host := requestHost(r)
+	for i := range rt.routes {
+		if rt.routes[i].matches(host, r.URL.Path) {
+			return rt.routes[i]
+		}
+	}
+	return Route{Pool: rt.pools[defaultPool]}
+}
+
+func (rt *Router) Pool(name string) *ServerPool {
+	rt.mux.RLock()
+	defer rt.mux.RUnlock()
+	return rt.pools[name]
+}
+
+// Pools returns every pool, ordered by name.
+func (rt *Router) Pools() []*ServerPool {
+	rt.mux.RLock()
+	defer rt.mux.RUnlock()
+	pools := make([]*ServerPool, 0, len(rt.pools))
+	for _, p := range rt.pools {
+		pools = append(pools, p)
+	}
+	sort.Slice(pools, func(i, j int) bool { return pools[i].Name < pools[j].Name })
+	return pools
+}
+
+// Config returns the last applied config.
+func (rt *Router) Config() Config {
+	rt.mux.RLock()
+	defer rt.mux.RUnlock()
+	return *rt.cfg
+}
+
+// ActiveConnections returns the number of requests in flight across all pools.
+func (rt *Router) ActiveConnections() int64 {
+	var total int64
+	for _, p := range rt.Pools() {
+		total += p.ActiveConnections()
+	}
+	return total
+}
+
+// Configure applies the pools and routes of a validated config. Pools that
+// already exist keep their backends' state; see ServerPool.SetBackends.
+// Backends of pools that are no longer configured are reported as removed.
+func (rt *Router) Configure(cfg *Config) (added, removed []*Backend, err error) {
+	rt.mux.Lock()
+	defer rt.mux.Unlock()
+	added, removed, err = rt.configure(cfg)
+	if err == nil {
+		rt.cfg = cfg
+	}
+	return added, removed, err
+}
+
+// Reconfigure reapplies the current config, picking up discovered backends.
+func (rt *Router) Reconfigure() (added, removed []*Backend, err error) {
+	rt.mux.Lock()
+	defer rt.mux.Unlock()
+	return rt.configure(rt.cfg)
+}
+
+// configure does the work of Configure. The caller must hold rt.mux.
+func (rt *Router) configure(cfg *Config) (added, removed []*Backend, err error) {
+	poolConfigs := cfg.PoolConfigs()
+	if rt.discovery != nil {
+		def := poolConfigs[defaultPool]
+		def.Backends = append(slices.Clip(def.Backends), rt.discovery.Backends()...)
+		poolConfigs[defaultPool] = def
+	}
+
+	pools := make(map[string]*ServerPool, len(poolConfigs))
+	backends := make(map[string][]*Backend, len(poolConfigs))
+	for name, pc := range poolConfigs {
+		pool, ok := rt.pools[name]
+		if !ok {
+			pool = &ServerPool{Name: name, lastUp: -1, policies: rt.policies}
+		}
+		for _, bc := range pc.Backends {
+			b, err := newBackend(bc, pool)
+			if err != nil {
+				return nil, nil, err
+			}
+			backends[name] = append(backends[name], b)
+		}
+		pools[name] = pool
+	}
+
+	for name, pc := range poolConfigs {
+		pool := pools[name]
+		pool.Configure(pc)
+		a, r := pool.SetBackends(backends[name])
+		added = append(added, a...)
+		removed = append(removed, r...)
+	}
+	for name, pool := range rt.pools {
+		if _, ok := pools[name]; !ok {
+			_, r := pool.SetBackends(nil)
+			removed = append(removed, r...)
+		}
+	}
+
+	routes := make([]Route, 0, len(cfg.Routes))
+	for _, rc := range cfg.Routes {
+		routes = append(routes, Route{Host: strings.ToLower(rc.Host), Prefix: rc.Prefix, StripPrefix: rc.StripPrefix, Pool: pools[rc.Pool]})
+	}
+	sort.SliceStable(routes, func(i, j int) bool { return routes[i].precedes(&routes[j]) })
+
+	rt.pools = pools
+	rt.routes = routes
+	return added, removed, nil
+}
@@ -0,0 +1,56 @@
+package balancer
+
+import (
+	"net/http/httptest"
+	"sort"
+	"testing"
+)
+
+func TestRouterMatch(t *testing.T) {
+	pools := map[string]*ServerPool{}
+	for _, name := range []string{defaultPool, "api", "api-v2", "static", "admin", "tenants", "root"} {
+		pools[name] = &ServerPool{Name: name}
+	}
+	routes := []Route{
+		{Prefix: "/api", Pool: pools["api"]},
+		{Prefix: "/api/v2", Pool: pools["api-v2"]},
+		{Prefix: "/static/", Pool: pools["static"]},
+		{Host: "admin.example.com", Pool: pools["admin"]},
+		{Host: "*.tenants.example.com", Prefix: "/api", Pool: pools["tenants"]},
+		{Host: "root.example.com", Prefix: "/", Pool: pools["root"]},
+	}
+	sort.SliceStable(routes, func(i, j int) bool { return routes[i].precedes(&routes[j]) })
+	rt := &Router{pools: pools, routes: routes}
+
+	tests := []struct {
+		host, path string
+		want       string
+	}{
+		{"lb", "/api", "api"},
+		{"lb", "/api/", "api"},
+		{"lb", "/api/users", "api"},
+		{"lb", "/apiary", defaultPool},
+		{"lb", "/apix/users", defaultPool},
+		{"lb", "/ap", defaultPool},
+		{"lb", "/api/v2", "api-v2"},
+		{"lb", "/api/v2/users", "api-v2"},
+		{"lb", "/api/v20", "api"},
+		{"lb", "/static/app.js", "static"},
+		{"lb", "/static", defaultPool},
+		{"lb", "/staticfiles", defaultPool},
+		{"admin.example.com", "/api/users", "admin"},
+		{"ADMIN.example.com:8080", "/", "admin"},
+		{"a.tenants.example.com", "/api/users", "tenants"},
+		{"a.tenants.example.com", "/apiary", defaultPool},
+		{"tenants.example.com", "/api", "api"},
+		{"root.example.com", "/anything", "root"},
+		{"lb", "/", defaultPool},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", tt.path, nil)
+		r.Host = tt.host
+		if got := rt.Match(r).Pool.Name; got != tt.want {
+			t.Errorf("Match(%s%s) = %s, want %s", tt.host, tt.path, got, tt.want)
+		}
+	}
+}
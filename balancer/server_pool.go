@@ -0,0 +1,406 @@
+package balancer
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+)
+
+type ServerPool struct {
+	Name     string
+	backends []*Backend
+	byUrl    map[string]*Backend
+	strategy Strategy
+	health   HealthCheck
+	affinity Affinity
+	timeouts UpstreamTimeouts
+	//responseHeaders rewrite the responses relayed from the backends
+	responseHeaders HeaderRules
+	hostRewrite     HostRewrite
+	cors            CORSPolicy
+	ring            *HashRing
+	//transports is swapped by SetTimeouts when the transport timeouts change
+	transports atomic.Pointer[upstreamTransports]
+	//lastUp is the alive count of the last health check, -1 before the first
+	lastUp int
+	//queue holds the requests waiting for a backend below max_connections
+	queue        requestQueue
+	maxQueueWait atomic.Int64
+	//policies are those of the LoadBalancer the pool belongs to, or its own
+	//when built by NewServerPool
+	policies *policies
+	//mux guards the backend set and the per-backend settings that reloads
+	//can change; wrrMux guards the smooth weighted round-robin state.
+	mux    sync.RWMutex
+	wrrMux sync.Mutex
+}
+
+func (s *ServerPool) SetStrategy(strategy Strategy) {
+	s.mux.Lock()
+	s.strategy = strategy
+	s.mux.Unlock()
+}
+
+func (s *ServerPool) SetHealthCheck(health HealthCheck) {
+	s.mux.Lock()
+	s.health = health
+	s.mux.Unlock()
+}
+
+// Configure applies the pool-wide settings of a validated pool config.
+func (s *ServerPool) Configure(pc PoolConfig) {
+	strategy, _ := ParseStrategy(pc.Strategy)
+	s.SetStrategy(strategy)
+	s.SetHealthCheck(pc.HealthCheck())
+	s.SetAffinity(Affinity{Cookie: pc.StickyCookie, TTL: pc.StickyTTL})
+	s.SetTimeouts(pc.UpstreamTimeouts())
+	s.SetResponseHeaders(pc.ResponseHeaders)
+	s.SetCORS(pc.CORS)
+	s.SetHostRewrite(HostRewrite{Preserve: pc.PreserveHost == nil || *pc.PreserveHost, Override: pc.HostOverride})
+}
+
+// SetTimeouts applies t to the requests of the pool. Transports are only
+// rebuilt when the dial or response header timeout changes, dropping the
+// idle connections of the old ones.
+func (s *ServerPool) SetTimeouts(t UpstreamTimeouts) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	old := s.transports.Load()
+	if old == nil || t.Dial != s.timeouts.Dial || t.ResponseHeader != s.timeouts.ResponseHeader {
+		s.transports.Store(newUpstreamTransports(t, s.policies.keepAlive))
+		if old != nil {
+			old.closeIdle()
+		}
+	}
+	s.timeouts = t
+}
+
+func (s *ServerPool) Timeouts() UpstreamTimeouts {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.timeouts
+}
+
+func (s *ServerPool) AddBackend(backend *Backend) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	key := backend.Url.String()
+	if _, ok := s.byUrl[key]; ok {
+		return fmt.Errorf("backend %s already exists", backend.Url)
+	}
+	if s.byUrl == nil {
+		s.byUrl = make(map[string]*Backend)
+	}
+	initBackend(backend)
+	next := make([]*Backend, len(s.backends), len(s.backends)+1)
+	copy(next, s.backends)
+	s.backends = append(next, backend)
+	s.byUrl[key] = backend
+	s.ring = newHashRing(s.backends, s.policies.hash.VNodes)
+	return nil
+}
+
+// RemoveBackend takes the backend with the given URL out of rotation
+// immediately. Requests already in flight to it are left to finish.
+func (s *ServerPool) RemoveBackend(backendUrl string) (*Backend, bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	b, ok := s.byUrl[backendUrl]
+	if !ok {
+		return nil, false
+	}
+	//copy so snapshots handed out by Backends stay untouched
+	next := make([]*Backend, 0, len(s.backends)-1)
+	for _, other := range s.backends {
+		if other != b {
+			next = append(next, other)
+		}
+	}
+	s.backends = next
+	delete(s.byUrl, backendUrl)
+	s.ring = newHashRing(s.backends, s.policies.hash.VNodes)
+	b.metrics.delete()
+	return b, true
+}
+
+func initBackend(backend *Backend) {
+	if backend.Weight <= 0 {
+		backend.Weight = 1
+	}
+	backend.effectiveWeight = backend.Weight
+	backend.id = backendID(backend)
+	backend.metrics = newBackendMetrics(backend.pool.Name, backendHost(backend.Url))
+}
+
+// SetBackends replaces the pool's backends with the given set. Backends whose
+// URL is already in the pool keep their state and only pick up the new
+// settings; new ones are added as they are, normally down until a health
+// check passes. Requests already in flight to a removed backend are left to
+// finish.
+func (s *ServerPool) SetBackends(backends []*Backend) (added, removed []*Backend) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	next := make([]*Backend, 0, len(backends))
+	byUrl := make(map[string]*Backend, len(backends))
+	for _, b := range backends {
+		key := b.Url.String()
+		if _, dup := byUrl[key]; dup {
+			continue
+		}
+		if old, ok := s.byUrl[key]; ok {
+			byUrl[key] = old
+			if b.Weight <= 0 {
+				b.Weight = 1
+			}
+			old.Weight = b.Weight
+			old.MaxConnections = b.MaxConnections
+			old.Priority = b.Priority
+			old.mux.Lock()
+			old.HealthPath = b.HealthPath
+			old.HealthStatus = b.HealthStatus
+			old.HealthUrl = b.HealthUrl
+			old.PreserveHost = b.PreserveHost
+			old.HostOverride = b.HostOverride
+			old.tlsConfig.Store(b.tlsConfig.Load())
+			old.mux.Unlock()
+			s.wrrMux.Lock()
+			if old.effectiveWeight > old.Weight {
+				old.effectiveWeight = old.Weight
+			}
+			s.wrrMux.Unlock()
+			next = append(next, old)
+			continue
+		}
+		initBackend(b)
+		byUrl[key] = b
+		next = append(next, b)
+		added = append(added, b)
+	}
+	for _, b := range s.backends {
+		if _, ok := byUrl[b.Url.String()]; !ok {
+			b.metrics.delete()
+			removed = append(removed, b)
+		}
+	}
+	s.backends = next
+	s.byUrl = byUrl
+	s.ring = newHashRing(s.backends, s.policies.hash.VNodes)
+	return added, removed
+}
+
+// Backend returns the backend with the given URL, or nil.
+func (s *ServerPool) Backend(backendUrl string) *Backend {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.byUrl[backendUrl]
+}
+
+// Backends returns a snapshot of the backends currently in the pool. The slice
+// is never modified in place, writers swap in a new one.
+func (s *ServerPool) Backends() []*Backend {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.backends
+}
+
+// return next active peer to take a connection, or nil when none is available;
+// backends that have already been tried for r are skipped, and so are those
+// of a lower tier than the first one with a backend left in rotation
+func (s *ServerPool) GetNextPeer(r *http.Request) *Backend {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	if len(s.backends) == 0 {
+		return nil
+	}
+	var peer *Backend
+	tried := s.tierFilter(GetTriedFromContext(r))
+	switch s.strategy {
+	case LeastConnections:
+		peer = s.nextLeastConnections(tried)
+	case WeightedLeastConnections:
+		peer = s.nextWeightedLeastConnections(tried)
+	case IPHash:
+		peer = s.nextIPHash(r, tried)
+	case LeastTime:
+		peer = s.nextLeastTime(tried)
+	case ConsistentHash:
+		peer = s.nextConsistentHash(r, tried)
+	case P2C:
+		peer = s.nextP2C(tried)
+	default:
+		s.wrrMux.Lock()
+		peer = s.nextRoundRobin(tried)
+		s.wrrMux.Unlock()
+	}
+	if peer != nil {
+		peer.breaker.Begin()
+	}
+	return peer
+}
+
+// ActiveConnections returns the number of requests in flight across all backends.
+func (s *ServerPool) ActiveConnections() int64 {
+	var total int64
+	for _, b := range s.Backends() {
+		total += b.ActiveConnections()
+	}
+	return total
+}
+
+func (s *ServerPool) MarkBackendStatus(backendUrl *url.URL, alive bool) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	if b, ok := s.byUrl[backendUrl.String()]; ok {
+		wasAlive := b.IsAlive()
+		b.SetAlive(alive)
+		if !alive {
+			if wasAlive {
+				b.failWaiting()
+			}
+			b.metrics.markedDown.Inc()
+			//a failed peer re-earns its weight gradually once it is back
+			s.wrrMux.Lock()
+			b.effectiveWeight = 0
+			s.wrrMux.Unlock()
+		}
+	}
+}
+
+// HeadthCheck probes every backend and logs how many are up, warning when
+// none are left. Unless forced, backends whose probes are backed off while
+// they stay down are skipped until they are due.
+func (s *ServerPool) HeadthCheck(force bool) {
+	backends := s.Backends()
+	due := backends
+	if !force {
+		due = s.policies.healthBackoff.due(backends, s.policies.clock.Now())
+	}
+	up := s.checkBackends(due)
+	if len(due) < len(backends) {
+		up = 0
+		for _, b := range backends {
+			if b.IsAlive() {
+				up++
+			}
+		}
+	}
+	slog.Info(fmt.Sprintf("health: %d/%d backends up", up, len(backends)), "pool", s.Name, "up", up, "total", len(backends))
+
+	s.mux.Lock()
+	wasUp := s.lastUp
+	s.lastUp = up
+	s.mux.Unlock()
+	if up == 0 && wasUp != 0 {
+		slog.Warn("no backends up", "pool", s.Name, "total", len(backends))
+	}
+}
+
+// checkBackends probes backends and returns how many are alive afterwards.
+func (s *ServerPool) checkBackends(backends []*Backend) int {
+	s.mux.RLock()
+	health := s.health
+	s.mux.RUnlock()
+	var up int64
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, max(s.policies.healthConcurrency, 1))
+	for _, b := range backends {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			status := "up"
+			start := s.policies.clock.Now()
+			err := health.probe(b)
+			alive := health.recordProbe(b, err, start)
+			s.policies.healthBackoff.record(b, alive, start)
+			if err == nil {
+				b.recordPassiveSuccess()
+			}
+			if alive {
+				atomic.AddInt64(&up, 1)
+			} else {
+				status = "down"
+			}
+			slog.Debug("backend status", "pool", s.Name, "backend", b.Url.String(), "status", status)
+		}()
+	}
+	wg.Wait()
+	return int(up)
+}
+
+// serve answers CORS preflights and proxies r within the total timeout of
+// the pool.
+func (s *ServerPool) serve(w http.ResponseWriter, r *http.Request) {
+	if s.handleCORS(w, r) {
+		return
+	}
+	r, cancel := withTotalTimeout(r, s.Timeouts().Total)
+	defer cancel()
+	s.ServeHTTP(w, r)
+}
+
+// ServeHTTP proxies r to the next peer of the pool. It is re-entered by the
+// proxy ErrorHandler to fail over to another backend.
+func (s *ServerPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	attempts := GetAttemptsFromContext(r)
+	if attempts == 0 {
+		requestsTotal.Inc()
+		s.policies.retryBudget.Request()
+		r = withTried(r)
+		s.policies.retry.keepBody(r)
+	}
+	if attempts > s.policies.retry.maxAttempts() {
+		slog.WarnContext(r.Context(), "max attempts reached, terminating", "pool", s.Name, "client", r.RemoteAddr, "path", r.URL.Path, "attempt", attempts)
+		s.policies.serveBadGateway(w)
+		return
+	}
+	if attempts == 0 && !s.applyWeightOverride(w, r) {
+		return
+	}
+	peer, ok := s.overridePeer(w, r)
+	if !ok {
+		return
+	}
+	if peer == nil {
+		peer = s.pinnedPeer(r)
+	}
+	if peer == nil {
+		peer = s.GetNextPeer(r)
+		if peer == nil {
+			peer = s.queuedPeer(r)
+		}
+		if peer != nil {
+			s.pin(w, peer)
+		}
+	}
+	if peer != nil {
+		GetTriedFromContext(r).add(peer)
+		setAccessBackend(r, peer)
+		traceAttempt(r, peer, attempts)
+		slog.DebugContext(r.Context(), "proxying request", "pool", s.Name, "backend", backendHost(peer.Url), "path", r.URL.Path, "attempt", attempts)
+		start := s.policies.clock.Now()
+		peer.ServeHTTP(w, r)
+		//a failed over request is charged to the backend that failed it too
+		latency := since(s.policies.clock, start)
+		if !isUpgrade(r) {
+			peer.latency.Observe(latency)
+			peer.outlier.observeLatency(s.policies.outlierDetection, latency)
+		}
+		slog.DebugContext(r.Context(), "request completed", "pool", s.Name, "backend", backendHost(peer.Url), "path", r.URL.Path, "attempt", attempts, "latency", latency)
+		return
+	}
+	if attempts > 0 {
+		//the backends left have all failed this request already
+		slog.WarnContext(r.Context(), "every backend failed, terminating", "pool", s.Name, "client", r.RemoteAddr, "path", r.URL.Path, "attempt", attempts)
+		s.policies.serveBadGateway(w)
+		return
+	}
+	s.policies.serveUnavailable(w)
+}
@@ -0,0 +1,147 @@
+package balancer
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// newTestPool builds a standalone pool with opts, failing t if it is invalid.
+func newTestPool(t *testing.T, opts ...PoolOption) *ServerPool {
+	t.Helper()
+	pool, err := NewServerPool("test", opts...)
+	if err != nil {
+		t.Fatalf("NewServerPool: %v", err)
+	}
+	return pool
+}
+
+// withSettings edits the settings of the Config the policies of the pool are
+// taken from.
+func withSettings(edit func(cfg *Config)) PoolOption {
+	return func(o *poolOptions) {
+		edit(&o.cfg)
+	}
+}
+
+// newTestBackend starts a backend serving h, closed at the end of the test.
+func newTestBackend(t *testing.T, h http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// set sets the package variable at p to v for the rest of the test.
+func set[T any](t testing.TB, p *T, v T) {
+	t.Helper()
+	old := *p
+	*p = v
+	t.Cleanup(func() { *p = old })
+}
+
+// serve sends r through h and returns the recorded response.
+func serve(h http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestGetNextPeerEmptyPool(t *testing.T) {
+	strategies := []Strategy{RoundRobin, LeastConnections, WeightedLeastConnections, IPHash, LeastTime, ConsistentHash, P2C}
+	for _, strategy := range strategies {
+		t.Run(string(strategy), func(t *testing.T) {
+			pool := &ServerPool{Name: "empty", strategy: strategy}
+			r := withTried(httptest.NewRequest(http.MethodGet, "/", nil))
+			if peer := pool.GetNextPeer(r); peer != nil {
+				t.Fatalf("GetNextPeer = %s, want nil", peer.Url)
+			}
+		})
+	}
+}
+
+func TestServeEmptiedPool(t *testing.T) {
+	pool := newTestPool(t, WithBackend("http://127.0.0.1:1", 1))
+	if _, ok := pool.RemoveBackend("http://127.0.0.1:1"); !ok {
+		t.Fatal("RemoveBackend did not find the backend")
+	}
+	w := serve(pool.Handler(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+}
+
+// TestConcurrentBackendChanges selects backends while others are added and
+// removed, to be run with -race.
+func TestConcurrentBackendChanges(t *testing.T) {
+	strategies := []Strategy{RoundRobin, LeastConnections, WeightedLeastConnections, IPHash, LeastTime, ConsistentHash, P2C}
+	for _, strategy := range strategies {
+		t.Run(string(strategy), func(t *testing.T) {
+			pool := newStrategyPool(t, strategy, 2)
+			var wg sync.WaitGroup
+			stop := make(chan struct{})
+			for i := 0; i < 4; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for {
+						select {
+						case <-stop:
+							return
+						default:
+						}
+						r := withTried(httptest.NewRequest(http.MethodGet, "/", nil))
+						if peer := pool.GetNextPeer(r); peer == nil {
+							t.Error("GetNextPeer returned nil with two backends always in the pool")
+							return
+						}
+						pool.Stats()
+					}
+				}()
+			}
+			for i := 0; i < 200; i++ {
+				u := fmt.Sprintf("http://10.0.1.%d:80", i%50)
+				b, err := newBackend(BackendConfig{Url: u, Weight: 1}, pool)
+				if err != nil {
+					t.Fatal(err)
+				}
+				b.SetAlive(true)
+				if pool.AddBackend(b) == nil {
+					pool.RemoveBackend(u)
+				}
+				if i%20 == 0 {
+					pool.SetBackends(pool.Backends())
+				}
+			}
+			close(stop)
+			wg.Wait()
+		})
+	}
+}
+
+func TestGetNextPeerSkipsTried(t *testing.T) {
+	strategies := []Strategy{RoundRobin, LeastConnections, WeightedLeastConnections, IPHash, LeastTime, ConsistentHash, P2C}
+	for _, strategy := range strategies {
+		t.Run(string(strategy), func(t *testing.T) {
+			pool := newStrategyPool(t, strategy, 2)
+			for range 10 {
+				r := withTried(httptest.NewRequest(http.MethodGet, "/", nil))
+				first := pool.GetNextPeer(r)
+				if first == nil {
+					t.Fatal("GetNextPeer returned nil before any backend was tried")
+				}
+				GetTriedFromContext(r).add(first)
+				second := pool.GetNextPeer(r)
+				if second == nil || second == first {
+					t.Fatalf("GetNextPeer picked %v after %s was tried, want the other backend", second, first.Url)
+				}
+				GetTriedFromContext(r).add(second)
+				if peer := pool.GetNextPeer(r); peer != nil {
+					t.Fatalf("GetNextPeer picked %s with every backend tried, want nil", peer.Url)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,20 @@
+package balancer
+
+import "time"
+
+// slowStartPercent returns the share of its weight, from 1 to 100, that b
+// receives in round-robin selection at now while it ramps up after coming up.
+func (b *Backend) slowStartPercent(now time.Time) int {
+	slowStart := b.pool.policies.slowStart
+	if slowStart <= 0 {
+		return 100
+	}
+	b.mux.RLock()
+	since := b.aliveSince
+	b.mux.RUnlock()
+	elapsed := now.Sub(since)
+	if elapsed >= slowStart {
+		return 100
+	}
+	return max(1, int(100*elapsed/slowStart))
+}
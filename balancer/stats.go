@@ -0,0 +1,144 @@
+package balancer
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+type BackendStats struct {
+	Pool              string       `json:"pool"`
+	Url               string       `json:"url"`
+	Alive             bool         `json:"alive"`
+	Draining          bool         `json:"draining"`
+	Weight            int          `json:"weight"`
+	Priority          int          `json:"priority"`
+	WeightOverride    int          `json:"weight_override,omitempty"`
+	EffectiveWeight   int          `json:"effective_weight"`
+	CurrentWeight     int          `json:"current_weight"`
+	ActiveConnections int64        `json:"active_connections"`
+	OpenConnections   int64        `json:"open_connections"`
+	IdleConnections   int64        `json:"idle_connections"`
+	MaxConnections    int          `json:"max_connections"`
+	Breaker           string       `json:"breaker"`
+	HealthPassed      int          `json:"health_passed"`
+	HealthFailed      int          `json:"health_failed"`
+	LastProbeAt       *time.Time   `json:"last_probe_at,omitempty"`
+	LastProbeMs       float64      `json:"last_probe_ms"`
+	LastError         string       `json:"last_error,omitempty"`
+	LastErrorAt       *time.Time   `json:"last_error_at,omitempty"`
+	StateChangedAt    *time.Time   `json:"state_changed_at,omitempty"`
+	Latency           LatencyStats `json:"latency"`
+	WeightPercent     int          `json:"weight_percent"`
+	ErrorRate         float64      `json:"error_rate"`
+	Ejected           bool         `json:"ejected"`
+	EjectedUntil      *time.Time   `json:"ejected_until,omitempty"`
+	Ejections         int          `json:"ejections"`
+	RingShare         float64      `json:"ring_share,omitempty"`
+}
+
+// LatencyStats reports request durations in milliseconds.
+type LatencyStats struct {
+	Average float64 `json:"avg_ms"`
+	P50     float64 `json:"p50_ms"`
+	P95     float64 `json:"p95_ms"`
+	P99     float64 `json:"p99_ms"`
+}
+
+func newLatencyStats(t *LatencyTracker) LatencyStats {
+	p := t.Percentiles(50, 95, 99)
+	return LatencyStats{
+		Average: milliseconds(t.Average()),
+		P50:     milliseconds(p[0]),
+		P95:     milliseconds(p[1]),
+		P99:     milliseconds(p[2]),
+	}
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// Stats returns a snapshot of every backend's state.
+func (s *ServerPool) Stats() []BackendStats {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	stats := make([]BackendStats, 0, len(s.backends))
+	now := s.policies.clock.Now()
+	for _, b := range s.backends {
+		passed, failed := b.HealthCounts()
+		ejected, until, ejections := b.Ejection()
+		st := BackendStats{
+			Pool:              s.Name,
+			Url:               b.Url.String(),
+			Alive:             b.IsAlive(),
+			Draining:          b.IsDraining(),
+			Weight:            b.Weight,
+			Priority:          b.Priority,
+			ActiveConnections: b.ActiveConnections(),
+			MaxConnections:    b.MaxConnections,
+			Breaker:           b.breaker.State(),
+			HealthPassed:      passed,
+			HealthFailed:      failed,
+			Latency:           newLatencyStats(&b.latency),
+			WeightPercent:     b.weightPercent(now),
+			ErrorRate:         b.errors.get(),
+			Ejected:           ejected,
+			Ejections:         ejections,
+		}
+		if ejected {
+			st.EjectedUntil = &until
+		}
+		st.OpenConnections, st.IdleConnections = b.UpstreamConnections()
+		if o := b.weightOverride.Load(); o != nil && now.Before(o.until) {
+			st.WeightOverride = o.weight
+		}
+		last, failure, changedAt := b.HealthDetails()
+		if !last.At.IsZero() {
+			st.LastProbeAt = &last.At
+			st.LastProbeMs = milliseconds(last.Duration)
+		}
+		if failure.Err != nil {
+			st.LastError = failure.Err.Error()
+			st.LastErrorAt = &failure.At
+		}
+		if !changedAt.IsZero() {
+			st.StateChangedAt = &changedAt
+		}
+		stats = append(stats, st)
+	}
+	if s.strategy == ConsistentHash && s.ring != nil {
+		shares := s.ring.shares()
+		for i, b := range s.backends {
+			stats[i].RingShare = shares[b]
+		}
+	}
+	//only hold up round-robin selection for the weight snapshot
+	s.wrrMux.Lock()
+	for i, b := range s.backends {
+		stats[i].EffectiveWeight = b.effectiveWeight
+		stats[i].CurrentWeight = b.currentWeight
+	}
+	s.wrrMux.Unlock()
+	return stats
+}
+
+func (l *LoadBalancer) statsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	stats := []BackendStats{}
+	for _, pool := range l.router.Pools() {
+		stats = append(stats, pool.Stats()...)
+	}
+	body := map[string]interface{}{
+		"backends": stats,
+	}
+	if l.policies.retryBudget != nil {
+		body["retry_budget"] = l.policies.retryBudget.Stats()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
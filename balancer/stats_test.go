@@ -0,0 +1,59 @@
+package balancer
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestStatsLastError(t *testing.T) {
+	var status atomic.Int64
+	status.Store(http.StatusServiceUnavailable)
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(status.Load()))
+	})
+	closed := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {})
+	closed.Close()
+	tests := []struct {
+		name       string
+		url        string
+		healthPath string
+		want       string //in the last error
+	}{
+		{"http status", backend.URL, "/health", "503"},
+		{"tcp dial", closed.URL, "", "connection refused"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := newTestPool(t, WithBackend(tt.url, 1), WithHealthPath(tt.healthPath), WithHealthThresholds(1, 1))
+			pool.checkBackends(pool.Backends())
+			st := pool.Stats()[0]
+			if st.Alive {
+				t.Fatal("backend alive after a failed probe")
+			}
+			if !strings.Contains(st.LastError, tt.want) {
+				t.Errorf("last error = %q, want it to contain %q", st.LastError, tt.want)
+			}
+			if st.LastProbeAt == nil || st.LastErrorAt == nil || !st.LastErrorAt.Equal(*st.LastProbeAt) {
+				t.Errorf("last probe at %v, last error at %v, want the same time", st.LastProbeAt, st.LastErrorAt)
+			}
+			if st.StateChangedAt == nil {
+				t.Error("no state change recorded for the backend marked down")
+			}
+		})
+	}
+
+	//the last error is kept once probes pass again
+	pool := newTestPool(t, WithBackend(backend.URL, 1), WithHealthPath("/health"), WithHealthThresholds(1, 1))
+	pool.checkBackends(pool.Backends())
+	status.Store(http.StatusOK)
+	pool.checkBackends(pool.Backends())
+	st := pool.Stats()[0]
+	if !st.Alive {
+		t.Fatal("backend down after a passed probe")
+	}
+	if !strings.Contains(st.LastError, "503") || st.LastProbeAt == nil || !st.LastProbeAt.After(*st.LastErrorAt) {
+		t.Errorf("last error %q at %v, last probe at %v, want the failure kept before the probe", st.LastError, st.LastErrorAt, st.LastProbeAt)
+	}
+}
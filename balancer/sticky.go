@@ -0,0 +1,74 @@
+package balancer
+
+import (
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Affinity pins clients to a backend with a cookie. An empty Cookie disables
+// it.
+type Affinity struct {
+	Cookie string
+	TTL    time.Duration
+}
+
+func (s *ServerPool) SetAffinity(affinity Affinity) {
+	s.mux.Lock()
+	s.affinity = affinity
+	s.mux.Unlock()
+}
+
+// backendID is the opaque value stored in the affinity cookie, so backend
+// addresses are not leaked to clients.
+func backendID(b *Backend) string {
+	h := fnv.New64a()
+	h.Write([]byte(b.Url.String()))
+	return strconv.FormatUint(h.Sum64(), 36)
+}
+
+// pinnedPeer returns the available backend the request's affinity cookie
+// points at, if any.
+func (s *ServerPool) pinnedPeer(r *http.Request) *Backend {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	if s.affinity.Cookie == "" {
+		return nil
+	}
+	c, err := r.Cookie(s.affinity.Cookie)
+	if err != nil {
+		return nil
+	}
+	for _, b := range s.backends {
+		if b.id == c.Value {
+			if s.tierFilter(GetTriedFromContext(r)).available(b) {
+				b.breaker.Begin()
+				return b
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// pin tells the client to stick to b on subsequent requests.
+func (s *ServerPool) pin(w http.ResponseWriter, b *Backend) {
+	s.mux.RLock()
+	affinity := s.affinity
+	s.mux.RUnlock()
+	if affinity.Cookie == "" {
+		return
+	}
+	c := &http.Cookie{
+		Name:     affinity.Cookie,
+		Value:    b.id,
+		Path:     "/",
+		HttpOnly: true,
+	}
+	if affinity.TTL > 0 {
+		c.MaxAge = int(affinity.TTL / time.Second)
+	}
+	//Set rather than Add so a failover re-pins instead of sending two cookies
+	w.Header().Set("Set-Cookie", c.String())
+}
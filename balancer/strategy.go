@@ -0,0 +1,194 @@
+package balancer
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+// Strategy selects how ServerPool picks the next backend.
+type Strategy string
+
+const (
+	RoundRobin               Strategy = "round-robin"
+	LeastConnections         Strategy = "least-connections"
+	WeightedLeastConnections Strategy = "weighted-least-connections"
+	IPHash                   Strategy = "ip-hash"
+	LeastTime                Strategy = "least-time"
+	ConsistentHash           Strategy = "consistent-hash"
+	P2C                      Strategy = "p2c"
+)
+
+func ParseStrategy(s string) (Strategy, error) {
+	switch Strategy(s) {
+	case RoundRobin, LeastConnections, WeightedLeastConnections, IPHash, LeastTime, ConsistentHash, P2C:
+		return Strategy(s), nil
+	}
+	return "", fmt.Errorf("unknown strategy %q", s)
+}
+
+// nextRoundRobin is the smooth weighted round-robin algorithm from nginx so
+// that heavier backends are interleaved with lighter ones instead of being
+// picked in bursts. Weights are scaled by the slow-start percentage, so a
+// backend that just came up ramps up from a trickle of requests, and by the
+// error weighting percentage. Weight overrides count instead of the
+// configured weights while in effect. The caller must hold s.mux and
+// s.wrrMux.
+func (s *ServerPool) nextRoundRobin(tried triedBackends) *Backend {
+	var best *Backend
+	total := 0
+	now := s.policies.clock.Now()
+	for _, b := range s.backends {
+		if !tried.available(b) {
+			continue
+		}
+		target := b.weight(now)
+		if b.effectiveWeight > target {
+			b.effectiveWeight = target
+		}
+		weight := b.effectiveWeight * b.weightPercent(now)
+		b.currentWeight += weight
+		total += weight
+		if b.effectiveWeight < target {
+			b.effectiveWeight++
+		}
+		if best == nil || b.currentWeight > best.currentWeight {
+			best = b
+		}
+	}
+	if best != nil {
+		best.currentWeight -= total
+	}
+	return best
+}
+
+// nextLeastConnections returns the alive backend with the fewest in-flight
+// requests, preferring the lowest index on ties. The caller must hold s.mux
+// for reading.
+func (s *ServerPool) nextLeastConnections(tried triedBackends) *Backend {
+	var best *Backend
+	var bestConns int64
+	for _, b := range s.backends {
+		if !tried.available(b) {
+			continue
+		}
+		conns := b.ActiveConnections()
+		if best == nil || conns < bestConns {
+			best, bestConns = b, conns
+		}
+	}
+	return best
+}
+
+// nextWeightedLeastConnections returns the alive backend with the fewest
+// in-flight requests per unit of weight, so a backend of weight 2 carries
+// twice the concurrent requests of one of weight 1. Ties go to the higher
+// weight and then the lowest index. The caller must hold s.mux for reading.
+func (s *ServerPool) nextWeightedLeastConnections(tried triedBackends) *Backend {
+	var best *Backend
+	var bestConns int64
+	var bestWeight int
+	now := s.policies.clock.Now()
+	for _, b := range s.backends {
+		if !tried.available(b) {
+			continue
+		}
+		conns, weight := b.ActiveConnections(), b.weight(now)
+		if best == nil {
+			best, bestConns, bestWeight = b, conns, weight
+			continue
+		}
+		//conns/weight < bestConns/bestWeight without dividing
+		lhs, rhs := conns*int64(bestWeight), bestConns*int64(weight)
+		if lhs < rhs || (lhs == rhs && weight > bestWeight) {
+			best, bestConns, bestWeight = b, conns, weight
+		}
+	}
+	return best
+}
+
+// nextP2C samples two distinct backends at random and returns the one with
+// fewer in-flight requests ("power of two choices"). That avoids herding onto
+// the single least loaded backend under bursts without scanning the pool,
+// except when neither sample is available. math/rand/v2 uses a per-thread
+// source, so concurrent requests do not contend on the generator. The caller
+// must hold s.mux for reading.
+func (s *ServerPool) nextP2C(tried triedBackends) *Backend {
+	n := len(s.backends)
+	if n == 1 {
+		if b := s.backends[0]; tried.available(b) {
+			return b
+		}
+		return nil
+	}
+	i := rand.IntN(n)
+	j := rand.IntN(n - 1)
+	if j >= i {
+		j++
+	}
+	a, b := s.backends[i], s.backends[j]
+	switch aOk, bOk := tried.available(a), tried.available(b); {
+	case aOk && bOk:
+		if b.ActiveConnections() < a.ActiveConnections() {
+			return b
+		}
+		return a
+	case aOk:
+		return a
+	case bOk:
+		return b
+	}
+	return s.nextLeastConnections(tried)
+}
+
+// nextLeastTime returns the alive backend with the lowest recent average
+// latency, breaking ties by fewest in-flight requests and then lowest index.
+// Backends without samples yet count as fastest so they get tried. The caller
+// must hold s.mux for reading.
+func (s *ServerPool) nextLeastTime(tried triedBackends) *Backend {
+	var best *Backend
+	var bestAvg time.Duration
+	var bestConns int64
+	for _, b := range s.backends {
+		if !tried.available(b) {
+			continue
+		}
+		avg, conns := b.latency.Average(), b.ActiveConnections()
+		if best == nil || avg < bestAvg || (avg == bestAvg && conns < bestConns) {
+			best, bestAvg, bestConns = b, avg, conns
+		}
+	}
+	return best
+}
+
+// nextIPHash maps the client address onto a backend, moving on to the
+// following backend when the chosen one is down or saturated. Clients only
+// move when the set of alive backends changes. The caller must hold s.mux for
+// reading.
+func (s *ServerPool) nextIPHash(r *http.Request, tried triedBackends) *Backend {
+	start := int(hashKey(s.policies.trustedProxies.clientIP(r)) % uint64(len(s.backends)))
+	for i := 0; i < len(s.backends); i++ {
+		b := s.backends[(start+i)%len(s.backends)]
+		if tried.available(b) {
+			return b
+		}
+	}
+	return nil
+}
+
+// hashKey hashes s with FNV-1a and runs the result through the murmur3
+// finalizer, as the low bits of plain FNV are poorly distributed for short,
+// similar keys such as IP addresses.
+func hashKey(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	k := h.Sum64()
+	k ^= k >> 33
+	k *= 0xff51afd7ed558ccd
+	k ^= k >> 33
+	k *= 0xc4ceb9fe1a85ec53
+	k ^= k >> 33
+	return k
+}
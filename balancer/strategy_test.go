@@ -0,0 +1,115 @@
+package balancer
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newStrategyPool builds a pool of strategy over n backends that are never
+// dialed, named http://10.0.0.<i>:80 from 1, with opts.
+func newStrategyPool(t testing.TB, strategy Strategy, n int, opts ...PoolOption) *ServerPool {
+	t.Helper()
+	opts = append([]PoolOption{WithStrategy(string(strategy))}, opts...)
+	for i := 1; i <= n; i++ {
+		opts = append(opts, WithBackend(fmt.Sprintf("http://10.0.0.%d:80", i), 1))
+	}
+	pool, err := NewServerPool("test", opts...)
+	if err != nil {
+		t.Fatalf("NewServerPool: %v", err)
+	}
+	return pool
+}
+
+// nextPeer returns the index of the backend picked for a new request.
+func nextPeer(t *testing.T, pool *ServerPool) int {
+	t.Helper()
+	peer := pool.GetNextPeer(withTried(httptest.NewRequest(http.MethodGet, "/", nil)))
+	for i, b := range pool.Backends() {
+		if b == peer {
+			return i
+		}
+	}
+	t.Fatal("GetNextPeer returned no backend")
+	return -1
+}
+
+func TestLeastTime(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		name      string
+		latencies [][]time.Duration
+		conns     []int64
+		down      []bool
+		want      int
+	}{
+		{"lowest average", [][]time.Duration{{30 * ms}, {5 * ms, 15 * ms}, {20 * ms}}, nil, nil, 1},
+		{"tie broken by connections", [][]time.Duration{{10 * ms}, {10 * ms}, {10 * ms}}, []int64{2, 1, 3}, nil, 1},
+		{"tie broken by index", [][]time.Duration{{10 * ms}, {10 * ms}, {10 * ms}}, nil, nil, 0},
+		{"no samples counts as fastest", [][]time.Duration{{10 * ms}, {1 * ms}, nil}, nil, nil, 2},
+		{"down backend skipped", [][]time.Duration{{30 * ms}, {5 * ms}, {20 * ms}}, nil, []bool{false, true, false}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := newStrategyPool(t, LeastTime, len(tt.latencies))
+			for i, b := range pool.Backends() {
+				for _, d := range tt.latencies[i] {
+					b.latency.Observe(d)
+				}
+				if tt.conns != nil {
+					b.connections = tt.conns[i]
+				}
+				if tt.down != nil && tt.down[i] {
+					b.SetAlive(false)
+				}
+			}
+			if got := nextPeer(t, pool); got != tt.want {
+				t.Errorf("picked backend %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func BenchmarkGetNextPeer(b *testing.B) {
+	strategies := []Strategy{RoundRobin, LeastConnections, WeightedLeastConnections, IPHash, LeastTime, ConsistentHash, P2C}
+	for _, strategy := range strategies {
+		b.Run(string(strategy), func(b *testing.B) {
+			pool := newStrategyPool(b, strategy, 16)
+			r := withTried(httptest.NewRequest(http.MethodGet, "/", nil))
+			b.ReportAllocs()
+			for b.Loop() {
+				pool.GetNextPeer(r)
+			}
+		})
+	}
+}
+
+func TestWeightedLeastConnections(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights []int
+		conns   []int64
+		want    int
+	}{
+		{"lowest connections per weight", []int{1, 3, 2}, []int64{1, 2, 2}, 1},
+		{"heavier backend carries more", []int{1, 4}, []int64{1, 3}, 1},
+		{"ratio above the lighter backend", []int{1, 4}, []int64{1, 5}, 0},
+		{"tie goes to the higher weight", []int{1, 2}, []int64{1, 2}, 1},
+		{"tie of equal weights goes to the first", []int{2, 2}, []int64{3, 3}, 0},
+		{"idle backends", []int{1, 5, 3}, []int64{0, 0, 0}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := newStrategyPool(t, WeightedLeastConnections, len(tt.weights))
+			for i, b := range pool.Backends() {
+				b.Weight = tt.weights[i]
+				b.connections = tt.conns[i]
+			}
+			if got := nextPeer(t, pool); got != tt.want {
+				t.Errorf("picked backend %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
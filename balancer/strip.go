@@ -0,0 +1,39 @@
+package balancer
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// withStripPrefix has prefix removed from the path of r when it is proxied.
+func withStripPrefix(r *http.Request, prefix string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), StripPrefix, prefix))
+}
+
+func GetStripPrefixFromContext(r *http.Request) string {
+	prefix, _ := r.Context().Value(StripPrefix).(string)
+	return prefix
+}
+
+// stripPrefix removes prefix from the path of u when it starts with it on a
+// segment boundary, as routes match, keeping its original encoding where the
+// raw path starts with prefix as well. The path left starts with a single
+// slash, so /api/users and /api//users both lose the prefix /api to /users,
+// and /api itself becomes /; other paths are left alone.
+func stripPrefix(u *url.URL, prefix string) {
+	if prefix == "" || !hasPathPrefix(u.Path, prefix) {
+		return
+	}
+	u.Path = "/" + strings.TrimLeft(u.Path[len(prefix):], "/")
+	if u.RawPath == "" {
+		return
+	}
+	if !hasPathPrefix(u.RawPath, prefix) {
+		//the prefix is encoded differently in the raw path, re-encode it
+		u.RawPath = ""
+		return
+	}
+	u.RawPath = "/" + strings.TrimLeft(u.RawPath[len(prefix):], "/")
+}
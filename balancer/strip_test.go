@@ -0,0 +1,85 @@
+package balancer
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+)
+
+func TestStripPrefix(t *testing.T) {
+	tests := []struct {
+		prefix, in string
+		want       string //escaped path sent to the backend
+	}{
+		{"/api", "/api/users", "/users"},
+		{"/api", "/api", "/"},
+		{"/api", "/api/", "/"},
+		{"/api", "/api//users", "/users"},
+		{"/api", "/api/users/", "/users/"},
+		{"/api", "/api/users//x", "/users//x"},
+		{"/api/", "/api/users", "/users"},
+		{"/api/", "/api/", "/"},
+		{"/api", "/apiusers", "/apiusers"},
+		{"/api", "/ap", "/ap"},
+		{"/api", "/v1/api/users", "/v1/api/users"},
+		{"/api", "/api/a%2Fb", "/a%2Fb"},
+		{"/api", "/api/caf%C3%A9", "/caf%C3%A9"},
+		{"/api", "/%61pi/users", "/users"},
+		{"/api/a", "/api/a%2Fb", "/b"},
+		{"/api", "/apiusers%2Fx", "/apiusers%2Fx"},
+		{"", "/api/users", "/api/users"},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stripPrefix(u, tt.prefix)
+		if got := u.EscapedPath(); got != tt.want {
+			t.Errorf("stripPrefix(%q, %q) = %q, want %q", tt.in, tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestStripPrefixProxied(t *testing.T) {
+	var hits atomic.Int64
+	failFirst := func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) == 1 {
+			hangUp(&atomic.Int64{})(w, r)
+			return
+		}
+		io.WriteString(w, r.URL.EscapedPath())
+	}
+	tests := []struct {
+		name  string
+		strip bool
+		want  string
+	}{
+		{"stripped", true, "/api/users"},
+		{"preserved", false, "/api/api/users"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hits.Store(0)
+			pool := newTestPool(t, WithBackend(newTestBackend(t, failFirst).URL, 1), withSettings(func(cfg *Config) {
+				cfg.RetryBackoff = 0
+			}))
+			r := httptest.NewRequest(http.MethodGet, "/api/api/users", nil)
+			if tt.strip {
+				r = withStripPrefix(r, "/api")
+			}
+			//the retry strips the prefix from the path of the client again,
+			//not from the one already stripped
+			w := serve(pool.Handler(), r)
+			if hits.Load() != 2 {
+				t.Fatalf("backend got %d requests, want a retry after the first", hits.Load())
+			}
+			if w.Body.String() != tt.want {
+				t.Errorf("backend got path %q, want %q", w.Body, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package balancer
+
+// tierFilter returns tried along with the backends of every tier after the
+// first one that has a backend in rotation not tried yet, so the strategies
+// skip them: backups with a higher Priority only get requests while every
+// backend before them is down, or has failed the request already, and lose
+// them again as soon as one is back. A backend at its max_connections still
+// counts as in rotation, leaving the request to queue rather than spill over
+// to the backups. The caller must hold s.mux.
+func (s *ServerPool) tierFilter(tried triedBackends) triedBackends {
+	tier, tiered := -1, false
+	for _, b := range s.backends {
+		if b.Priority != s.backends[0].Priority {
+			tiered = true
+		}
+		if !tried[b] && b.inRotation() && (tier < 0 || b.Priority < tier) {
+			tier = b.Priority
+		}
+	}
+	if !tiered || tier < 0 {
+		return tried
+	}
+	filtered := make(triedBackends, len(tried))
+	for b := range tried {
+		filtered[b] = true
+	}
+	for _, b := range s.backends {
+		if b.Priority > tier {
+			filtered[b] = true
+		}
+	}
+	return filtered
+}
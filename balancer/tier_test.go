@@ -0,0 +1,69 @@
+package balancer
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestTierFailover(t *testing.T) {
+	strategies := []Strategy{RoundRobin, LeastConnections, WeightedLeastConnections, IPHash, LeastTime, ConsistentHash, P2C}
+	for _, strategy := range strategies {
+		t.Run(string(strategy), func(t *testing.T) {
+			pool := newTestPool(t, WithStrategy(string(strategy)), WithBackends(
+				BackendConfig{Url: "http://10.0.0.1:80", Weight: 1},
+				BackendConfig{Url: "http://10.0.0.2:80", Weight: 1},
+				BackendConfig{Url: "http://10.0.1.1:80", Weight: 1, Priority: 1},
+			))
+			primary1, primary2, backup := pool.Backends()[0], pool.Backends()[1], pool.Backends()[2]
+			picked := func() []*Backend {
+				var picked []*Backend
+				for i := range 20 {
+					r := withTried(httptest.NewRequest(http.MethodGet, "/", nil))
+					r.RemoteAddr = fmt.Sprintf("192.0.2.%d:1234", i+1)
+					if peer := pool.GetNextPeer(r); peer != nil && !slices.Contains(picked, peer) {
+						picked = append(picked, peer)
+					}
+				}
+				return picked
+			}
+			steps := []struct {
+				name  string
+				alive []bool
+				want  []*Backend //every backend that may be picked
+			}{
+				{"primaries up", []bool{true, true, true}, []*Backend{primary1, primary2}},
+				{"one primary down", []bool{false, true, true}, []*Backend{primary2}},
+				{"failover", []bool{false, false, true}, []*Backend{backup}},
+				{"failback", []bool{true, false, true}, []*Backend{primary1}},
+			}
+			for _, step := range steps {
+				for i, b := range pool.Backends() {
+					b.SetAlive(step.alive[i])
+				}
+				got := picked()
+				if len(got) == 0 {
+					t.Errorf("%s: no backend picked", step.name)
+				}
+				for _, b := range got {
+					if !slices.Contains(step.want, b) {
+						t.Errorf("%s: picked %s", step.name, b.Url)
+					}
+				}
+			}
+
+			//a request failed by every primary goes to the backup
+			for _, b := range pool.Backends() {
+				b.SetAlive(true)
+			}
+			r := withTried(httptest.NewRequest(http.MethodGet, "/", nil))
+			GetTriedFromContext(r).add(primary1)
+			GetTriedFromContext(r).add(primary2)
+			if peer := pool.GetNextPeer(r); peer != backup {
+				t.Errorf("picked %v once the primaries failed the request, want the backup", peer)
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package balancer
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// UpstreamTimeouts bound every request to a backend, per pool with the
+// top-level settings as the default. Dial and ResponseHeader apply to the
+// transport; Request caps a whole attempt against one backend,
+// including streaming the response body, and is disabled when 0 so that long
+// downloads are not cut. A timeout fails the attempt like a connection error,
+// so the usual retry and failover path applies. Total caps all attempts of a
+// request together, after which it fails with 504, 0 for none.
+type UpstreamTimeouts struct {
+	Dial           time.Duration
+	ResponseHeader time.Duration
+	Request        time.Duration
+	Total          time.Duration
+}
+
+var upstreamTimeouts = UpstreamTimeouts{
+	Dial:           5 * time.Second,
+	ResponseHeader: 30 * time.Second,
+}
+
+// UpstreamKeepAlive sizes the pool of idle connections kept open to
+// backends for reuse. MaxIdleConns caps them across the backends of a pool,
+// 0 for no limit, and MaxIdleConnsPerHost per backend; IdleConnTimeout
+// closes those unused for that long, 0 for never. The per backend limit
+// should be at least the busy backends' max_connections: above it,
+// connections freed after a burst are closed and have to be dialed again on
+// the next one. It only applies to idle connections, max_connections still
+// caps those in use.
+//
+// Isolated gives every backend a transport of its own, so that a slow or
+// busy backend cannot take the idle connections of the others; MaxIdleConns
+// then caps them per backend.
+type UpstreamKeepAlive struct {
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     time.Duration
+	Isolated            bool
+}
+
+// upstreamTransports are the transports to the backends of a pool, built for
+// its dial and response header timeouts: one for plain HTTP and HTTPS, one
+// for h2c and one per Unix socket and per backend with its own TLS config,
+// made on first use. When isolated, every other backend gets one too.
+type upstreamTransports struct {
+	http     *http.Transport
+	h2c      *http.Transport
+	isolated bool
+
+	mux      sync.Mutex
+	unix     map[string]*http.Transport
+	tls      map[string]tlsTransport
+	backends map[string]*http.Transport
+}
+
+func newUpstreamTransports(t UpstreamTimeouts, k UpstreamKeepAlive) *upstreamTransports {
+	return &upstreamTransports{
+		http:     newTransport(t, k),
+		h2c:      newH2CTransport(t, k),
+		isolated: k.Isolated,
+		unix:     make(map[string]*http.Transport),
+		tls:      make(map[string]tlsTransport),
+		backends: make(map[string]*http.Transport),
+	}
+}
+
+// get returns the transport for b.
+func (u *upstreamTransports) get(b *Backend) *http.Transport {
+	base := u.http
+	if b.h2c {
+		base = u.h2c
+	}
+	if cfg := b.tlsConfig.Load(); cfg != nil {
+		return u.tlsTransport(base, b.Url.String(), cfg)
+	}
+	if !isUnixSocket(b.Url) {
+		if u.isolated {
+			return u.backendTransport(base, b.Url.String())
+		}
+		return base
+	}
+	key := b.Url.String()
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	t, ok := u.unix[key]
+	if !ok {
+		t = unixTransport(base, b.Url.Path)
+		u.unix[key] = t
+	}
+	return t
+}
+
+// backendTransport returns the transport of its own derived from base for
+// the backend at key.
+func (u *upstreamTransports) backendTransport(base *http.Transport, key string) *http.Transport {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	t, ok := u.backends[key]
+	if !ok {
+		t = base.Clone()
+		u.backends[key] = t
+	}
+	return t
+}
+
+// prune drops the transports of backends that are no longer in backends.
+func (u *upstreamTransports) prune(backends []*Backend) {
+	live := make(map[string]bool, len(backends))
+	for _, b := range backends {
+		live[b.Url.String()] = true
+	}
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	for key, t := range u.unix {
+		if !live[key] {
+			t.CloseIdleConnections()
+			delete(u.unix, key)
+		}
+	}
+	for key, t := range u.tls {
+		if !live[key] {
+			t.transport.CloseIdleConnections()
+			delete(u.tls, key)
+		}
+	}
+	for key, t := range u.backends {
+		if !live[key] {
+			t.CloseIdleConnections()
+			delete(u.backends, key)
+		}
+	}
+}
+
+// size returns the number of transports made for particular backends.
+func (u *upstreamTransports) size() int {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	return len(u.unix) + len(u.tls) + len(u.backends)
+}
+
+// closeIdle closes the idle connections of transports that are replaced.
+func (u *upstreamTransports) closeIdle() {
+	u.http.CloseIdleConnections()
+	u.h2c.CloseIdleConnections()
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	for _, t := range u.unix {
+		t.CloseIdleConnections()
+	}
+	for _, t := range u.tls {
+		t.transport.CloseIdleConnections()
+	}
+	for _, t := range u.backends {
+		t.CloseIdleConnections()
+	}
+}
+
+// backendTransport sends the requests of a backend over the transport of its
+// pool, so a reload changing the pool's timeouts applies to the backends it
+// already has.
+type backendTransport struct {
+	backend *Backend
+}
+
+func (t backendTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.backend.pool.transports.Load().get(t.backend).RoundTrip(req)
+}
+
+func newTransport(t UpstreamTimeouts, k UpstreamKeepAlive) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = countingDial((&net.Dialer{
+		Timeout:   t.Dial,
+		KeepAlive: 30 * time.Second,
+	}).DialContext)
+	transport.ResponseHeaderTimeout = t.ResponseHeader
+	transport.MaxIdleConns = k.MaxIdleConns
+	transport.MaxIdleConnsPerHost = k.MaxIdleConnsPerHost
+	transport.IdleConnTimeout = k.IdleConnTimeout
+	return transport
+}
+
+// newH2CTransport speaks HTTP/2 without TLS with prior knowledge, as gRPC
+// servers expect.
+func newH2CTransport(t UpstreamTimeouts, k UpstreamKeepAlive) *http.Transport {
+	transport := newTransport(t, k)
+	transport.Protocols = new(http.Protocols)
+	transport.Protocols.SetUnencryptedHTTP2(true)
+	return transport
+}
+
+// withTotalTimeout bounds all attempts of r by total, 0 for no limit. Every
+// attempt derives its context from the one returned, so the backend request
+// is cancelled as soon as the client disconnects or the total timeout passes.
+func withTotalTimeout(r *http.Request, total time.Duration) (*http.Request, context.CancelFunc) {
+	if total <= 0 || isUpgrade(r) {
+		return r, func() {}
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), total)
+	return r.WithContext(ctx), cancel
+}
+
+// withAttemptTimeout derives the context of a single attempt, bounded by
+// timeout unless 0. The context it was derived from is kept so a retry or
+// failover does not inherit the deadline of the attempt that just expired.
+func withAttemptTimeout(r *http.Request, timeout time.Duration) (*http.Request, context.CancelFunc) {
+	if timeout <= 0 || isUpgrade(r) {
+		return r, func() {}
+	}
+	base := r.Context()
+	ctx, cancel := context.WithTimeout(base, timeout)
+	return r.WithContext(context.WithValue(ctx, BaseContext, base)), cancel
+}
+
+// GetBaseContext returns the request context without the deadline of the
+// current attempt.
+func GetBaseContext(r *http.Request) context.Context {
+	if base, ok := r.Context().Value(BaseContext).(context.Context); ok {
+		return base
+	}
+	return r.Context()
+}
@@ -0,0 +1,125 @@
+package balancer
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// BenchmarkKeepAlive proxies concurrent requests to one backend and reports
+// the connections dialed per request: with fewer idle connections per host
+// than requests in flight, connections freed by a request are closed and
+// dialed again by the next one.
+func BenchmarkKeepAlive(b *testing.B) {
+	for _, perHost := range []int{1, 8, 64} {
+		b.Run("idle-per-host="+strconv.Itoa(perHost), func(b *testing.B) {
+			var dials atomic.Int64
+			backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(100 * time.Microsecond)
+			}))
+			backend.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+				if state == http.StateNew {
+					dials.Add(1)
+				}
+			}
+			backend.Start()
+			defer backend.Close()
+			cfg := DefaultConfig()
+			cfg.MaxIdleConnsPerHost = perHost
+			pool, err := NewServerPool("test", WithBackend(backend.URL, 1), WithPolicies(cfg))
+			if err != nil {
+				b.Fatal(err)
+			}
+			handler := pool.Handler()
+
+			b.SetParallelism(16)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					handler.ServeHTTP(&discardWriter{header: http.Header{}}, httptest.NewRequest(http.MethodGet, "/", nil))
+				}
+			})
+			b.ReportMetric(float64(dials.Load())/float64(b.N), "dials/op")
+		})
+	}
+}
+
+// BenchmarkIsolatedTransports sends requests to a fast backend while a slow
+// one of the same pool gets bursts of concurrent requests, and reports the
+// connections dialed to the fast one per request. Sharing a transport, the
+// slow backend's connections fill the pool's idle slots at the end of every
+// burst and evict the fast one's, which has to dial again; isolated, each
+// backend keeps its own.
+func BenchmarkIsolatedTransports(b *testing.B) {
+	for _, isolated := range []bool{false, true} {
+		b.Run("isolated="+strconv.FormatBool(isolated), func(b *testing.B) {
+			slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(time.Millisecond)
+			}))
+			defer slow.Close()
+			var dials atomic.Int64
+			fast := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			fast.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+				if state == http.StateNew {
+					dials.Add(1)
+				}
+			}
+			fast.Start()
+			defer fast.Close()
+			cfg := DefaultConfig()
+			cfg.MaxIdleConns, cfg.MaxIdleConnsPerHost, cfg.IdleConnTimeout = 8, 8, 90*time.Second
+			cfg.IsolateTransports = isolated
+			pool, err := NewServerPool("test", WithBackend(slow.URL, 1), WithBackend(fast.URL, 1), WithPolicies(cfg))
+			if err != nil {
+				b.Fatal(err)
+			}
+			backends := pool.Backends()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for ctx.Err() == nil {
+					var wg sync.WaitGroup
+					for range 32 {
+						wg.Add(1)
+						go func() {
+							defer wg.Done()
+							roundTrip(ctx, backends[0])
+						}()
+					}
+					wg.Wait()
+				}
+			}()
+			b.ResetTimer()
+			for range b.N {
+				roundTrip(context.Background(), backends[1])
+				// Leave the connection idle, as between the requests of a quiet client
+				time.Sleep(time.Millisecond)
+			}
+			b.StopTimer()
+			cancel()
+			<-done
+			b.ReportMetric(float64(dials.Load())/float64(b.N), "dials/op")
+		})
+	}
+}
+
+// roundTrip sends a GET to backend over the transport of its pool and reads
+// the response, so that the connection can be reused.
+func roundTrip(ctx context.Context, backend *Backend) {
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, backend.Url.String(), nil)
+	resp, err := backendTransport{backend: backend}.RoundTrip(req)
+	if err != nil {
+		return
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+}
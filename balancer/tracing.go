@@ -0,0 +1,76 @@
+package balancer
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// propagator carries the trace context and baggage between the client, the
+// load balancer and the backends.
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// setupTracing returns a tracer exporting spans over OTLP/HTTP and the
+// function shutting it down. The endpoint, headers and service name come from
+// the standard OTEL_* environment variables. Tracing is only on for the
+// LoadBalancer setting it up; when off no tracing code runs on the request
+// path at all.
+func setupTracing(ctx context.Context) (trace.Tracer, func(context.Context) error, error) {
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter))
+	return provider.Tracer("loadbalancer"), provider.Shutdown, nil
+}
+
+// withTracing starts a server span of tracer per request, continuing the
+// caller's trace when it sent one. trusted are the trusted proxies.
+func withTracing(tracer trace.Tracer, trusted proxyPrefixes, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+			attribute.String("http.request.method", r.Method),
+			attribute.String("url.path", r.URL.Path),
+			attribute.String("client.address", trusted.clientIP(r)),
+		))
+		defer span.End()
+		sw := &statusWriter{ResponseWriter: w}
+		h.ServeHTTP(sw, r.WithContext(ctx))
+		if sw.status != 0 {
+			span.SetAttributes(attribute.Int("http.response.status_code", sw.status))
+			if sw.status >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, http.StatusText(sw.status))
+			}
+		}
+	})
+}
+
+// traceAttempt records the backend chosen for an attempt on the request span.
+func traceAttempt(r *http.Request, b *Backend, attempt int) {
+	if !b.pool.policies.tracing {
+		return
+	}
+	span := trace.SpanFromContext(r.Context())
+	span.AddEvent("attempt", trace.WithAttributes(
+		attribute.String("lb.pool", b.pool.Name),
+		attribute.String("lb.backend", backendHost(b.Url)),
+		attribute.Int("lb.attempt", attempt),
+	))
+	span.SetAttributes(attribute.String("lb.backend", backendHost(b.Url)), attribute.Int("lb.attempts", attempt+1))
+}
+
+// injectTrace passes the trace context on to the backend so its spans link
+// up, when tracing is on.
+func injectTrace(tracing bool, out *http.Request) {
+	if !tracing {
+		return
+	}
+	propagator.Inject(out.Context(), propagation.HeaderCarrier(out.Header))
+}
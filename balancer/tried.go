@@ -0,0 +1,33 @@
+package balancer
+
+import (
+	"context"
+	"net/http"
+)
+
+// triedBackends are the backends a request has been sent to. It is shared by
+// all attempts of the request, which run one after the other, so a failover
+// picks a backend that has not failed the request yet.
+type triedBackends map[*Backend]bool
+
+// withTried starts tracking the backends tried for r.
+func withTried(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), Tried, triedBackends{}))
+}
+
+func GetTriedFromContext(r *http.Request) triedBackends {
+	tried, _ := r.Context().Value(Tried).(triedBackends)
+	return tried
+}
+
+// available reports whether b can take the request: it is available and has
+// not been tried for it yet.
+func (t triedBackends) available(b *Backend) bool {
+	return !t[b] && b.IsAvailable()
+}
+
+func (t triedBackends) add(b *Backend) {
+	if t != nil {
+		t[b] = true
+	}
+}
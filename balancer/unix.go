@@ -0,0 +1,47 @@
+package balancer
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// A backend URL like unix:///var/run/app.sock proxies plain HTTP over the
+// Unix domain socket at its path. Requests to it carry the client's Host as
+// usual; the URL they are sent to is unixTarget.
+var unixTarget = &url.URL{Scheme: "http", Host: "localhost"}
+
+func isUnixSocket(u *url.URL) bool {
+	return u.Scheme == "unix"
+}
+
+// backendHost names the backend at u in logs, metrics and stats: its host
+// and port, or the socket path of a Unix socket backend.
+func backendHost(u *url.URL) string {
+	if isUnixSocket(u) {
+		return u.Path
+	}
+	return u.Host
+}
+
+// backendAddr returns what to dial to reach the backend at u.
+func backendAddr(u *url.URL) (network, address string) {
+	if isUnixSocket(u) {
+		return "unix", u.Path
+	}
+	return "tcp", u.Host
+}
+
+// unixTransport derives a transport from base that dials the socket at path
+// for every connection, keeping the dial timeout of base. Each socket gets its
+// own transport as the idle connections of a transport are keyed by the host
+// of unixTarget.
+func unixTransport(base *http.Transport, path string) *http.Transport {
+	t := base.Clone()
+	dial := base.DialContext
+	t.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return dial(ctx, "unix", path)
+	}
+	return t
+}
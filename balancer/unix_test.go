@@ -0,0 +1,59 @@
+package balancer
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+// newUnixBackend serves h on a temporary Unix socket and returns its URL.
+func newUnixBackend(t *testing.T, h http.HandlerFunc) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "app.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skipf("cannot listen on a Unix socket: %v", err)
+	}
+	srv := &http.Server{Handler: h}
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+	return "unix://" + path
+}
+
+func TestUnixSocketBackend(t *testing.T) {
+	backendUrl := newUnixBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Host+r.URL.Path)
+	})
+	pool := newTestPool(t, WithBackend(backendUrl, 1), WithHealthPath("/healthz"))
+
+	r := httptest.NewRequest(http.MethodGet, "/users", nil)
+	r.Host = "app.example.com"
+	w := serve(pool.Handler(), r)
+	if w.Code != http.StatusOK || w.Body.String() != "app.example.com/users" {
+		t.Fatalf("got %d %q, want 200 %q", w.Code, w.Body.String(), "app.example.com/users")
+	}
+
+	pool.HeadthCheck(true)
+	b := pool.Backends()[0]
+	if !b.IsAlive() {
+		t.Fatal("backend down after probing its socket")
+	}
+	if last, _, _ := b.HealthDetails(); last.Err != nil {
+		t.Fatalf("probe failed: %v", last.Err)
+	}
+	if stats := pool.Stats(); stats[0].Url != backendUrl {
+		t.Fatalf("stats url = %s, want %s", stats[0].Url, backendUrl)
+	}
+}
+
+func TestUnixSocketBackendDown(t *testing.T) {
+	backendUrl := "unix://" + filepath.Join(t.TempDir(), "missing.sock")
+	pool := newTestPool(t, WithBackend(backendUrl, 1), WithHealthThresholds(1, 1))
+	pool.HeadthCheck(true)
+	if pool.Backends()[0].IsAlive() {
+		t.Fatal("backend up without a socket to dial")
+	}
+}
@@ -0,0 +1,25 @@
+package balancer
+
+import (
+	"net/http"
+	"strings"
+)
+
+// isUpgrade reports whether r asks to switch protocols, e.g. to WebSocket.
+// httputil.ReverseProxy hijacks such connections and copies both directions
+// unbuffered once the backend answers 101, so the stream cannot be replayed:
+// upgrades are never retried, are exempt from the per-attempt timeout and are
+// kept out of latency stats.
+func isUpgrade(r *http.Request) bool {
+	if r.Header.Get("Upgrade") == "" {
+		return false
+	}
+	for _, v := range r.Header.Values("Connection") {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package balancer
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// echoUpgrade switches to the "echo" protocol and then echoes whatever the
+// client sends, standing in for a WebSocket echo server.
+func echoUpgrade(hits *atomic.Int64) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if !isUpgrade(r) {
+			http.Error(w, "upgrade required", http.StatusUpgradeRequired)
+			return
+		}
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		rw.Flush()
+		io.Copy(conn, rw)
+	}
+}
+
+// dialUpgrade sends an upgrade request to srv and returns the connection and
+// the response.
+func dialUpgrade(t *testing.T, srv *httptest.Server) (net.Conn, *bufio.Reader, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, "GET /ws HTTP/1.1\r\nHost: lb\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, br, resp
+}
+
+func TestUpgradePassthrough(t *testing.T) {
+	var hits atomic.Int64
+	backend := newTestBackend(t, echoUpgrade(&hits))
+	pool := newTestPool(t, WithBackend(backend.URL, 1))
+	lb := httptest.NewServer(pool.Handler())
+	defer lb.Close()
+
+	conn, br, resp := dialUpgrade(t, lb)
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusSwitchingProtocols)
+	}
+	//each message comes back before the next is sent, nothing is buffered
+	for _, msg := range []string{"hello\n", "world\n"} {
+		io.WriteString(conn, msg)
+		got, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != msg {
+			t.Fatalf("echoed %q, want %q", got, msg)
+		}
+	}
+}
+
+func TestUpgradeNotRetried(t *testing.T) {
+	var failed, served atomic.Int64
+	bad := newTestBackend(t, hangUp(&failed))
+	good := newTestBackend(t, echoUpgrade(&served))
+	pool := newTestPool(t, WithBackend(bad.URL, 1), WithBackend(good.URL, 1))
+	lb := httptest.NewServer(pool.Handler())
+	defer lb.Close()
+
+	_, _, resp := dialUpgrade(t, lb)
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusBadGateway)
+	}
+	if failed.Load() != 1 || served.Load() != 0 {
+		t.Fatalf("backends got %d and %d upgrades, want 1 and 0", failed.Load(), served.Load())
+	}
+}
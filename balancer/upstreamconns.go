@@ -0,0 +1,64 @@
+package balancer
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// upstreamConns counts the connections open to each backend address, the
+// host and port or the socket path, whichever pool or transport dialed them.
+var upstreamConns sync.Map
+
+// countingDial wraps dial to count the connections it opens until they are
+// closed.
+func countingDial(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		open := connCount(addr)
+		open.Add(1)
+		return &countedConn{Conn: conn, open: open}, nil
+	}
+}
+
+func connCount(addr string) *atomic.Int64 {
+	if n, ok := upstreamConns.Load(addr); ok {
+		return n.(*atomic.Int64)
+	}
+	n, _ := upstreamConns.LoadOrStore(addr, new(atomic.Int64))
+	return n.(*atomic.Int64)
+}
+
+// countedConn is a connection counted by countingDial.
+type countedConn struct {
+	net.Conn
+	once sync.Once
+	open *atomic.Int64
+}
+
+func (c *countedConn) Close() error {
+	c.once.Do(func() { c.open.Add(-1) })
+	return c.Conn.Close()
+}
+
+// UpstreamConnections returns the number of connections open to b and how
+// many of them are idle, kept for reuse. Idle ones are those beyond the
+// requests in flight, exact for HTTP/1.1 where a connection serves one
+// request at a time and a lower bound for HTTP/2. A backend sharing its
+// address with one of another pool counts their connections together.
+func (b *Backend) UpstreamConnections() (open, idle int64) {
+	addr := b.Url.Host
+	if isUnixSocket(b.Url) {
+		addr = b.Url.Path
+	}
+	n, ok := upstreamConns.Load(addr)
+	if !ok {
+		return 0, 0
+	}
+	open = n.(*atomic.Int64).Load()
+	return open, max(open-b.ActiveConnections(), 0)
+}
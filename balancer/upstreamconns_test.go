@@ -0,0 +1,92 @@
+package balancer
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// nopConn is a connection that does nothing, to count without dialing.
+type nopConn struct {
+	net.Conn
+}
+
+func (nopConn) Close() error { return nil }
+
+func nopDial(context.Context, string, string) (net.Conn, error) {
+	return nopConn{}, nil
+}
+
+func TestUpstreamConnections(t *testing.T) {
+	release := make(chan struct{})
+	srv := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/hold" {
+			<-release
+		}
+	})
+	pool := newTestPool(t, WithBackend(srv.URL, 1))
+	backend := pool.Backends()[0]
+
+	if open, idle := backend.UpstreamConnections(); open != 0 || idle != 0 {
+		t.Fatalf("UpstreamConnections = %d, %d before any request, want 0, 0", open, idle)
+	}
+	roundTrip(context.Background(), backend)
+	if open, idle := backend.UpstreamConnections(); open != 1 || idle != 1 {
+		t.Fatalf("UpstreamConnections = %d, %d after a request, want 1, 1", open, idle)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		serve(pool.Handler(), httptest.NewRequest(http.MethodGet, "/hold", nil))
+	}()
+	waitFor(t, func() bool { return backend.ActiveConnections() == 1 })
+	if open, idle := backend.UpstreamConnections(); open != 1 || idle != 0 {
+		t.Errorf("UpstreamConnections = %d, %d with the connection in use, want 1, 0", open, idle)
+	}
+	close(release)
+	<-done
+
+	pool.transports.Load().closeIdle()
+	waitFor(t, func() bool { open, _ := backend.UpstreamConnections(); return open == 0 })
+}
+
+// BenchmarkCountingDial measures what counting adds to opening and closing
+// a connection, across a few backend addresses.
+func BenchmarkCountingDial(b *testing.B) {
+	dial := countingDial(nopDial)
+	addrs := make([]string, 8)
+	for i := range addrs {
+		addrs[i] = "10.0.2." + strconv.Itoa(i) + ":80"
+	}
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			conn, err := dial(context.Background(), "tcp", addrs[i%len(addrs)])
+			if err != nil {
+				b.Fatal(err)
+			}
+			conn.Close()
+		}
+	})
+}
+
+// BenchmarkUpstreamConnections measures reading the counts of a backend, as
+// stats do for every backend on every scrape.
+func BenchmarkUpstreamConnections(b *testing.B) {
+	pool, err := NewServerPool("test", WithBackend("http://10.0.3.1:80", 1))
+	if err != nil {
+		b.Fatal(err)
+	}
+	backend := pool.Backends()[0]
+	conn, _ := countingDial(nopDial)(context.Background(), "tcp", backend.Url.Host)
+	defer conn.Close()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			backend.UpstreamConnections()
+		}
+	})
+}
@@ -0,0 +1,136 @@
+package balancer
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// BackendTLS configures the TLS client of an HTTPS backend: CA is a PEM
+// bundle trusted instead of the system roots, for self-signed or internal CA
+// certificates, and Cert and Key a client certificate for mutual TLS.
+// InsecureSkipVerify accepts any certificate, which leaves the connection
+// open to interception.
+type BackendTLS struct {
+	CA                 string `yaml:"ca" json:"ca"`
+	Cert               string `yaml:"cert" json:"cert"`
+	Key                string `yaml:"key" json:"key"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify" json:"insecure_skip_verify"`
+}
+
+func (t BackendTLS) enabled() bool {
+	return t != BackendTLS{}
+}
+
+// config loads the files of t into a TLS client config.
+func (t BackendTLS) config() (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: t.InsecureSkipVerify}
+	if t.CA != "" {
+		pool, err := loadCAPool(t.CA)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = pool
+	}
+	if t.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(t.Cert, t.Key)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// loadCAPool reads the PEM certificates of the bundle at path.
+func loadCAPool(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no PEM certificate in %s", path)
+	}
+	return pool, nil
+}
+
+// validate loads the files of t to check them.
+func (t BackendTLS) validate(v *validator, prefix, backendUrl string) {
+	if !t.enabled() {
+		return
+	}
+	if !strings.HasPrefix(backendUrl, "https://") {
+		v.fail(prefix+"tls", "needs an https backend, got %q", backendUrl)
+		return
+	}
+	if t.CA != "" {
+		if _, err := loadCAPool(t.CA); err != nil {
+			v.fail(prefix+"tls.ca", "%s", err)
+		}
+	}
+	if (t.Cert == "") != (t.Key == "") {
+		v.fail(prefix+"tls.cert", "cert and key must be set together")
+	} else if t.Cert != "" {
+		if _, err := tls.LoadX509KeyPair(t.Cert, t.Key); err != nil {
+			v.fail(prefix+"tls.cert", "%s", err)
+		}
+	}
+}
+
+// tlsTransport is the transport of a backend with its own TLS config.
+type tlsTransport struct {
+	config    *tls.Config
+	transport *http.Transport
+}
+
+// tlsTransport returns the transport derived from base for the backend at
+// key with cfg, replacing the previous one when a reload changed cfg.
+func (u *upstreamTransports) tlsTransport(base *http.Transport, key string, cfg *tls.Config) *http.Transport {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	t, ok := u.tls[key]
+	if ok && t.config == cfg {
+		return t.transport
+	}
+	if ok {
+		t.transport.CloseIdleConnections()
+	}
+	transport := base.Clone()
+	transport.TLSClientConfig = cfg.Clone()
+	u.tls[key] = tlsTransport{cfg, transport}
+	return transport
+}
+
+// isBackendTLSAlive dials u, completing a TLS handshake so that certificate
+// errors show up as such.
+func isBackendTLSAlive(u *url.URL, cfg *tls.Config, timeout time.Duration) error {
+	dialer := &tls.Dialer{NetDialer: &net.Dialer{Timeout: timeout}, Config: cfg}
+	conn, err := dialer.Dial("tcp", u.Host)
+	if err != nil {
+		logProbeError(u, err)
+		return err
+	}
+	conn.Close()
+	return nil
+}
+
+// isTLSError reports whether err comes from a failed TLS handshake rather
+// than an unreachable backend.
+func isTLSError(err error) bool {
+	var verify *tls.CertificateVerificationError
+	var record tls.RecordHeaderError
+	var alert tls.AlertError
+	var unknown x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	return errors.As(err, &verify) || errors.As(err, &record) || errors.As(err, &alert) ||
+		errors.As(err, &unknown) || errors.As(err, &hostname) || errors.As(err, &invalid)
+}
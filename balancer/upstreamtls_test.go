@@ -0,0 +1,77 @@
+package balancer
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBackendTLS(t *testing.T) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(backend.Close)
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: backend.Certificate().Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		tls  BackendTLS
+		want int
+	}{
+		{"system roots", BackendTLS{}, http.StatusBadGateway},
+		{"configured CA", BackendTLS{CA: ca}, http.StatusOK},
+		{"skip verify", BackendTLS{InsecureSkipVerify: true}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := newTestPool(t, WithBackends(BackendConfig{Url: backend.URL, Weight: 1, TLS: tt.tls}), WithHealthPath("/health"), withSettings(func(cfg *Config) {
+				cfg.MaxRetries = 0
+			}))
+			b := pool.Backends()[0]
+			pool.checkBackends(pool.Backends())
+			if alive := tt.want == http.StatusOK; b.IsAlive() != alive {
+				t.Fatalf("alive = %v, want %v", b.IsAlive(), alive)
+			}
+			if !b.IsAlive() {
+				if _, failure, _ := b.HealthDetails(); failure.Err == nil || !strings.Contains(failure.Err.Error(), "certificate") {
+					t.Errorf("health check error = %v, want a certificate error", failure.Err)
+				}
+				b.SetAlive(true)
+			}
+			w := serve(pool.Handler(), httptest.NewRequest(http.MethodGet, "/", nil))
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackendTLSValidate(t *testing.T) {
+	empty := filepath.Join(t.TempDir(), "empty.pem")
+	if err := os.WriteFile(empty, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		url string
+		tls BackendTLS
+		err string
+	}{
+		{"https://10.0.0.1:443", BackendTLS{InsecureSkipVerify: true}, ""},
+		{"http://10.0.0.1:80", BackendTLS{InsecureSkipVerify: true}, "tls: needs an https backend"},
+		{"https://10.0.0.1:443", BackendTLS{CA: empty}, "tls.ca: no PEM certificate in"},
+		{"https://10.0.0.1:443", BackendTLS{CA: filepath.Join(t.TempDir(), "missing.pem")}, "tls.ca: open"},
+		{"https://10.0.0.1:443", BackendTLS{Cert: "cert.pem"}, "tls.cert: cert and key must be set together"},
+	}
+	for _, tt := range tests {
+		v := &validator{}
+		tt.tls.validate(v, "", tt.url)
+		err := v.err()
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.HasPrefix(err.Error(), tt.err)) {
+			t.Errorf("validate %+v for %s = %v, want %q", tt.tls, tt.url, err, tt.err)
+		}
+	}
+}
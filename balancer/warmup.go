@@ -0,0 +1,81 @@
+package balancer
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+)
+
+// Warmup probes every backend once before traffic is accepted, marking each
+// up or down by that probe alone rather than the rise and fall thresholds, so
+// that the first requests do not go to backends that are not ready yet. It
+// waits up to the warmup_timeout of the config, 0 to skip the warmup;
+// backends whose probe is still pending then stay assumed up until the
+// health checks of Run settle them. With warmup_require_healthy it fails
+// unless a backend passed its probe.
+func (l *LoadBalancer) Warmup(ctx context.Context) error {
+	timeout := l.cfg.WarmupTimeout
+	if timeout <= 0 {
+		return nil
+	}
+	clock := l.policies.clock
+	start := clock.Now()
+	var up, pending atomic.Int64
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		//holds off the first health check of Run until every probe is in
+		l.policies.healthCheckMux.Lock()
+		defer l.policies.healthCheckMux.Unlock()
+		for _, pool := range l.router.Pools() {
+			pool.warmup(&up, &pending)
+		}
+	}()
+	select {
+	case <-done:
+		slog.Info("warmup completed", "up", up.Load(), "duration", since(clock, start))
+	case <-clock.After(timeout):
+		slog.Warn("warmup timed out, assuming pending backends up", "up", up.Load(), "pending", pending.Load(), "timeout", timeout)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if l.cfg.WarmupRequireHealthy && up.Load() == 0 {
+		return fmt.Errorf("no backend passed its warmup probe within %s", timeout)
+	}
+	return nil
+}
+
+// warmup probes the backends of the pool and sets them up or down by the
+// result, counting those that passed in up and those not probed yet in
+// pending.
+func (s *ServerPool) warmup(up, pending *atomic.Int64) {
+	s.mux.RLock()
+	health := s.health
+	s.mux.RUnlock()
+	backends := s.Backends()
+	pending.Add(int64(len(backends)))
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, max(s.policies.healthConcurrency, 1))
+	for _, b := range backends {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			start := s.policies.clock.Now()
+			err := health.probe(b)
+			b.SetAlive(err == nil)
+			health.recordProbe(b, err, start)
+			pending.Add(-1)
+			if err == nil {
+				up.Add(1)
+			}
+			slog.Info("warmup probe", "pool", s.Name, "backend", b.Url.String(), "up", err == nil)
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,64 @@
+package balancer
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWarmup(t *testing.T) {
+	ready := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ready"))
+	})
+	booting := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	release := make(chan struct{})
+	hanging := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	})
+	t.Cleanup(func() { close(release) })
+
+	tests := []struct {
+		name           string
+		backends       []string
+		requireHealthy bool
+		wantAlive      []bool
+		err            string
+		onlyReady      bool //whether requests must all reach the ready backend
+	}{
+		{"not ready backend down", []string{ready.URL, booting.URL}, false, []bool{true, false}, "", true},
+		{"none ready", []string{booting.URL}, false, []bool{false}, "", false},
+		{"none ready required", []string{booting.URL}, true, []bool{false}, "no backend passed its warmup probe", false},
+		{"pending assumed up", []string{ready.URL, hanging.URL}, true, []bool{true, true}, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := []PoolOption{WithHealthPath("/health")}
+			for _, u := range tt.backends {
+				opts = append(opts, WithBackend(u, 1))
+			}
+			pool := newTestPool(t, opts...)
+			l := newTestBalancer(Config{WarmupTimeout: 200 * time.Millisecond, WarmupRequireHealthy: tt.requireHealthy}, pool)
+
+			err := l.Warmup(context.Background())
+			if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("Warmup = %v, want %q", err, tt.err)
+			}
+			for i, b := range pool.Backends() {
+				if b.IsAlive() != tt.wantAlive[i] {
+					t.Errorf("backend %s alive = %v, want %v", b.Url, b.IsAlive(), tt.wantAlive[i])
+				}
+			}
+			for i := 0; tt.onlyReady && i < 4; i++ {
+				w := serve(pool.Handler(), httptest.NewRequest(http.MethodGet, "/", nil))
+				if w.Body.String() != "ready" {
+					t.Fatalf("request answered %d %q, want the ready backend", w.Code, w.Body)
+				}
+			}
+		})
+	}
+}
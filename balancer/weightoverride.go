@@ -0,0 +1,140 @@
+package balancer
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WeightOverrideHeader sets temporary backend weights, see WeightOverrides.
+const WeightOverrideHeader = "X-LB-Weight-Override"
+
+// WeightOverrides lets requests carrying the admin Token set the weights of
+// backends of their pool in the X-LB-Weight-Override header, e.g.
+// "http://10.0.0.1:8080=10", several separated by commas, to bias the
+// round-robin and weighted-least-connections strategies during a load test.
+// An override lasts TTL after the last request setting it, or until cleared
+// through /lb/weights, and is clamped between 1 and Max. The header is
+// ignored without an admin token.
+type WeightOverrides struct {
+	Token string
+	TTL   time.Duration
+	Max   int
+}
+
+// weightOverride is a weight set through WeightOverrides.
+type weightOverride struct {
+	weight int
+	until  time.Time
+}
+
+// weight returns the weight of b at now, its override if one is in effect.
+func (b *Backend) weight(now time.Time) int {
+	if o := b.weightOverride.Load(); o != nil && now.Before(o.until) {
+		return o.weight
+	}
+	return b.Weight
+}
+
+// applyWeightOverride sets the weights requested in the override header of r
+// if it carries the admin token. An invalid header gets a 400, and a backend
+// that is not in s a 421, with ok false. The caller must not hold s.mux.
+func (s *ServerPool) applyWeightOverride(w http.ResponseWriter, r *http.Request) (ok bool) {
+	header := r.Header.Get(WeightOverrideHeader)
+	p := s.policies.weightOverrides
+	if header == "" || p.Token == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get(AdminTokenHeader)), []byte(p.Token)) != 1 {
+		return true
+	}
+	type override struct {
+		backend *Backend
+		weight  int
+	}
+	var overrides []override
+	for _, item := range strings.Split(header, ",") {
+		i := strings.LastIndex(item, "=")
+		weight, err := strconv.Atoi(strings.TrimSpace(item[i+1:]))
+		if i < 0 || err != nil {
+			http.Error(w, "Invalid "+WeightOverrideHeader+" header: want <backend url>=<weight>, got "+strconv.Quote(item), http.StatusBadRequest)
+			return false
+		}
+		backendUrl, err := normalizeBackendUrl(strings.TrimSpace(item[:i]))
+		if err != nil {
+			http.Error(w, "Invalid "+WeightOverrideHeader+" header: "+err.Error(), http.StatusBadRequest)
+			return false
+		}
+		b := s.Backend(backendUrl)
+		if b == nil {
+			http.Error(w, "Backend "+backendUrl+" is not in pool "+s.Name, http.StatusMisdirectedRequest)
+			return false
+		}
+		overrides = append(overrides, override{b, min(max(weight, 1), p.Max)})
+	}
+	now := s.policies.clock.Now()
+	for _, o := range overrides {
+		prev := o.backend.weightOverride.Swap(&weightOverride{weight: o.weight, until: now.Add(p.TTL)})
+		if prev == nil || prev.weight != o.weight || !now.Before(prev.until) {
+			slog.InfoContext(r.Context(), "backend weight overridden", "pool", s.Name, "backend", o.backend.Url.String(), "weight", o.weight, "configured_weight", o.backend.Weight, "ttl", p.TTL)
+		}
+	}
+	return true
+}
+
+// weightOverrideStatus is an override in effect, as listed by /lb/weights.
+type weightOverrideStatus struct {
+	Pool   string    `json:"pool"`
+	Url    string    `json:"url"`
+	Weight int       `json:"weight"`
+	Until  time.Time `json:"until"`
+}
+
+// weightsHandler lists the weight overrides in effect (GET), or clears them
+// (DELETE), only those of the backend given by the url query parameter in
+// the pool named by the pool one if set.
+func (l *LoadBalancer) weightsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodDelete {
+		w.Header().Set("Allow", "GET, DELETE")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	pools := l.router.Pools()
+	var backendUrl string
+	if r.Method == http.MethodDelete && r.URL.Query().Has("url") {
+		pool := l.requestPool(w, r)
+		if pool == nil {
+			return
+		}
+		if backendUrl = requestBackendUrl(w, r); backendUrl == "" {
+			return
+		}
+		pools = []*ServerPool{pool}
+	}
+	now := l.policies.clock.Now()
+	statuses := []weightOverrideStatus{}
+	for _, pool := range pools {
+		for _, b := range pool.Backends() {
+			if backendUrl != "" && b.Url.String() != backendUrl {
+				continue
+			}
+			o := b.weightOverride.Load()
+			if o == nil || !now.Before(o.until) {
+				continue
+			}
+			if r.Method == http.MethodDelete {
+				b.weightOverride.CompareAndSwap(o, nil)
+				slog.Info("backend weight override cleared", "pool", pool.Name, "backend", b.Url.String(), "weight", b.Weight, "source", "admin")
+				continue
+			}
+			statuses = append(statuses, weightOverrideStatus{Pool: pool.Name, Url: b.Url.String(), Weight: o.weight, Until: o.until})
+		}
+	}
+	if r.Method == http.MethodDelete {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"overrides": statuses})
+}
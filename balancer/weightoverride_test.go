@@ -0,0 +1,190 @@
+package balancer
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// withAdminToken sets the admin token of the pool, with overrides lasting a
+// minute and capped at 10.
+func withAdminToken(token string) PoolOption {
+	return withSettings(func(cfg *Config) {
+		cfg.AdminToken = token
+		cfg.WeightOverrideTTL = time.Minute
+		cfg.WeightOverrideMax = 10
+	})
+}
+
+// overrideRequest returns a request setting the weight override header to
+// override, with the admin token if set.
+func overrideRequest(token, override string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(WeightOverrideHeader, override)
+	if token != "" {
+		r.Header.Set(AdminTokenHeader, token)
+	}
+	return r
+}
+
+func TestApplyWeightOverride(t *testing.T) {
+	const backend = "http://10.0.0.1:80"
+	tests := []struct {
+		name       string
+		configured string //admin token of the pool
+		token      string //admin token of the request
+		override   string
+		wantStatus int //0 when the request goes on
+		wantWeight int
+	}{
+		{"applied", "secret", "secret", backend + "=5", 0, 5},
+		{"clamped to 1", "secret", "secret", backend + "=0", 0, 1},
+		{"negative clamped to 1", "secret", "secret", backend + "=-3", 0, 1},
+		{"clamped to max", "secret", "secret", backend + "=1000", 0, 10},
+		{"no token", "secret", "", backend + "=5", 0, 1},
+		{"wrong token", "secret", "guess", backend + "=5", 0, 1},
+		{"no admin token configured", "", "", backend + "=5", 0, 1},
+		{"unnormalized url", "secret", "secret", " http://10.0.0.1:80/ = 5", 0, 5},
+		{"missing weight", "secret", "secret", backend, http.StatusBadRequest, 1},
+		{"invalid weight", "secret", "secret", backend + "=heavy", http.StatusBadRequest, 1},
+		{"unknown backend", "secret", "secret", "http://10.0.0.9:80=5", http.StatusMisdirectedRequest, 1},
+		{"partly invalid", "secret", "secret", backend + "=5,http://10.0.0.9:80=5", http.StatusMisdirectedRequest, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := newStrategyPool(t, RoundRobin, 2, withAdminToken(tt.configured))
+			w := httptest.NewRecorder()
+			ok := pool.applyWeightOverride(w, overrideRequest(tt.token, tt.override))
+			if ok != (tt.wantStatus == 0) {
+				t.Fatalf("applyWeightOverride = %v, want %v", ok, tt.wantStatus == 0)
+			}
+			if tt.wantStatus != 0 && w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			b := pool.Backend(backend)
+			if got := b.weight(pool.policies.clock.Now()); got != tt.wantWeight {
+				t.Errorf("weight = %d, want %d", got, tt.wantWeight)
+			}
+		})
+	}
+}
+
+func TestWeightOverrideExpires(t *testing.T) {
+	fc := newFakeClock()
+	pool := newStrategyPool(t, RoundRobin, 1, withAdminToken("secret"), WithPoolClock(fc))
+	b := pool.Backends()[0]
+	if !pool.applyWeightOverride(httptest.NewRecorder(), overrideRequest("secret", b.Url.String()+"=4")) {
+		t.Fatal("override rejected")
+	}
+	if got := b.weight(fc.Now()); got != 4 {
+		t.Fatalf("weight = %d, want 4", got)
+	}
+
+	//each request setting the override extends it
+	fc.Advance(30 * time.Second)
+	pool.applyWeightOverride(httptest.NewRecorder(), overrideRequest("secret", b.Url.String()+"=4"))
+	fc.Advance(59 * time.Second)
+	if got := b.weight(fc.Now()); got != 4 {
+		t.Errorf("weight %s after the last override = %d, want 4", 59*time.Second, got)
+	}
+	fc.Advance(time.Second)
+	if got := b.weight(fc.Now()); got != b.Weight {
+		t.Errorf("weight once the TTL passed = %d, want the configured %d", got, b.Weight)
+	}
+}
+
+func TestWeightOverrideDistribution(t *testing.T) {
+	pool := newStrategyPool(t, RoundRobin, 2, withAdminToken("secret"))
+	if !pool.applyWeightOverride(httptest.NewRecorder(), overrideRequest("secret", "http://10.0.0.1:80=3")) {
+		t.Fatal("override rejected")
+	}
+	counts := make([]int, 2)
+	for range 40 {
+		counts[nextPeer(t, pool)]++
+	}
+	if counts[0] != 30 || counts[1] != 10 {
+		t.Errorf("picks = %v, want [30 10] with weights 3 and 1", counts)
+	}
+}
+
+func TestWeightsHandler(t *testing.T) {
+	fc := newFakeClock()
+	pool, err := NewServerPool(defaultPool, WithBackend("http://10.0.0.1:80", 1), WithBackend("http://10.0.0.2:80", 1), withAdminToken("secret"), WithPoolClock(fc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := newTestBalancer(Config{}, pool)
+	override := func() {
+		t.Helper()
+		if !pool.applyWeightOverride(httptest.NewRecorder(), overrideRequest("secret", "http://10.0.0.1:80=5,http://10.0.0.2:80=7")) {
+			t.Fatal("override rejected")
+		}
+	}
+	list := func() []weightOverrideStatus {
+		t.Helper()
+		w := serve(http.HandlerFunc(l.weightsHandler), httptest.NewRequest(http.MethodGet, "/lb/weights", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET status = %d, want %d", w.Code, http.StatusOK)
+		}
+		var body struct {
+			Overrides []weightOverrideStatus `json:"overrides"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("decoding %s: %v", w.Body, err)
+		}
+		return body.Overrides
+	}
+	del := func(query string) int {
+		t.Helper()
+		return serve(http.HandlerFunc(l.weightsHandler), httptest.NewRequest(http.MethodDelete, "/lb/weights"+query, nil)).Code
+	}
+
+	if got := list(); len(got) != 0 {
+		t.Fatalf("overrides before any = %v, want none", got)
+	}
+	override()
+	got := list()
+	if len(got) != 2 || got[0].Url != "http://10.0.0.1:80" || got[0].Weight != 5 || got[1].Weight != 7 {
+		t.Fatalf("overrides = %+v, want 10.0.0.1 at 5 and 10.0.0.2 at 7", got)
+	}
+	if want := fc.Now().Add(time.Minute); !got[0].Until.Equal(want) || got[0].Pool != defaultPool {
+		t.Errorf("override = %+v, want pool %s until %s", got[0], defaultPool, want)
+	}
+
+	if code := del("?url=http://10.0.0.1:80"); code != http.StatusNoContent {
+		t.Fatalf("DELETE one status = %d, want %d", code, http.StatusNoContent)
+	}
+	if got := list(); len(got) != 1 || got[0].Url != "http://10.0.0.2:80" {
+		t.Fatalf("overrides after clearing 10.0.0.1 = %+v, want only 10.0.0.2", got)
+	}
+	if code := del(""); code != http.StatusNoContent {
+		t.Fatalf("DELETE all status = %d, want %d", code, http.StatusNoContent)
+	}
+	if got := list(); len(got) != 0 {
+		t.Fatalf("overrides after clearing all = %+v, want none", got)
+	}
+
+	override()
+	fc.Advance(time.Minute)
+	if got := list(); len(got) != 0 {
+		t.Errorf("overrides past the TTL = %+v, want none", got)
+	}
+
+	tests := []struct {
+		method string
+		target string
+		want   int
+	}{
+		{http.MethodPost, "/lb/weights", http.StatusMethodNotAllowed},
+		{http.MethodDelete, "/lb/weights?pool=missing&url=http://10.0.0.1:80", http.StatusNotFound},
+		{http.MethodDelete, "/lb/weights?url=", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		w := serve(http.HandlerFunc(l.weightsHandler), httptest.NewRequest(tt.method, tt.target, nil))
+		if w.Code != tt.want {
+			t.Errorf("%s %s status = %d, want %d", tt.method, tt.target, w.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"loadbalancer/balancer"
+)
+
+// flagValues holds the flags that are not Config fields as such.
+type flagValues struct {
+	config              string
+	validate            bool
+	backends            string
+	listen              string
+	trustedProxies      string
+	passiveHeaderValues string
+	compressTypes       string
+}
+
+// defineFlags defines the command line flags on fs, storing their values in
+// cfg and v, which get the defaults of balancer.DefaultConfig.
+func defineFlags(fs *flag.FlagSet, cfg *balancer.Config, v *flagValues) {
+	def := balancer.DefaultConfig()
+	fs.StringVar(&v.config, "config", "", "YAML or JSON config file, reloaded on SIGHUP; environment variables and flags take precedence over its settings")
+	fs.BoolVar(&v.validate, "validate", false, "Load and validate the configuration, print its errors one per line and exit, with status 1 if there are any, without serving")
+	fs.StringVar(&v.backends, "backends", "", "Load balanced backends, use commas to separate; append #N to set a weight, e.g. http://host:port#3")
+	fs.IntVar(&cfg.Port, "port", def.Port, "Port to serve on, unless -listen or -bind with a port is set")
+	fs.StringVar(&cfg.Bind, "bind", "", "Host or host:port to serve on, e.g. 127.0.0.1; all interfaces if empty, and also the host of -listen addresses without one")
+	fs.StringVar(&v.listen, "listen", "", "Comma separated addresses to serve on, e.g. 10.0.0.1:80,:8080; overrides -port")
+	fs.StringVar(&cfg.Strategy, "strategy", def.Strategy, "Load balancing strategy: round-robin, least-connections, weighted-least-connections, ip-hash, least-time, consistent-hash or p2c")
+	fs.StringVar(&cfg.HealthPath, "health-path", "", "HTTP path to probe for health checks, empty for a plain TCP dial")
+	fs.StringVar(&cfg.HealthStatus, "health-status", def.HealthStatus, "Status code or range treated as healthy by HTTP health checks")
+	fs.IntVar(&cfg.HealthRise, "health-rise", def.HealthRise, "Consecutive passed health checks that bring a down backend back up")
+	fs.IntVar(&cfg.HealthFall, "health-fall", def.HealthFall, "Consecutive failed health checks that mark a backend down")
+	fs.IntVar(&cfg.HealthConcurrency, "health-concurrency", def.HealthConcurrency, "Backends of a pool probed at once by a health check")
+	fs.DurationVar(&cfg.WarmupTimeout, "warmup-timeout", 0, "Longest wait at startup for a first probe of every backend, which marks each up or down before traffic is accepted; 0 to assume every backend up until the first health check")
+	fs.BoolVar(&cfg.WarmupRequireHealthy, "warmup-require-healthy", false, "Exit at startup unless a backend passed its -warmup-timeout probe")
+	fs.DurationVar(&cfg.HealthInterval, "health-interval", def.HealthInterval, "Interval between health checks")
+	fs.DurationVar(&cfg.HealthBackoffMax, "health-backoff-max", 0, "Longest interval between probes of a backend that stays down, which grows by -health-backoff-factor with each failed probe; 0 to always probe every -health-interval")
+	fs.Float64Var(&cfg.HealthBackoffFactor, "health-backoff-factor", def.HealthBackoffFactor, "Factor by which the interval between probes of a backend that stays down grows, up to -health-backoff-max")
+	fs.StringVar(&cfg.StatsPath, "stats-path", def.StatsPath, "Path serving backend stats as JSON, empty to disable")
+	fs.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", def.ShutdownTimeout, "Grace period for in-flight requests on shutdown")
+	fs.StringVar(&cfg.MetricsPath, "metrics-path", def.MetricsPath, "Path serving Prometheus metrics, empty to disable")
+	fs.StringVar(&cfg.StickyCookie, "sticky-cookie", "", "Cookie name used to pin clients to a backend, empty to disable session affinity")
+	fs.DurationVar(&cfg.StickyTTL, "sticky-ttl", def.StickyTTL, "Lifetime of the session affinity cookie, 0 for a session cookie")
+	fs.BoolVar(&cfg.TrustForwardedFor, "trust-forwarded-for", false, "Deprecated, same as -trusted-proxies 0.0.0.0/0,::/0")
+	fs.BoolVar(&cfg.NoRetry, "no-retry", false, "Never retry the same backend after a proxy error: mark it down and fail over once, answering with -bad-gateway-status if that fails too")
+	fs.IntVar(&cfg.MaxRetries, "max-retries", def.MaxRetries, "Retries against the same backend after a proxy error, before failing over")
+	fs.BoolVar(&cfg.RetryNonIdempotent, "retry-non-idempotent", false, "Also retry and fail over POST and PATCH requests; a backend may have applied them before failing, so replays can duplicate writes")
+	fs.Int64Var(&cfg.RetryMaxBody, "retry-max-body", def.RetryMaxBody, "Largest request body buffered so that the request can be retried or failed over, 0 to never replay requests with a body")
+	fs.IntVar(&cfg.MaxAttempts, "max-attempts", def.MaxAttempts, "Failovers to a different backend once retries are exhausted, before giving up")
+	fs.Float64Var(&cfg.RetryBudgetRatio, "retry-budget", 0, "Cap retries against the same backend at this fraction of requests over -retry-budget-window, e.g. 0.2; 0 for no budget")
+	fs.DurationVar(&cfg.RetryBudgetWindow, "retry-budget-window", def.RetryBudgetWindow, "Sliding window of the retry budget")
+	fs.BoolVar(&cfg.FailFast, "fail-fast", false, "Cancel replayable requests still waiting on a backend when it is marked down so they fail over at once; a cancelled PUT or DELETE may then be applied twice")
+	fs.DurationVar(&cfg.RetryBackoff, "retry-backoff", def.RetryBackoff, "Delay before retrying the same backend, the first one for exponential strategies")
+	fs.DurationVar(&cfg.RetryBackoffMax, "retry-backoff-max", def.RetryBackoffMax, "Longest delay before a retry with an exponential -retry-backoff-strategy")
+	fs.StringVar(&cfg.RetryBackoffStrategy, "retry-backoff-strategy", def.RetryBackoffStrategy, "Growth of the delay between retries: fixed, exponential or exponential-jitter")
+	fs.IntVar(&cfg.BreakerFailures, "breaker-failures", def.BreakerFailures, "Consecutive proxy errors or 5xx responses that open a backend's circuit breaker, 0 to disable")
+	fs.DurationVar(&cfg.BreakerCooldown, "breaker-cooldown", def.BreakerCooldown, "Time an open circuit breaker waits before letting a trial request through")
+	fs.StringVar(&cfg.TLSCert, "tls-cert", "", "TLS certificate file, enables HTTPS together with -tls-key; both are reloaded on SIGHUP")
+	fs.StringVar(&cfg.TLSKey, "tls-key", "", "TLS private key file")
+	fs.IntVar(&cfg.HTTPRedirectPort, "http-redirect-port", 0, "Port redirecting plain HTTP to HTTPS when TLS is enabled, 0 to disable")
+	fs.BoolVar(&cfg.ProxyProtocol, "proxy-protocol", false, "Expect a PROXY protocol v1 or v2 header, as sent by an L4 load balancer, on every connection to the -listen addresses and take the client address from it; connections without one are closed")
+	fs.BoolVar(&cfg.ClientH2C, "client-h2c", false, "Accept HTTP/2 without TLS from clients using prior knowledge; on by default when a backend is h2c")
+	fs.BoolVar(&cfg.DisableHTTP2, "disable-http2", false, "Only speak HTTP/1.1 to clients, also over TLS")
+	fs.Float64Var(&cfg.ErrorWeightSensitivity, "error-weight-sensitivity", 0, "Scale round-robin weights down by this factor, up to 1, times each backend's recent error rate; 0 to disable")
+	fs.IntVar(&cfg.ErrorWeightWindow, "error-weight-window", def.ErrorWeightWindow, "Number of recent requests the error rate of -error-weight-sensitivity averages over")
+	fs.DurationVar(&cfg.OutlierInterval, "outlier-interval", 0, "Interval over which backends are compared to eject outliers, 0 to disable outlier detection")
+	fs.Float64Var(&cfg.OutlierErrorRate, "outlier-error-rate", 0, "Eject a backend whose error rate exceeds the pool median by this much, e.g. 0.2; 0 to disable")
+	fs.Float64Var(&cfg.OutlierLatencyFactor, "outlier-latency-factor", 0, "Eject a backend whose mean latency exceeds the pool median by this factor, e.g. 3; 0 to disable")
+	fs.IntVar(&cfg.OutlierMinRequests, "outlier-min-requests", def.OutlierMinRequests, "Requests a backend needs in an -outlier-interval to be compared")
+	fs.DurationVar(&cfg.OutlierBaseEjection, "outlier-base-ejection", def.OutlierBaseEjection, "Time an outlier is ejected, multiplied by the number of times in a row it has been")
+	fs.DurationVar(&cfg.OutlierMaxEjection, "outlier-max-ejection", def.OutlierMaxEjection, "Longest time an outlier is ejected")
+	fs.IntVar(&cfg.OutlierMaxEjectedPercent, "outlier-max-ejected-percent", def.OutlierMaxEjectedPercent, "Percentage of the backends of a pool that may be ejected at once, at least one")
+	fs.IntVar(&cfg.PassiveFailures, "passive-failures", def.PassiveFailures, "Consecutive failed live requests that mark a backend down before the next health check, 0 to disable")
+	fs.BoolVar(&cfg.Passive5xx, "passive-5xx", def.Passive5xx, "Count 5xx responses as failures for passive health checking")
+	fs.StringVar(&cfg.PassiveHeader, "passive-header", "", "Response header through which backends report being degraded, e.g. X-Health; empty to disable")
+	fs.StringVar(&v.passiveHeaderValues, "passive-header-values", "", "Comma separated values of -passive-header that count as a failed request, e.g. degraded,unhealthy")
+	fs.StringVar(&cfg.LogLevel, "log-level", def.LogLevel, "Log level: debug, info, warn or error")
+	fs.StringVar(&cfg.LogFormat, "log-format", def.LogFormat, "Log format: text or json")
+	fs.IntVar(&cfg.LatencyWindow, "latency-window", def.LatencyWindow, "Number of recent requests per backend used for latency stats and the least-time strategy")
+	fs.StringVar(&cfg.AdminToken, "admin-token", "", "Shared secret for the admin API, sent in the "+balancer.AdminTokenHeader+" header; empty disables the API")
+	fs.DurationVar(&cfg.WeightOverrideTTL, "weight-override-ttl", def.WeightOverrideTTL, "Time a weight set with the admin token in the "+balancer.WeightOverrideHeader+" header, e.g. http://host:port=10, lasts after the last request setting it; DELETE /lb/weights clears them")
+	fs.IntVar(&cfg.WeightOverrideMax, "weight-override-max", def.WeightOverrideMax, "Highest weight "+balancer.WeightOverrideHeader+" may set, larger ones are capped")
+	fs.BoolVar(&cfg.BackendOverride, "backend-override", false, "Let clients pick the backend by URL in the "+balancer.BackendOverrideHeader+" header, for testing canaries; never enable for untrusted clients")
+	fs.DurationVar(&cfg.DialTimeout, "dial-timeout", def.DialTimeout, "Timeout for connecting to a backend")
+	fs.DurationVar(&cfg.ResponseHeaderTimeout, "response-header-timeout", def.ResponseHeaderTimeout, "Timeout for a backend to send response headers, 0 for none")
+	fs.DurationVar(&cfg.RequestTimeout, "request-timeout", def.RequestTimeout, "Timeout for a whole attempt against one backend including the body, 0 for none")
+	fs.DurationVar(&cfg.TotalTimeout, "total-timeout", def.TotalTimeout, "Timeout for a request across all retries and failovers, answered with 504; 0 for none")
+	fs.IntVar(&cfg.MaxIdleConns, "max-idle-conns", def.MaxIdleConns, "Idle connections kept open for reuse across the backends of a pool, 0 for no limit")
+	fs.IntVar(&cfg.MaxIdleConnsPerHost, "max-idle-conns-per-host", def.MaxIdleConnsPerHost, "Idle connections kept open for reuse per backend, best no lower than max_connections")
+	fs.DurationVar(&cfg.IdleConnTimeout, "idle-conn-timeout", def.IdleConnTimeout, "Time an unused connection to a backend is kept open, 0 for no limit")
+	fs.BoolVar(&cfg.IsolateTransports, "isolate-transports", false, "Give every backend its own transport and idle connections instead of sharing those of its pool")
+	fs.Float64Var(&cfg.RateLimit, "rate-limit", 0, "Requests per second allowed per client IP, 0 to disable rate limiting")
+	fs.IntVar(&cfg.RateBurst, "rate-burst", 0, "Requests a client IP may burst above -rate-limit, 0 for the rate rounded up")
+	fs.DurationVar(&cfg.ReapInterval, "reap-interval", def.ReapInterval, "Interval at which idle rate limit buckets and the transports of removed backends are evicted")
+	fs.DurationVar(&cfg.StateTTL, "state-ttl", 0, "Time a client's rate limit bucket may stay idle before it is evicted, 0 for the time it takes to refill; shorter lets quiet clients start over with a full bucket")
+	fs.IntVar(&cfg.MaxConcurrent, "max-concurrent", 0, "Requests proxied at once across all pools, further ones get a 503; 0 for no limit")
+	fs.DurationVar(&cfg.MaxConcurrentWait, "max-concurrent-wait", 0, "Time a request over -max-concurrent waits for a slot before it gets a 503, 0 to refuse it right away")
+	fs.DurationVar(&cfg.QueueWait, "queue-wait", 0, "Time a request waits for a backend to get below max_connections when every available one is at it, before it gets a 503; 0 to refuse it right away")
+	fs.StringVar(&cfg.MirrorUrl, "mirror-url", "", "Backend to copy requests to in the background, discarding its responses, e.g. a new version under test; empty to disable mirroring")
+	fs.Float64Var(&cfg.MirrorPercent, "mirror-percent", def.MirrorPercent, "Percentage of requests copied to -mirror-url")
+	fs.Int64Var(&cfg.MirrorMaxBody, "mirror-max-body", def.MirrorMaxBody, "Largest request body in bytes buffered to be mirrored, larger requests are not")
+	fs.IntVar(&cfg.MirrorMaxInFlight, "mirror-max-in-flight", def.MirrorMaxInFlight, "Mirrored requests pending at once, further ones are not mirrored")
+	fs.BoolVar(&cfg.PreserveHost, "preserve-host", def.PreserveHost, "Send backends the Host header of the client; with false, the host of the backend URL")
+	fs.StringVar(&cfg.HostOverride, "host-override", "", "Host header sent to backends instead of the client's or their own, empty to disable")
+	fs.BoolVar(&cfg.ForwardedHeaders, "forwarded-headers", def.ForwardedHeaders, "Send X-Forwarded-For, X-Forwarded-Host and X-Forwarded-Proto to backends")
+	fs.StringVar(&v.trustedProxies, "trusted-proxies", "", "Comma separated CIDR ranges or addresses of proxies trusted to report the client address in X-Forwarded-For")
+	fs.StringVar(&cfg.HashKey, "hash-key", def.HashKey, "Request key of the consistent-hash strategy: path or header:<name>")
+	fs.IntVar(&cfg.HashVNodes, "hash-vnodes", def.HashVNodes, "Virtual nodes per unit of weight on the consistent-hash ring")
+	fs.StringVar(&cfg.DNSDiscovery, "dns-discovery", "", "URL whose host name is resolved periodically to discover backends of the default pool, e.g. http://backend.svc:8080")
+	fs.BoolVar(&cfg.DNSSRV, "dns-srv", false, "Resolve SRV records for -dns-discovery instead of A records, taking ports from the records")
+	fs.DurationVar(&cfg.DNSInterval, "dns-interval", def.DNSInterval, "Interval between DNS discovery lookups")
+	fs.StringVar(&cfg.LivezPath, "livez-path", def.LivezPath, "Path of the liveness probe, empty to disable")
+	fs.StringVar(&cfg.ReadyzPath, "readyz-path", def.ReadyzPath, "Path of the readiness probe, 503 while no backend is alive; empty to disable")
+	fs.StringVar(&cfg.VersionPath, "version-path", def.VersionPath, "Path serving the build version as JSON, empty to disable")
+	fs.BoolVar(&cfg.VersionDetails, "version-details", def.VersionDetails, "Include the commit, build date and Go version at -version-path, not just the version")
+	fs.IntVar(&cfg.ProxyBufferSize, "proxy-buffer-size", def.ProxyBufferSize, "Size in bytes of the pooled buffers used to copy bodies between clients and backends")
+	fs.StringVar(&cfg.RequestIDHeader, "request-id-header", def.RequestIDHeader, "Header carrying the request ID sent to backends, echoed to clients and logged; empty to disable")
+	fs.BoolVar(&cfg.TrustRequestID, "trust-request-id", def.TrustRequestID, "Keep a request ID sent by the client instead of always generating a new one")
+	fs.StringVar(&cfg.AccessLog, "access-log", "", "Write a Combined Log Format access log to this file, - for stdout; empty to disable")
+	fs.IntVar(&cfg.MaxHeaderBytes, "max-header-bytes", def.MaxHeaderBytes, "Answer requests whose request line and headers, cookies included, exceed about this many bytes with 431 before they reach a backend; raise it if clients send large cookies")
+	fs.BoolVar(&cfg.Compress, "compress", false, "Gzip responses of the backends to clients that accept it, see -compress-types and -compress-min-size")
+	//like the defaults of the flags bound to cfg, overridden by the config file
+	cfg.CompressTypes = def.CompressTypes
+	fs.StringVar(&v.compressTypes, "compress-types", "", "Comma separated content types gzipped by -compress, type/* for every subtype; "+strings.Join(def.CompressTypes, ",")+" if empty")
+	fs.IntVar(&cfg.CompressMinSize, "compress-min-size", def.CompressMinSize, "Smallest response body in bytes gzipped by -compress")
+	fs.Int64Var(&cfg.MaxRequestBytes, "max-request-bytes", 0, "Answer requests whose body exceeds this many bytes with 413, 0 for no limit")
+	fs.Int64Var(&cfg.MaxResponseBytes, "max-response-bytes", 0, "Cut responses whose body exceeds this many bytes, 0 for no limit")
+	fs.IntVar(&cfg.UnavailableStatus, "unavailable-status", def.UnavailableStatus, "Status code sent when no backend is available, the pool being empty or every backend down")
+	fs.IntVar(&cfg.BadGatewayStatus, "bad-gateway-status", def.BadGatewayStatus, "Status code sent when backends were tried but failed the request after every retry and failover")
+	fs.StringVar(&cfg.UnavailableBodyFile, "unavailable-page", "", "File with the body sent when no backend can serve a request, e.g. an HTML page; empty for plain text")
+	fs.DurationVar(&cfg.UnavailableRetryAfter, "unavailable-retry-after", 0, "Retry-After sent when no backend can serve a request, 0 to omit it")
+	fs.DurationVar(&cfg.DrainTimeout, "drain-timeout", 0, "Time a backend removed by a reload, discovery or the admin API has to finish its requests before they are cancelled, 0 for no limit")
+	fs.DurationVar(&cfg.DrainDelay, "drain-delay", 0, "Time between entering drain, via POST /lb/drain or a shutdown signal, and refusing new requests, while /readyz already fails")
+	fs.DurationVar(&cfg.SlowStart, "slow-start", 0, "Time a backend that came back up takes to ramp up to its full round-robin weight, 0 to disable")
+	fs.BoolVar(&cfg.Tracing, "tracing", false, "Export OpenTelemetry traces over OTLP/HTTP, configured by the OTEL_EXPORTER_OTLP_* environment variables")
+}
+
+// apply moves the comma separated lists of v into cfg, unless they are unset.
+func (v flagValues) apply(cfg *balancer.Config) error {
+	if v.backends != "" {
+		backends, err := parseBackendList(v.backends)
+		if err != nil {
+			return fmt.Errorf("backends: %w", err)
+		}
+		cfg.Backends = backends
+	}
+	if v.listen != "" {
+		cfg.Listen = strings.Split(v.listen, ",")
+	}
+	if v.trustedProxies != "" {
+		cfg.TrustedProxies = strings.Split(v.trustedProxies, ",")
+	}
+	if v.passiveHeaderValues != "" {
+		cfg.PassiveHeaderValues = strings.Split(v.passiveHeaderValues, ",")
+	}
+	if v.compressTypes != "" {
+		cfg.CompressTypes = strings.Split(v.compressTypes, ",")
+	}
+	return nil
+}
+
+// configSources are the defaults and the settings given through the
+// environment and the command line, from which the configuration is loaded
+// at startup and again on every reload, together with the config file.
+// Settings are taken, from lowest to highest precedence, from the defaults,
+// the config file, LB_* environment variables named after the flags, such as
+// LB_PORT for -port or LB_HEALTH_INTERVAL for -health-interval, and the
+// flags given on the command line. BACKENDS is read as well as LB_BACKENDS.
+type configSources struct {
+	defaults      balancer.Config
+	defaultValues flagValues
+	env           map[string]string
+	flags         map[string]string
+}
+
+// newConfigSources defines the flags on fs, which must not be parsed yet, and
+// reads the environment variables matching them.
+func newConfigSources(fs *flag.FlagSet) *configSources {
+	s := &configSources{env: map[string]string{}, flags: map[string]string{}}
+	defineFlags(fs, &s.defaults, &s.defaultValues)
+	fs.VisitAll(func(f *flag.Flag) {
+		if value, ok := os.LookupEnv(envName(f.Name)); ok {
+			s.env[f.Name] = value
+		}
+	})
+	if _, ok := s.env["backends"]; !ok {
+		if value, ok := os.LookupEnv("BACKENDS"); ok {
+			s.env["backends"] = value
+		}
+	}
+	return s
+}
+
+// envName returns the environment variable of a flag.
+func envName(flag string) string {
+	return "LB_" + strings.ToUpper(strings.ReplaceAll(flag, "-", "_"))
+}
+
+// visit records the flags set on the parsed fs.
+func (s *configSources) visit(fs *flag.FlagSet) {
+	fs.Visit(func(f *flag.Flag) {
+		s.flags[f.Name] = f.Value.String()
+	})
+}
+
+func (s *configSources) configPath() string {
+	if path, ok := s.flags["config"]; ok {
+		return path
+	}
+	return s.env["config"]
+}
+
+// validateOnly reports whether -validate, or LB_VALIDATE, was given.
+func (s *configSources) validateOnly() bool {
+	value, ok := s.flags["validate"]
+	if !ok {
+		value = s.env["validate"]
+	}
+	validate, _ := strconv.ParseBool(value)
+	return validate
+}
+
+// load builds the configuration from the defaults, the config file, the
+// environment and the flags, each overriding the ones before.
+func (s *configSources) load() (balancer.Config, error) {
+	var cfg balancer.Config
+	var v flagValues
+	//flags bound to cfg parse the overrides the same way as the command line
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	defineFlags(fs, &cfg, &v)
+	if path := s.configPath(); path != "" {
+		if err := cfg.LoadFile(path); err != nil {
+			return cfg, err
+		}
+	}
+	for name, value := range s.env {
+		if err := fs.Set(name, value); err != nil {
+			return cfg, fmt.Errorf("%s: invalid value %q: %w", envName(name), value, err)
+		}
+	}
+	for name, value := range s.flags {
+		if err := fs.Set(name, value); err != nil {
+			return cfg, fmt.Errorf("-%s: invalid value %q: %w", name, value, err)
+		}
+	}
+	if err := v.apply(&cfg); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"loadbalancer/balancer"
+)
+
+func TestConfigPrecedence(t *testing.T) {
+	def := balancer.DefaultConfig()
+	file := filepath.Join(t.TempDir(), "lb.yaml")
+	if err := os.WriteFile(file, []byte("port: 8001\nstrategy: ip-hash\nmax_retries: 7\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		env        map[string]string
+		args       []string
+		port       int
+		strategy   string
+		maxRetries int
+	}{
+		{"defaults", nil, nil, def.Port, def.Strategy, def.MaxRetries},
+		{"file over defaults", nil, []string{"-config", file}, 8001, "ip-hash", 7},
+		{"env over file", map[string]string{"LB_PORT": "8002", "LB_STRATEGY": "p2c"}, []string{"-config", file}, 8002, "p2c", 7},
+		{"flags over env", map[string]string{"LB_PORT": "8002", "LB_STRATEGY": "p2c"}, []string{"-config", file, "-port", "8003"}, 8003, "p2c", 7},
+		{"config path from env", map[string]string{"LB_CONFIG": file}, []string{"-strategy", "least-time"}, 8001, "least-time", 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			fs := flag.NewFlagSet("lb", flag.ContinueOnError)
+			sources := newConfigSources(fs)
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			sources.visit(fs)
+			cfg, err := sources.load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Port != tt.port || cfg.Strategy != tt.strategy || cfg.MaxRetries != tt.maxRetries {
+				t.Errorf("port, strategy, max retries = %d, %s, %d, want %d, %s, %d", cfg.Port, cfg.Strategy, cfg.MaxRetries, tt.port, tt.strategy, tt.maxRetries)
+			}
+		})
+	}
+}
+
+func TestConfigBackendsEnv(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want string
+	}{
+		{"BACKENDS", map[string]string{"BACKENDS": "http://10.0.0.1:80"}, "http://10.0.0.1:80"},
+		{"LB_BACKENDS over BACKENDS", map[string]string{"BACKENDS": "http://10.0.0.1:80", "LB_BACKENDS": "http://10.0.0.2:80"}, "http://10.0.0.2:80"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			fs := flag.NewFlagSet("lb", flag.ContinueOnError)
+			sources := newConfigSources(fs)
+			fs.Parse(nil)
+			sources.visit(fs)
+			cfg, err := sources.load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(cfg.Backends) != 1 || cfg.Backends[0].Url != tt.want {
+				t.Errorf("backends = %v, want %s", cfg.Backends, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateOnly(t *testing.T) {
+	tests := []struct {
+		name string
+		env  string //LB_VALIDATE, unset if empty
+		args []string
+		want bool
+	}{
+		{"unset", "", nil, false},
+		{"flag", "", []string{"-validate"}, true},
+		{"env", "true", nil, true},
+		{"env false", "0", nil, false},
+		{"flag over env", "true", []string{"-validate=false"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env != "" {
+				t.Setenv("LB_VALIDATE", tt.env)
+			}
+			fs := flag.NewFlagSet("lb", flag.ContinueOnError)
+			sources := newConfigSources(fs)
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			sources.visit(fs)
+			if got := sources.validateOnly(); got != tt.want {
+				t.Errorf("validateOnly = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -4,124 +4,242 @@ import (
 	"context"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
-	"net/http/httputil"
 	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
-)
-
-var serverPool ServerPool
 
-const (
-	Attempts int = iota
-	Retry
+	"loadbalancer/balancer"
 )
 
 func main() {
-	var serverList string
-	var port int
-	flag.StringVar(&serverList, "backends", "", "Load balanced backends, use commas to separate")
-	flag.IntVar(&port, "port", 3030, "Port to serve")
+	sources := newConfigSources(flag.CommandLine)
 	flag.Parse()
-
-	if len(serverList) == 0 {
-		log.Fatal("Please provider one or more backends to load balance")
+	sources.visit(flag.CommandLine)
+	cfg, err := sources.load()
+	if sources.validateOnly() {
+		validateConfig(cfg, err)
+		return
+	}
+	if err != nil {
+		fatal("cannot load config", "error", err)
+	}
+	configPath := sources.configPath()
+	if logger, err := balancer.NewLogger(cfg.LogLevel, cfg.LogFormat); err == nil {
+		slog.SetDefault(logger)
+	}
+	build := buildInfo()
+	slog.Info("starting load balancer", "version", build.Version, "commit", build.Commit, "build_date", build.BuildDate, "go_version", build.GoVersion)
+	versionDetails = cfg.VersionDetails
+	var opts []balancer.Option
+	if cfg.VersionPath != "" {
+		opts = append(opts, balancer.WithEndpoint(cfg.VersionPath, http.HandlerFunc(versionHandler)))
+	}
+	l, err := balancer.New(cfg, opts...)
+	if err != nil {
+		fatal("invalid configuration", "error", err)
 	}
 
-	servers := strings.Split(serverList, ",")
-	for _, s := range servers {
-		serverUrl, err := url.Parse(s)
+	//bind every address up front so a taken port fails before serving
+	useTLS := cfg.TLSCert != ""
+	var cert *certificate
+	if useTLS {
+		if cert, err = loadCertificate(cfg.TLSCert, cfg.TLSKey); err != nil {
+			fatal("cannot load TLS certificate", "cert", cfg.TLSCert, "error", err)
+		}
+	}
+	listen := func(addr string) net.Listener {
+		ln, err := net.Listen("tcp", addr)
 		if err != nil {
-			log.Fatal(err)
+			fatal("cannot listen", "addr", addr, "error", err)
 		}
-		proxy := httputil.NewSingleHostReverseProxy(serverUrl)
-		proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, e error) {
-			log.Printf("[%s] %s\n", serverUrl.Host, e.Error())
-			retries := GetRetryFromContext(r)
-			if retries < 3 {
-				select {
-				case <-time.After(10 * time.Millisecond):
-					ctx := context.WithValue(r.Context(), Retry, retries+1)
-					proxy.ServeHTTP(w, r.WithContext(ctx))
-				}
-			}
-
-			serverPool.MarkBackendStatus(serverUrl, false)
-			attemps := GetAttemptsFromContext(r)
-			log.Printf("%s(%s) Attemping retry %d\n", r.RemoteAddr, r.URL.Path, attemps)
-			ctx := context.WithValue(r.Context(), Attempts, attemps+1)
-
-			lb(w, r.WithContext(ctx))
+		if cfg.ProxyProtocol {
+			ln = proxyListener{ln}
 		}
-
-		serverPool.AddBackend(&Backend{
-			Url:          serverUrl,
-			Alive:        true,
-			ReverseProxy: proxy,
-		})
-		log.Printf("Configured server: %s\n", serverUrl)
+		return ln
 	}
-
-	//create http server
-	server := http.Server{
-		Addr:    fmt.Sprintf(":%d", port),
-		Handler: http.HandlerFunc(lb),
+	var servers []*http.Server
+	var listeners []net.Listener
+	for _, addr := range cfg.ListenAddrs() {
+		ln := listen(addr)
+		server := &http.Server{
+			Addr:           addr,
+			Handler:        l,
+			MaxHeaderBytes: cfg.MaxHeaderBytes,
+		}
+		server.Protocols = cfg.ServerProtocols()
+		if useTLS {
+			server.TLSConfig = serverTLSConfig(cert)
+		}
+		servers = append(servers, server)
+		listeners = append(listeners, ln)
 	}
-
-	go healthCheck()
-
-	log.Printf("Load balancer start at : %d\n", port)
-	if err := server.ListenAndServe(); err != nil {
-		log.Fatal(err)
+	var redirect *http.Server
+	var redirectLn net.Listener
+	if useTLS && cfg.HTTPRedirectPort > 0 {
+		redirectLn = listen(cfg.RedirectAddr())
+		redirect = &http.Server{
+			Addr:           cfg.RedirectAddr(),
+			Handler:        redirectToHTTPS(listenPort(listeners[0])),
+			MaxHeaderBytes: cfg.MaxHeaderBytes,
+		}
 	}
-}
 
-func GetRetryFromContext(r *http.Request) int {
-	if retry, ok := r.Context().Value(Retry).(int); ok {
-		return retry
+	ctx, stopHealthCheck := context.WithCancel(context.Background())
+	if err := l.Warmup(ctx); err != nil {
+		fatal("warmup failed", "error", err)
+	}
+	healthDone := make(chan struct{})
+	go func() {
+		l.Run(ctx)
+		close(healthDone)
+	}()
+
+	for i, server := range servers {
+		go func(srv *http.Server, ln net.Listener) {
+			slog.Info("load balancer started", "addr", ln.Addr().String(), "tls", useTLS, "proxy_protocol", cfg.ProxyProtocol)
+			var err error
+			if useTLS {
+				err = srv.ServeTLS(ln, "", "")
+			} else {
+				err = srv.Serve(ln)
+			}
+			if err != nil && err != http.ErrServerClosed {
+				fatal("listener failed", "addr", ln.Addr().String(), "error", err)
+			}
+		}(server, listeners[i])
+	}
+	if redirect != nil {
+		servers = append(servers, redirect)
+		go func() {
+			slog.Info("redirecting plain HTTP to HTTPS", "addr", redirectLn.Addr().String(), "proxy_protocol", cfg.ProxyProtocol)
+			if err := redirect.Serve(redirectLn); err != nil && err != http.ErrServerClosed {
+				fatal("redirect listener failed", "addr", redirectLn.Addr().String(), "error", err)
+			}
+		}()
 	}
-	return 0
-}
 
-func GetAttemptsFromContext(r *http.Request) int {
-	if attempts, ok := r.Context().Value(Attempts).(int); ok {
-		return attempts
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	var received os.Signal
+	for received == nil {
+		select {
+		case received = <-sig:
+		case <-hup:
+			if cert != nil {
+				if err := cert.reload(); err != nil {
+					slog.Error("cannot reload TLS certificate, keeping current one", "cert", cfg.TLSCert, "error", err)
+				}
+			}
+			if configPath == "" {
+				if cert == nil {
+					slog.Warn("received SIGHUP but no -config file to reload")
+				}
+				continue
+			}
+			slog.Info("reloading configuration", "path", configPath)
+			cfg, err := sources.load()
+			if err == nil {
+				err = l.Reload(cfg)
+			}
+			if err != nil {
+				slog.Error("reload failed, keeping current configuration", "path", configPath, "error", err)
+			}
+		}
+	}
+	//give the upstream balancer time to notice /readyz failing first
+	if refuseAt := l.Drain(); time.Until(refuseAt) > 0 {
+		slog.Info("waiting for drain before shutting down", "until", refuseAt)
+		time.Sleep(time.Until(refuseAt))
+	}
+	slog.Info("shutting down", "signal", received.String(), "active_connections", l.ActiveConnections())
+
+	stopHealthCheck()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	for _, srv := range servers {
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			slog.Warn("shutdown did not complete cleanly", "addr", srv.Addr, "error", err)
+		}
 	}
-	return 0
+	<-healthDone
+	if err := l.Close(shutdownCtx); err != nil {
+		slog.Warn("cannot flush traces", "error", err)
+	}
+	slog.Info("load balancer stopped")
 }
 
-func lb(w http.ResponseWriter, r *http.Request) {
-	peer := serverPool.GetNextPeer()
-	if peer != nil {
-		peer.ReverseProxy.ServeHTTP(w, r)
+// validateConfig prints the errors of cfg, as loaded with err, one per line,
+// or that it is valid, and exits with status 1 if there are errors.
+func validateConfig(cfg balancer.Config, err error) {
+	if err == nil {
+		err = balancer.Check(cfg)
+	}
+	if err == nil && cfg.TLSCert != "" {
+		if _, certErr := loadCertificate(cfg.TLSCert, cfg.TLSKey); certErr != nil {
+			err = fmt.Errorf("tls_cert: %w", certErr)
+		}
+	}
+	if err == nil {
+		fmt.Println("configuration is valid")
 		return
 	}
-	http.Error(w, "Service not available", http.StatusServiceUnavailable)
+	for _, line := range errorLines(err) {
+		fmt.Fprintln(os.Stderr, line)
+	}
+	os.Exit(1)
 }
 
-func isBackendAlive(u *url.URL) bool {
-	timeout := 2 * time.Second
-	conn, err := net.DialTimeout("tcp", u.Host, timeout)
-	if err != nil {
-		log.Println("Site unreachable, error:", err)
-		return false
+// errorLines returns the errors joined in err one per line, folding the
+// lines of an error spanning several, such as a YAML error, into one.
+func errorLines(err error) []string {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		var lines []string
+		for _, e := range joined.Unwrap() {
+			lines = append(lines, errorLines(e)...)
+		}
+		return lines
 	}
-	defer conn.Close()
-	return true
+	lines := strings.Split(err.Error(), "\n")
+	for i := range lines {
+		lines[i] = strings.TrimSpace(lines[i])
+	}
+	return []string{strings.Join(lines, " ")}
 }
 
-func healthCheck() {
-	t := time.NewTicker(time.Minute * 2)
-	for {
-		select {
-		case <-t.C:
-			log.Println("Starting heath check ...")
-			serverPool.HeadthCheck()
-			log.Println("Heath check completed")
+// parseBackendList turns the -backends flag into backend configs, splitting
+// an optional "#weight" suffix off each address.
+func parseBackendList(list string) ([]balancer.BackendConfig, error) {
+	var backends []balancer.BackendConfig
+	for _, s := range strings.Split(list, ",") {
+		u, err := url.Parse(s)
+		if err != nil {
+			return nil, err
 		}
+		weight := 1
+		if u.Fragment != "" {
+			weight, err = strconv.Atoi(u.Fragment)
+			if err != nil || weight <= 0 {
+				return nil, fmt.Errorf("invalid weight %q for backend %s", u.Fragment, s)
+			}
+			u.Fragment = ""
+			u.RawFragment = ""
+		}
+		backends = append(backends, balancer.BackendConfig{Url: u.String(), Weight: weight})
 	}
+	return backends, nil
+}
 
+// fatal logs msg at error level and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
 }
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// proxyHeaderTimeout bounds how long a client has to send its PROXY
+// protocol header.
+const proxyHeaderTimeout = 5 * time.Second
+
+// proxyV2Signature starts a binary PROXY protocol v2 header.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyListener expects every connection to start with a PROXY protocol v1
+// or v2 header, as prepended by an L4 load balancer, and reports the client
+// and destination addresses it carries as those of the connection. The
+// header is read on the first Read, RemoteAddr or LocalAddr, by the
+// goroutine serving the connection rather than in Accept. A connection
+// without a valid header is closed.
+type proxyListener struct {
+	net.Listener
+}
+
+func (l proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyConn{Conn: conn}, nil
+}
+
+// proxyConn is a connection accepted by proxyListener.
+type proxyConn struct {
+	net.Conn
+	once sync.Once
+	r    *bufio.Reader
+	//remote and local are nil for a LOCAL or UNKNOWN header, which keep the
+	//addresses of the connection, as for health checks of the L4 load balancer
+	remote, local net.Addr
+	err           error
+}
+
+// readHeader reads the PROXY protocol header, once.
+func (c *proxyConn) readHeader() error {
+	c.once.Do(func() {
+		c.r = bufio.NewReader(c.Conn)
+		c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+		c.remote, c.local, c.err = readProxyHeader(c.r)
+		c.Conn.SetReadDeadline(time.Time{})
+		if c.err != nil {
+			slog.Warn("invalid PROXY protocol header, closing connection", "peer", c.Conn.RemoteAddr().String(), "error", c.err)
+			c.Conn.Close()
+		}
+	})
+	return c.err
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	if err := c.readHeader(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.readHeader()
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyConn) LocalAddr() net.Addr {
+	c.readHeader()
+	if c.local != nil {
+		return c.local
+	}
+	return c.Conn.LocalAddr()
+}
+
+// readProxyHeader reads a PROXY protocol v1 or v2 header from r and returns
+// the source and destination addresses it carries.
+func readProxyHeader(r *bufio.Reader) (remote, local net.Addr, err error) {
+	start, err := r.Peek(len(proxyV2Signature))
+	switch {
+	case bytes.Equal(start, proxyV2Signature):
+		return readProxyV2(r)
+	case bytes.HasPrefix(start, []byte("PROXY ")):
+		return readProxyV1(r)
+	case err != nil:
+		return nil, nil, err
+	}
+	return nil, nil, errors.New("missing PROXY protocol header")
+}
+
+// readProxyV1 reads a text header such as
+// "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n", at most 107 bytes long.
+func readProxyV1(r *bufio.Reader) (remote, local net.Addr, err error) {
+	var line []byte
+	for len(line) < 107 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	text, ok := strings.CutSuffix(string(line), "\r\n")
+	if !ok {
+		return nil, nil, errors.New("PROXY v1 header not terminated by CRLF within 107 bytes")
+	}
+	fields := strings.Split(text, " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || fields[1] != "TCP4" && fields[1] != "TCP6" {
+		return nil, nil, fmt.Errorf("invalid PROXY v1 header %q", text)
+	}
+	src, err := proxyV1Addr(fields[2], fields[4], fields[1])
+	if err != nil {
+		return nil, nil, err
+	}
+	dst, err := proxyV1Addr(fields[3], fields[5], fields[1])
+	if err != nil {
+		return nil, nil, err
+	}
+	return src, dst, nil
+}
+
+func proxyV1Addr(ip, port, proto string) (*net.TCPAddr, error) {
+	addr := net.ParseIP(ip)
+	if addr == nil || (addr.To4() != nil) != (proto == "TCP4") {
+		return nil, fmt.Errorf("invalid %s address %q in PROXY v1 header", proto, ip)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q in PROXY v1 header", port)
+	}
+	return &net.TCPAddr{IP: addr, Port: int(p)}, nil
+}
+
+// readProxyV2 reads a binary header: the signature, the version and
+// command, the address family and protocol, the length of the rest and the
+// addresses, followed by TLVs that are skipped.
+func readProxyV2(r *bufio.Reader) (remote, local net.Addr, err error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, nil, err
+	}
+	if header[12]>>4 != 2 {
+		return nil, nil, fmt.Errorf("unsupported PROXY v2 version %d", header[12]>>4)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, nil, err
+	}
+	switch header[12] & 0x0f {
+	case 0x0:
+		//LOCAL, sent by the L4 load balancer itself
+		return nil, nil, nil
+	case 0x1:
+	default:
+		return nil, nil, fmt.Errorf("unsupported PROXY v2 command %d", header[12]&0x0f)
+	}
+	var size int
+	switch header[13] {
+	case 0x11, 0x12:
+		//TCP or UDP over IPv4
+		size = 4
+	case 0x21, 0x22:
+		//TCP or UDP over IPv6
+		size = 16
+	default:
+		//unix sockets and unspecified families keep the connection addresses
+		return nil, nil, nil
+	}
+	if len(body) < 2*size+4 {
+		return nil, nil, errors.New("PROXY v2 header too short for its addresses")
+	}
+	src := &net.TCPAddr{IP: net.IP(body[:size]), Port: int(binary.BigEndian.Uint16(body[2*size:]))}
+	dst := &net.TCPAddr{IP: net.IP(body[size : 2*size]), Port: int(binary.BigEndian.Uint16(body[2*size+2:]))}
+	return src, dst, nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// proxyV2 builds a PROXY protocol v2 header with the given version and
+// command byte, family and protocol byte, and body.
+func proxyV2(command, family byte, body []byte) string {
+	header := append([]byte{}, proxyV2Signature...)
+	header = append(header, command, family)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(body)))
+	return string(append(header, body...))
+}
+
+// proxyV2TCP4 is the body of a v2 TCP over IPv4 header from 192.0.2.1:56324
+// to 192.0.2.2:443.
+var proxyV2TCP4 = []byte{192, 0, 2, 1, 192, 0, 2, 2, 0xdc, 0x04, 0x01, 0xbb}
+
+func TestReadProxyHeader(t *testing.T) {
+	tests := []struct {
+		name          string
+		header        string
+		remote, local string //empty for the connection addresses
+		wantErr       string //substring of the error, if one is expected
+	}{
+		{name: "v1 TCP4", header: "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n", remote: "192.0.2.1:56324", local: "192.0.2.2:443"},
+		{name: "v1 TCP6", header: "PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n", remote: "[2001:db8::1]:56324", local: "[2001:db8::2]:443"},
+		{name: "v1 UNKNOWN", header: "PROXY UNKNOWN\r\n"},
+		{name: "v1 truncated", header: "PROXY TCP4 192.0.2.1 192.0", wantErr: "EOF"},
+		{name: "v1 without CRLF", header: "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\n", wantErr: "CRLF"},
+		{name: "v1 too long", header: "PROXY TCP4 " + strings.Repeat("1", 120) + "\r\n", wantErr: "CRLF"},
+		{name: "v1 family mismatch", header: "PROXY TCP4 2001:db8::1 192.0.2.2 56324 443\r\n", wantErr: "invalid TCP4 address"},
+		{name: "v1 bad port", header: "PROXY TCP4 192.0.2.1 192.0.2.2 65536 443\r\n", wantErr: "invalid port"},
+		{name: "v1 missing fields", header: "PROXY TCP4 192.0.2.1 192.0.2.2\r\n", wantErr: "invalid PROXY v1 header"},
+		{name: "v2 PROXY TCP4", header: proxyV2(0x21, 0x11, proxyV2TCP4), remote: "192.0.2.1:56324", local: "192.0.2.2:443"},
+		{name: "v2 PROXY with TLVs", header: proxyV2(0x21, 0x11, append(proxyV2TCP4[:12:12], 0x04, 0x00, 0x01, 0xff)), remote: "192.0.2.1:56324", local: "192.0.2.2:443"},
+		{name: "v2 LOCAL", header: proxyV2(0x20, 0x00, nil)},
+		{name: "v2 unspecified family", header: proxyV2(0x21, 0x00, nil)},
+		{name: "v2 unknown command", header: proxyV2(0x22, 0x11, proxyV2TCP4), wantErr: "unsupported PROXY v2 command 2"},
+		{name: "v2 unknown version", header: proxyV2(0x11, 0x11, proxyV2TCP4), wantErr: "unsupported PROXY v2 version 1"},
+		{name: "v2 truncated header", header: proxyV2(0x21, 0x11, nil)[:14], wantErr: "EOF"},
+		{name: "v2 truncated body", header: proxyV2(0x21, 0x11, proxyV2TCP4)[:20], wantErr: "EOF"},
+		{name: "v2 short addresses", header: proxyV2(0x21, 0x11, proxyV2TCP4[:8]), wantErr: "too short"},
+		{name: "no PROXY prefix", header: "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n", wantErr: "missing PROXY protocol header"},
+		{name: "empty", header: "", wantErr: "EOF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			remote, local, err := readProxyHeader(bufio.NewReader(strings.NewReader(tt.header)))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readProxyHeader: %v", err)
+			}
+			if got := addrString(remote); got != tt.remote {
+				t.Errorf("remote = %q, want %q", got, tt.remote)
+			}
+			if got := addrString(local); got != tt.local {
+				t.Errorf("local = %q, want %q", got, tt.local)
+			}
+		})
+	}
+}
+
+func addrString(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
+}
+
+func TestProxyConn(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go io.WriteString(client, "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\nGET / HTTP/1.1\r\n")
+	conn := &proxyConn{Conn: server}
+	defer conn.Close()
+
+	if got := conn.RemoteAddr().String(); got != "192.0.2.1:56324" {
+		t.Errorf("RemoteAddr = %s, want 192.0.2.1:56324", got)
+	}
+	if got := conn.LocalAddr().String(); got != "192.0.2.2:443" {
+		t.Errorf("LocalAddr = %s, want 192.0.2.2:443", got)
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "GET / HTTP/1.1\r\n" {
+		t.Errorf("read %q after the header, want the request line", line)
+	}
+}
+
+func TestProxyConnWithoutHeader(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go io.WriteString(client, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	conn := &proxyConn{Conn: server}
+
+	if _, err := conn.Read(make([]byte, 16)); err == nil {
+		t.Fatal("Read succeeded without a PROXY header")
+	}
+	if got, want := conn.RemoteAddr(), server.RemoteAddr(); got != want {
+		t.Errorf("RemoteAddr = %v, want the connection address %v", got, want)
+	}
+	//the connection was closed, so the client cannot write any more
+	if _, err := client.Write([]byte("x")); err == nil {
+		t.Error("client could still write to a connection without a PROXY header")
+	}
+}
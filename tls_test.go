@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCertificate writes a self-signed certificate for name and its key to
+// certFile and keyFile.
+func writeCertificate(t *testing.T, name, certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{name},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCertificateReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeCertificate(t, "first.example.com", certFile, keyFile)
+	cert, err := loadCertificate(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", serverTLSConfig(cert))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			//echo, so that connections can be checked to still work
+			go func() {
+				defer conn.Close()
+				buf := make([]byte, 1)
+				for {
+					if _, err := conn.Read(buf); err != nil {
+						return
+					}
+					conn.Write(buf)
+				}
+			}()
+		}
+	}()
+	dial := func() *tls.Conn {
+		t.Helper()
+		conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+	served := func(conn *tls.Conn) string {
+		return conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+	}
+
+	old := dial()
+	if got := served(old); got != "first.example.com" {
+		t.Fatalf("served %s, want first.example.com", got)
+	}
+	writeCertificate(t, "second.example.com", certFile, keyFile)
+	if err := cert.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got := served(dial()); got != "second.example.com" {
+		t.Errorf("new connection served %s after reload, want second.example.com", got)
+	}
+	if _, err := old.Write([]byte("x")); err != nil {
+		t.Errorf("connection established before the reload: %v", err)
+	} else if _, err := old.Read(make([]byte, 1)); err != nil {
+		t.Errorf("connection established before the reload: %v", err)
+	}
+
+	//a key not matching the certificate is refused, keeping the current one
+	writeCertificate(t, "third.example.com", certFile, filepath.Join(dir, "other.pem"))
+	if err := cert.reload(); err == nil {
+		t.Error("reload accepted a certificate with the key of another one")
+	}
+	if got := served(dial()); got != "second.example.com" {
+		t.Errorf("served %s after a failed reload, want second.example.com", got)
+	}
+}